@@ -0,0 +1,89 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Features of clients and users which can be allowed by access policy.
+const (
+	// FeatureServiceAccounts allows clients with service account (explicit or required by authorization services).
+	FeatureServiceAccounts AccessPolicyFeature = "serviceAccounts"
+	// FeatureRoles allows authorization policies based on realm or client roles, and users with assigned roles or
+	// groups.
+	FeatureRoles AccessPolicyFeature = "roles"
+	// FeaturePublicClients allows public clients (without client secret).
+	FeaturePublicClients AccessPolicyFeature = "publicClients"
+)
+
+// DefaultInstance refers to default Keycloak instance of operator in access policy.
+const DefaultInstance AccessPolicyInstance = "default"
+
+// KeycloakAccessPolicySpec defines which realms, domains and features are allowed for resources in selected namespaces.
+type KeycloakAccessPolicySpec struct {
+	// NamespaceSelector (optional) selects namespaces to which policy applies. Empty selector selects all namespaces.
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// Instances (optional) selects Keycloak instances to which policy applies: default (instance of operator),
+	// KeycloakInstance/<name> (in namespace of resource) or ClusterKeycloakInstance/<name>. Policy applies to all
+	// instances if not set.
+	Instances []AccessPolicyInstance `json:"instances,omitempty"`
+	// Realms allowed for resources in selected namespaces.
+	//+kubebuilder:validation:MinItems=1
+	Realms []string `json:"realms"`
+	// Domains (optional) are allowed domain patterns of clients, for example: app.example.com or *.team.example.com
+	// (any subdomain). Hosts of assertion consumer URLs, entity ID and JWKS URL must match as well. Any domain is
+	// allowed if not set.
+	Domains []string `json:"domains,omitempty"`
+	// Features (optional) allowed for clients and users: serviceAccounts, roles, publicClients.
+	Features []AccessPolicyFeature `json:"features,omitempty"`
+}
+
+//+kubebuilder:validation:Enum=serviceAccounts;roles;publicClients
+
+// AccessPolicyFeature is a feature of clients or users allowed by policy.
+type AccessPolicyFeature string
+
+//+kubebuilder:validation:Pattern=`^(default|(KeycloakInstance|ClusterKeycloakInstance)/.+)$`
+
+// AccessPolicyInstance refers to Keycloak instance in access policy.
+type AccessPolicyInstance string
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+
+// KeycloakAccessPolicy is the Schema for the policies restricting access of namespaces to Keycloak.
+// Once at least one policy exists, resources are allowed only in namespaces selected by policies.
+type KeycloakAccessPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec KeycloakAccessPolicySpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// KeycloakAccessPolicyList contains a list of KeycloakAccessPolicy
+type KeycloakAccessPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []KeycloakAccessPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&KeycloakAccessPolicy{}, &KeycloakAccessPolicyList{})
+}
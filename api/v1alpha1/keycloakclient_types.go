@@ -17,6 +17,9 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -30,21 +33,274 @@ type KeycloakClientSpec struct {
 
 	// Realm name.
 	Realm string `json:"realm"`
+	// InstanceRef (optional) refers to Keycloak instance. Default instance of operator is used if not set.
+	InstanceRef *InstanceReference `json:"instanceRef,omitempty"`
+	// Adoption (optional) defines if existent Keycloak client with the same name (not created for the resource) can be
+	// managed by resource: never (default), ifUnmanaged (only clients without ownership markers of other resources) or
+	// always (including clients owned by other resources or clusters).
+	//+kubebuilder:validation:Enum=never;ifUnmanaged;always
+	Adoption string `json:"adoption,omitempty"`
+	// DeletionPolicy (optional) defines what happens with Keycloak client when resource is removed: Delete, Retain
+	// (keep client, but remove ownership markers) or Disable (disable client and remove ownership markers).
+	// Default is set by operator (Delete if not configured).
+	//+kubebuilder:validation:Enum=Delete;Retain;Disable
+	DeletionPolicy string `json:"deletionPolicy,omitempty"`
 	// Domain which will be used for redirect callback.
 	Domain string `json:"domain"`
+	// Protocol (optional) of client: openid-connect (default) or saml.
+	//+kubebuilder:validation:Enum=openid-connect;saml
+	Protocol string `json:"protocol,omitempty"`
+	// SAML (optional) settings of client. Used only for saml protocol.
+	SAML *SAMLSettings `json:"saml,omitempty"`
+	// ClientAuthenticator (optional) of openid-connect client: client-secret (default) or client-jwt (private-key JWT).
+	//+kubebuilder:validation:Enum=client-secret;client-jwt
+	ClientAuthenticator string `json:"clientAuthenticator,omitempty"`
+	// JWT (optional) settings of client-jwt authenticator.
+	JWT *ClientJWTSettings `json:"jwt,omitempty"`
+	// PublicClient (optional) makes openid-connect client public (for browser or native applications), so it has no
+	// client secret. Can not be combined with client-jwt, clientSecretRef, serviceAccount or authorization.
+	PublicClient bool `json:"publicClient,omitempty"`
+	// ServiceAccount (optional) enables service account (client credentials grant) of openid-connect client. Service
+	// account is always enabled for clients with authorization.
+	ServiceAccount bool `json:"serviceAccount,omitempty"`
 	// Secret name where to store credentials. Optional, if not set - CRD name will be used.
-	// Contains: clientID, clientSecret, realm, discoveryURL, realmURL
+	// Contains for openid-connect: clientID, clientSecret, realm, discoveryURL, realmURL
+	// Contains for saml: clientID, realm, realmURL, idpMetadataURL, idpEntityID, ssoURL, signingCertificate
+	// Contains for client-jwt: privateKey, certificate and keyID instead of clientSecret
 	SecretName string `json:"secretName,omitempty"`
+	// SecretAdoption (optional) defines what to do if the target secret already exists and is not owned by any
+	// resource: refuse (default) reports SecretConflict condition, adopt takes ownership and overwrites content.
+	// Secrets owned by other resources are never adopted.
+	//+kubebuilder:validation:Enum=adopt;refuse
+	SecretAdoption string `json:"secretAdoption,omitempty"`
 	// Annotations (optional) to add to the target secret
 	Annotations map[string]string `json:"annotations,omitempty"`
 	// Labels (optional) to add to the target secret
 	Labels map[string]string `json:"labels,omitempty"`
+	// Preset (optional) of well-known consumer: sets callback URLs, protocol mappers, default scopes and additional
+	// keys of the target secret. Keys from secretTemplate have priority over preset keys.
+	//+kubebuilder:validation:Enum=oauth2-proxy;grafana;argocd;gitea
+	Preset string `json:"preset,omitempty"`
+	// GeneratedValues (optional) are random values (ex: cookie secrets) stored in the target secret. Values are
+	// generated once and preserved across reconciles.
+	GeneratedValues []GeneratedValue `json:"generatedValues,omitempty"`
+	// SecretTemplate (optional) defines additional keys of the target secret as Go text/template. Templates have access
+	// to .ClientID, .ClientSecret, .Realm, .RealmURL, .DiscoveryURL, .Domain, .Client (all Keycloak client fields)
+	// and .Data (all generated keys). Generated keys can be overridden.
+	SecretTemplate map[string]string `json:"secretTemplate,omitempty"`
+	// Rotation (optional) enables versioned secrets: each version of credentials is stored in own immutable secret
+	// <secretName>-v<version>, and the name of the current one is published in status.secretName.
+	Rotation *SecretRotation `json:"rotation,omitempty"`
+	// ClientSecretRef (optional) is a reference to a key in secret (in the same namespace) with client secret, which
+	// will be used instead of generated one. The secret is watched, so changed value will be propagated to Keycloak.
+	// Rotation does not regenerate such secret.
+	ClientSecretRef *v1.SecretKeySelector `json:"clientSecretRef,omitempty"`
+	// RestartTargets (optional) are workloads in the same namespace which will be restarted (by pod template annotation)
+	// once content of the secret changed.
+	RestartTargets []RestartTarget `json:"restartTargets,omitempty"`
+	// Authorization (optional) enables Keycloak Authorization Services for the client (and service account, required
+	// by Keycloak). Resources, scopes, policies and permissions which are not listed will be removed.
+	Authorization *ClientAuthorization `json:"authorization,omitempty"`
+}
+
+// SAMLSettings defines SAML-specific settings of client.
+type SAMLSettings struct {
+	// EntityID (optional) of service provider, used as client ID. Default is https://<domain>.
+	EntityID string `json:"entityID,omitempty"`
+	// ACSURLs (optional) are assertion consumer service URLs. First URL is used as POST binding URL.
+	// Default is https://<domain>/*.
+	ACSURLs []string `json:"acsURLs,omitempty"`
+	// NameIDFormat (optional) is one of: username, email, transient, persistent.
+	//+kubebuilder:validation:Enum=username;email;transient;persistent
+	NameIDFormat string `json:"nameIDFormat,omitempty"`
+	// SignDocuments (optional) signs SAML documents by realm key. Default is true.
+	SignDocuments *bool `json:"signDocuments,omitempty"`
+	// SignAssertions (optional) signs assertions by realm key.
+	SignAssertions bool `json:"signAssertions,omitempty"`
+	// ClientSignatureRequired (optional) requires client to sign requests.
+	ClientSignatureRequired bool `json:"clientSignatureRequired,omitempty"`
+	// EncryptAssertions (optional) encrypts assertions by client public key.
+	EncryptAssertions bool `json:"encryptAssertions,omitempty"`
+	// SignatureAlgorithm (optional), for example: RSA_SHA256 (default), RSA_SHA512.
+	SignatureAlgorithm string `json:"signatureAlgorithm,omitempty"`
+	// AttributeMappers (optional) of client. Mappers removed from the list are removed from client, mappers created
+	// outside of manifest are not touched.
+	AttributeMappers []ProtocolMapper `json:"attributeMappers,omitempty"`
+}
+
+// ClientJWTSettings defines settings of private-key JWT client authentication.
+type ClientJWTSettings struct {
+	// Algorithm (optional) of generated key pair: RS256 (default) or ES256.
+	//+kubebuilder:validation:Enum=RS256;ES256
+	Algorithm string `json:"algorithm,omitempty"`
+	// JWKSURL (optional) is a URL to client public keys. If set, key pair is not generated by operator.
+	JWKSURL string `json:"jwksURL,omitempty"`
+	// RotationPeriod (optional) after which new key pair will be generated, for example: 720h.
+	RotationPeriod *metav1.Duration `json:"rotationPeriod,omitempty"`
+}
+
+// ProtocolMapper maps user attributes, properties or roles to token claims or SAML attributes.
+type ProtocolMapper struct {
+	// Name of mapper.
+	Name string `json:"name"`
+	// Type of mapper, for example: saml-user-attribute-mapper, saml-user-property-mapper, saml-role-list-mapper.
+	Type string `json:"type"`
+	// Config (optional) of mapper, for example: user.attribute, attribute.name, friendly.name, attribute.nameformat.
+	Config map[string]string `json:"config,omitempty"`
+}
+
+// GeneratedValue defines random value in secret.
+type GeneratedValue struct {
+	// Key in secret
+	Key string `json:"key"`
+	// Length (optional) in bytes before encoding. Default is 32.
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:validation:Maximum=1024
+	Length int `json:"length,omitempty"`
+	// Encoding (optional) of value. Default is hex.
+	//+kubebuilder:validation:Enum=hex;base64;base64url
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// RestartTarget defines workloads by name or by label selector.
+type RestartTarget struct {
+	// Kind of workload
+	//+kubebuilder:validation:Enum=Deployment;StatefulSet;DaemonSet
+	Kind string `json:"kind"`
+	// Name (optional) of workload. Mutually exclusive with selector.
+	Name string `json:"name,omitempty"`
+	// Selector (optional) of workloads. Mutually exclusive with name.
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+}
+
+// SecretRotation defines rotation of client credentials.
+type SecretRotation struct {
+	// Version (optional) of credentials. Rotation is triggered when it is greater than current version
+	// (status.secretVersion).
+	Version int `json:"version,omitempty"`
+	// GracePeriod (optional) after which previous versions of secret are removed and previous credentials (client
+	// secret or key pair) become invalid. Default is 24h.
+	GracePeriod *metav1.Duration `json:"gracePeriod,omitempty"`
+	// Schedule (optional) of automatic rotation in cron format (ex: "0 3 1 */3 *").
+	Schedule string `json:"schedule,omitempty"`
+	// MaxAge (optional) of credentials after which they will be automatically rotated (ex: 2160h).
+	MaxAge *metav1.Duration `json:"maxAge,omitempty"`
+}
+
+// ClientAuthorization defines fine-grained authorization settings of client (resource server).
+type ClientAuthorization struct {
+	// PolicyEnforcementMode (optional) is one of: ENFORCING, PERMISSIVE, DISABLED. Default is ENFORCING.
+	//+kubebuilder:validation:Enum=ENFORCING;PERMISSIVE;DISABLED
+	PolicyEnforcementMode string `json:"policyEnforcementMode,omitempty"`
+	// DecisionStrategy (optional) is one of: UNANIMOUS, AFFIRMATIVE, CONSENSUS. Default is UNANIMOUS.
+	//+kubebuilder:validation:Enum=UNANIMOUS;AFFIRMATIVE;CONSENSUS
+	DecisionStrategy string `json:"decisionStrategy,omitempty"`
+	// Scopes (optional) which can be used by resources and permissions.
+	Scopes []AuthorizationScope `json:"scopes,omitempty"`
+	// Resources (optional) protected by client.
+	Resources []AuthorizationResource `json:"resources,omitempty"`
+	// Policies (optional) which can be used by permissions.
+	Policies []AuthorizationPolicy `json:"policies,omitempty"`
+	// Permissions (optional) binds resources or scopes to policies.
+	Permissions []AuthorizationPermission `json:"permissions,omitempty"`
+}
+
+type AuthorizationScope struct {
+	// Name of scope.
+	Name string `json:"name"`
+	// DisplayName (optional) of scope.
+	DisplayName string `json:"displayName,omitempty"`
+}
+
+type AuthorizationResource struct {
+	// Name of resource.
+	Name string `json:"name"`
+	// DisplayName (optional) of resource.
+	DisplayName string `json:"displayName,omitempty"`
+	// Type (optional) of resource, for example: urn:my-app:resources:default.
+	Type string `json:"type,omitempty"`
+	// URIs (optional) of resource.
+	URIs []string `json:"uris,omitempty"`
+	// Scopes (optional) names of resource.
+	Scopes []string `json:"scopes,omitempty"`
+	// OwnerManagedAccess (optional) allows resource owner to manage access.
+	OwnerManagedAccess bool `json:"ownerManagedAccess,omitempty"`
+}
+
+type AuthorizationPolicy struct {
+	// Name of policy.
+	Name string `json:"name"`
+	// Type of policy: role, group or client.
+	//+kubebuilder:validation:Enum=role;group;client
+	Type string `json:"type"`
+	// Description (optional) of policy.
+	Description string `json:"description,omitempty"`
+	// Logic (optional) is one of: POSITIVE, NEGATIVE. Default is POSITIVE.
+	//+kubebuilder:validation:Enum=POSITIVE;NEGATIVE
+	Logic string `json:"logic,omitempty"`
+	// Roles (optional) for role policy: realm role name or clientId/role for client role.
+	Roles []string `json:"roles,omitempty"`
+	// Groups (optional) for group policy as full paths (ex: /parent/child).
+	Groups []string `json:"groups,omitempty"`
+	// Clients (optional) for client policy as list of clientId.
+	Clients []string `json:"clients,omitempty"`
+}
+
+type AuthorizationPermission struct {
+	// Name of permission.
+	Name string `json:"name"`
+	// Type of permission: resource or scope.
+	//+kubebuilder:validation:Enum=resource;scope
+	Type string `json:"type"`
+	// Description (optional) of permission.
+	Description string `json:"description,omitempty"`
+	// DecisionStrategy (optional) is one of: UNANIMOUS, AFFIRMATIVE, CONSENSUS. Default is UNANIMOUS.
+	//+kubebuilder:validation:Enum=UNANIMOUS;AFFIRMATIVE;CONSENSUS
+	DecisionStrategy string `json:"decisionStrategy,omitempty"`
+	// Resources (optional) names protected by permission.
+	Resources []string `json:"resources,omitempty"`
+	// Scopes (optional) names protected by permission.
+	Scopes []string `json:"scopes,omitempty"`
+	// Policies names applied by permission.
+	Policies []string `json:"policies"`
 }
 
 // KeycloakClientStatus defines the observed state of KeycloakClient
 type KeycloakClientStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
+
+	// AuthorizationHash is a hash of authorization settings which were applied to Keycloak.
+	AuthorizationHash string `json:"authorizationHash,omitempty"`
+	// SecretVersion is a current version of credentials (only if rotation enabled).
+	SecretVersion int `json:"secretVersion,omitempty"`
+	// PendingSecretVersion is a version of credentials which is being issued (only during rotation).
+	PendingSecretVersion int `json:"pendingSecretVersion,omitempty"`
+	// SecretName is a name of secret with current credentials.
+	SecretName string `json:"secretName,omitempty"`
+	// LastRotationTime is a time when current version of credentials was issued (only if rotation enabled).
+	LastRotationTime *metav1.Time `json:"lastRotationTime,omitempty"`
+	// ProtocolMappers are names of protocol mappers managed by operator (declared by preset or manifest). Other mappers
+	// of client are never removed.
+	ProtocolMappers []string `json:"protocolMappers,omitempty"`
+	// SecretHash is a hash of the secret content which was applied to restart targets.
+	SecretHash string `json:"secretHash,omitempty"`
+	// Endpoints of realm from OpenID Connect discovery document.
+	Endpoints *RealmEndpoints `json:"endpoints,omitempty"`
+	// Conditions of client: Allowed (by access policies), SecretConflict (target secret is not owned by resource),
+	// ClientConflict (Keycloak client can not be adopted), IssuerMismatch (issuer of realm differs from public URL),
+	// RealmUnavailable (discovery document of realm can not be fetched).
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// RealmEndpoints are OpenID Connect endpoints of realm.
+type RealmEndpoints struct {
+	Issuer                string `json:"issuer,omitempty"`
+	AuthorizationEndpoint string `json:"authorizationEndpoint,omitempty"`
+	TokenEndpoint         string `json:"tokenEndpoint,omitempty"`
+	UserinfoEndpoint      string `json:"userinfoEndpoint,omitempty"`
+	JWKSURI               string `json:"jwksURI,omitempty"`
+	EndSessionEndpoint    string `json:"endSessionEndpoint,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -66,6 +322,40 @@ func (in *KeycloakClient) SecretName() string {
 	return in.Name
 }
 
+// VersionedSecretName returns name of secret for the version of credentials.
+func (in *KeycloakClient) VersionedSecretName(version int) string {
+	return fmt.Sprintf("%s-v%d", in.SecretName(), version)
+}
+
+// ClientID returns expected clientId of Keycloak client: domain for openid-connect, entity ID for saml.
+func (in *KeycloakClient) ClientID() string {
+	if !in.IsSAML() {
+		return in.Spec.Domain
+	}
+	if in.Spec.SAML != nil && in.Spec.SAML.EntityID != "" {
+		return in.Spec.SAML.EntityID
+	}
+	return "https://" + in.Spec.Domain
+}
+
+// IsSAML returns true if client uses SAML protocol.
+func (in *KeycloakClient) IsSAML() bool {
+	return in.Spec.Protocol == "saml"
+}
+
+// IsJWT returns true if client uses private-key JWT authentication.
+func (in *KeycloakClient) IsJWT() bool {
+	return !in.IsSAML() && in.Spec.ClientAuthenticator == "client-jwt"
+}
+
+// JWTAlgorithm returns algorithm of client key pair.
+func (in *KeycloakClient) JWTAlgorithm() string {
+	if in.Spec.JWT != nil && in.Spec.JWT.Algorithm != "" {
+		return in.Spec.JWT.Algorithm
+	}
+	return "RS256"
+}
+
 //+kubebuilder:object:root=true
 
 // KeycloakClientList contains a list of KeycloakClient
@@ -0,0 +1,111 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// KeycloakIdentityProviderSpec defines the desired state of KeycloakIdentityProvider
+type KeycloakIdentityProviderSpec struct {
+	// Realm name.
+	Realm string `json:"realm"`
+	// InstanceRef (optional) refers to Keycloak instance. Default instance of operator is used if not set.
+	InstanceRef *InstanceReference `json:"instanceRef,omitempty"`
+	// Adoption (optional) defines if existent identity provider with the same alias (not created for the resource) can
+	// be managed by resource: never (default), ifUnmanaged (only providers without ownership markers of other
+	// resources) or always (including providers owned by other resources or clusters).
+	//+kubebuilder:validation:Enum=never;ifUnmanaged;always
+	Adoption string `json:"adoption,omitempty"`
+	// Alias (unique name) of identity provider in realm. Optional, if not set - CRD name will be used.
+	Alias string `json:"alias,omitempty"`
+	// ProviderID is a type of provider, for example: oidc, keycloak-oidc, saml, github, google, gitlab.
+	ProviderID string `json:"providerId"`
+	// DisplayName (optional) shown on login page.
+	DisplayName string `json:"displayName,omitempty"`
+	// Enabled (optional) identity provider. Default is true.
+	Enabled *bool `json:"enabled,omitempty"`
+	// TrustEmail (optional) provided by identity provider.
+	TrustEmail bool `json:"trustEmail,omitempty"`
+	// FirstBrokerLoginFlowAlias (optional) is an alias of authentication flow triggered after first login.
+	// Keycloak uses "first broker login" if not set.
+	FirstBrokerLoginFlowAlias string `json:"firstBrokerLoginFlowAlias,omitempty"`
+	// Config (optional) is provider-specific configuration, for example: clientId, authorizationUrl, tokenUrl,
+	// singleSignOnServiceUrl.
+	Config map[string]string `json:"config,omitempty"`
+	// ClientSecretRef (optional) is a reference to a key in secret (in the same namespace) with client secret.
+	// The secret is watched, so rotated value will be propagated to Keycloak.
+	ClientSecretRef *v1.SecretKeySelector `json:"clientSecretRef,omitempty"`
+	// Mappers (optional) of identity provider. Mappers removed from the list are removed from identity provider, mappers
+	// created outside of manifest are not touched.
+	Mappers []IdentityProviderMapper `json:"mappers,omitempty"`
+}
+
+// IdentityProviderMapper maps claims or attributes from identity provider to Keycloak user.
+type IdentityProviderMapper struct {
+	// Name of mapper.
+	Name string `json:"name"`
+	// Type of mapper, for example: hardcoded-role-idp-mapper, oidc-user-attribute-idp-mapper, saml-role-idp-mapper.
+	Type string `json:"type"`
+	// Config (optional) of mapper, for example: syncMode, claim, user.attribute, role.
+	Config map[string]string `json:"config,omitempty"`
+}
+
+// KeycloakIdentityProviderStatus defines the observed state of KeycloakIdentityProvider
+type KeycloakIdentityProviderStatus struct {
+	// SecretVersion is a resource version of client secret which was applied to Keycloak.
+	SecretVersion string `json:"secretVersion,omitempty"`
+	// Mappers are names of mappers managed by operator (declared by manifest). Other mappers of identity provider are
+	// never removed.
+	Mappers []string `json:"mappers,omitempty"`
+	// Conditions of identity provider: Allowed (by access policies), IdentityProviderConflict (provider can not be
+	// adopted).
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// KeycloakIdentityProvider is the Schema for the Keycloak Identity Providers
+type KeycloakIdentityProvider struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   KeycloakIdentityProviderSpec   `json:"spec,omitempty"`
+	Status KeycloakIdentityProviderStatus `json:"status,omitempty"`
+}
+
+func (in *KeycloakIdentityProvider) Alias() string {
+	if manual := in.Spec.Alias; manual != "" {
+		return manual
+	}
+	return in.Name
+}
+
+//+kubebuilder:object:root=true
+
+// KeycloakIdentityProviderList contains a list of KeycloakIdentityProvider
+type KeycloakIdentityProviderList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []KeycloakIdentityProvider `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&KeycloakIdentityProvider{}, &KeycloakIdentityProviderList{})
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// KeycloakInstanceSpec defines connection to Keycloak instance.
+type KeycloakInstanceSpec struct {
+	// URL of Keycloak used by operator (ex: http://keycloak.keycloak.svc).
+	URL string `json:"url"`
+	// PublicURL (optional) of Keycloak used for everything written to secrets. Default is URL.
+	PublicURL string `json:"publicURL,omitempty"`
+	// RealmPublicURLs (optional) overrides public URL per realm.
+	RealmPublicURLs map[string]string `json:"realmPublicURLs,omitempty"`
+	// AuthMethod (optional) is one of: password (admin user, default), clientCredentials (service account).
+	//+kubebuilder:validation:Enum=password;clientCredentials
+	AuthMethod string `json:"authMethod,omitempty"`
+	// AuthRealm (optional) used for authorization. Default is master.
+	AuthRealm string `json:"authRealm,omitempty"`
+	// CredentialsSecret is a reference to secret with credentials: username and password keys for password method,
+	// clientID and clientSecret keys for clientCredentials method.
+	CredentialsSecret InstanceSecretReference `json:"credentialsSecret"`
+	// TLS (optional) settings.
+	TLS *InstanceTLS `json:"tls,omitempty"`
+}
+
+// InstanceSecretReference refers to secret. Namespace is used only by ClusterKeycloakInstance.
+type InstanceSecretReference struct {
+	// Name of secret
+	Name string `json:"name"`
+	// Namespace of secret (required for ClusterKeycloakInstance, ignored for KeycloakInstance)
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// InstanceTLS defines TLS settings of connection to Keycloak.
+type InstanceTLS struct {
+	// InsecureSkipVerify (optional) disables verification of Keycloak certificate.
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+	// CASecret (optional) is a reference to secret with CA bundle (ca.crt key) to verify Keycloak certificate.
+	CASecret *InstanceSecretReference `json:"caSecret,omitempty"`
+}
+
+// InstanceReference refers to KeycloakInstance (in the same namespace) or ClusterKeycloakInstance.
+type InstanceReference struct {
+	// Kind of instance. Default is KeycloakInstance.
+	//+kubebuilder:validation:Enum=KeycloakInstance;ClusterKeycloakInstance
+	Kind string `json:"kind,omitempty"`
+	// Name of instance
+	Name string `json:"name"`
+}
+
+//+kubebuilder:object:root=true
+
+// KeycloakInstance is the Schema for the Keycloak instances available in namespace
+type KeycloakInstance struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec KeycloakInstanceSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// KeycloakInstanceList contains a list of KeycloakInstance
+type KeycloakInstanceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []KeycloakInstance `json:"items"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+
+// ClusterKeycloakInstance is the Schema for the Keycloak instances available in all namespaces
+type ClusterKeycloakInstance struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec KeycloakInstanceSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ClusterKeycloakInstanceList contains a list of ClusterKeycloakInstance
+type ClusterKeycloakInstanceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterKeycloakInstance `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&KeycloakInstance{}, &KeycloakInstanceList{}, &ClusterKeycloakInstance{}, &ClusterKeycloakInstanceList{})
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// KeycloakUserSpec defines the desired state of KeycloakUser
+type KeycloakUserSpec struct {
+	// Realm name.
+	Realm string `json:"realm"`
+	// InstanceRef (optional) refers to Keycloak instance. Default instance of operator is used if not set.
+	InstanceRef *InstanceReference `json:"instanceRef,omitempty"`
+	// Adoption (optional) defines if existent Keycloak user with the same username (not created for the resource) can be
+	// managed by resource: never (default), ifUnmanaged (only users without ownership markers of other resources) or
+	// always (including users owned by other resources or clusters). Adopted user gets password from the secret and
+	// is removed together with resource.
+	//+kubebuilder:validation:Enum=never;ifUnmanaged;always
+	Adoption string `json:"adoption,omitempty"`
+	// Username in realm. Keycloak always stores usernames in lower case.
+	Username string `json:"username"`
+	// Email (optional) of user. Email set outside of manifest is kept if not defined.
+	Email string `json:"email,omitempty"`
+	// Enabled (optional) user. Default is true.
+	Enabled *bool `json:"enabled,omitempty"`
+	// Attributes (optional) of user.
+	Attributes map[string][]string `json:"attributes,omitempty"`
+	// Groups (optional) as full paths (ex: /parent/child) to join. Memberships are only added, never removed.
+	Groups []string `json:"groups,omitempty"`
+	// RealmRoles (optional) to assign to user. Roles are only added, never removed.
+	RealmRoles []string `json:"realmRoles,omitempty"`
+	// ClientRoles (optional) to assign to user: clientId -> list of role names. Roles are only added, never removed.
+	ClientRoles map[string][]string `json:"clientRoles,omitempty"`
+	// RequiredActions (optional) for user, for example: UPDATE_PASSWORD, VERIFY_EMAIL, CONFIGURE_TOTP.
+	// Actions are not touched if not defined.
+	RequiredActions []string `json:"requiredActions,omitempty"`
+	// TemporaryPassword (optional) forces user to change generated password on first login.
+	TemporaryPassword bool `json:"temporaryPassword,omitempty"`
+	// Secret name where to store credentials. Optional, if not set - CRD name will be used.
+	// Contains: username, password, realm, realmURL
+	SecretName string `json:"secretName,omitempty"`
+	// SecretAdoption (optional) defines what to do if the target secret already exists and is not owned by any
+	// resource: refuse (default) reports SecretConflict condition, adopt takes ownership and overwrites content.
+	// Password from adopted secret is kept (and set to user) if present. Secrets owned by other resources are never
+	// adopted.
+	//+kubebuilder:validation:Enum=adopt;refuse
+	SecretAdoption string `json:"secretAdoption,omitempty"`
+	// Annotations (optional) to add to the target secret
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Labels (optional) to add to the target secret
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// KeycloakUserStatus defines the observed state of KeycloakUser
+type KeycloakUserStatus struct {
+	// ID of user in Keycloak.
+	ID string `json:"id,omitempty"`
+	// PasswordSecretVersion is UID and resource version of secret which password was last set to user in Keycloak.
+	PasswordSecretVersion string `json:"passwordSecretVersion,omitempty"`
+	// Conditions of user: Allowed (by access policies), UserConflict (Keycloak user can not be adopted),
+	// SecretConflict (target secret is not owned by resource).
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// KeycloakUser is the Schema for the Keycloak Users
+type KeycloakUser struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   KeycloakUserSpec   `json:"spec,omitempty"`
+	Status KeycloakUserStatus `json:"status,omitempty"`
+}
+
+func (in *KeycloakUser) SecretName() string {
+	if manual := in.Spec.SecretName; manual != "" {
+		return manual
+	}
+	return in.Name
+}
+
+//+kubebuilder:object:root=true
+
+// KeycloakUserList contains a list of KeycloakUser
+type KeycloakUserList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []KeycloakUser `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&KeycloakUser{}, &KeycloakUserList{})
+}
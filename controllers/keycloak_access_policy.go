@@ -0,0 +1,298 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	v12 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/strings/slices"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+var ErrAccessDenied = errors.New("access denied by policy")
+
+//+kubebuilder:rbac:groups=keycloak.k8s.reddec.net,resources=keycloakaccesspolicies,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+
+// AccessPolicies enforces KeycloakAccessPolicy resources. If there are no policies, everything is allowed.
+// Otherwise, resource (client, user or identity provider) is allowed if at least one policy which selects namespace of
+// the resource allows it.
+type AccessPolicies struct {
+	Client client.Reader
+}
+
+// accessRequest describes what resource needs from Keycloak.
+type accessRequest struct {
+	Namespace string
+	// Instance of Keycloak as it is referred in policies.
+	Instance keycloakv1alpha1.AccessPolicyInstance
+	Realm    string
+	// Domain of client. Empty for resources without domain.
+	Domain string
+	// Hosts of external URLs of client: assertion consumer service URLs, entity ID (if it's URL) and JWKS URL.
+	Hosts    []string
+	Features []keycloakv1alpha1.AccessPolicyFeature
+}
+
+// Check returns error wrapping ErrAccessDenied if resource is not allowed by policies.
+func (ap *AccessPolicies) Check(ctx context.Context, m client.Object) error {
+	if ap == nil {
+		return nil
+	}
+	req, err := accessRequestOf(m)
+	if err != nil {
+		return err
+	}
+	var list keycloakv1alpha1.KeycloakAccessPolicyList
+	if err := ap.Client.List(ctx, &list); err != nil {
+		return fmt.Errorf("list access policies: %w", err)
+	}
+	if len(list.Items) == 0 {
+		return nil
+	}
+	var namespace v12.Namespace
+	if err := ap.Client.Get(ctx, types.NamespacedName{Name: req.Namespace}, &namespace); err != nil {
+		return fmt.Errorf("get namespace %s: %w", req.Namespace, err)
+	}
+
+	var violations []string
+	for _, policy := range list.Items {
+		selected, err := selectsNamespace(&policy.Spec, &namespace)
+		if err != nil {
+			return fmt.Errorf("policy %s: %w", policy.Name, err)
+		}
+		if !selected || !selectsInstance(&policy.Spec, req.Instance) {
+			continue
+		}
+		violation := policyViolation(&policy.Spec, req)
+		if violation == "" {
+			return nil
+		}
+		violations = append(violations, policy.Name+": "+violation)
+	}
+	if len(violations) == 0 {
+		return fmt.Errorf("%w: namespace %s and instance %s are not selected by any policy", ErrAccessDenied, req.Namespace, req.Instance)
+	}
+	return fmt.Errorf("%w: %s", ErrAccessDenied, strings.Join(violations, "; "))
+}
+
+func selectsNamespace(spec *keycloakv1alpha1.KeycloakAccessPolicySpec, namespace *v12.Namespace) (bool, error) {
+	if spec.NamespaceSelector == nil {
+		return true, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(spec.NamespaceSelector)
+	if err != nil {
+		return false, fmt.Errorf("parse namespace selector: %w", err)
+	}
+	return selector.Matches(labels.Set(namespace.Labels)), nil
+}
+
+// selectsInstance returns true if policy applies to instance. Policy without instances applies to all of them.
+func selectsInstance(spec *keycloakv1alpha1.KeycloakAccessPolicySpec, instance keycloakv1alpha1.AccessPolicyInstance) bool {
+	if len(spec.Instances) == 0 {
+		return true
+	}
+	for _, selected := range spec.Instances {
+		if selected == instance {
+			return true
+		}
+	}
+	return false
+}
+
+// accessRequestOf returns what resource requires from Keycloak.
+func accessRequestOf(obj client.Object) (accessRequest, error) {
+	switch m := obj.(type) {
+	case *keycloakv1alpha1.KeycloakClient:
+		return accessRequest{Namespace: m.Namespace, Instance: policyInstance(m.Spec.InstanceRef), Realm: m.Spec.Realm, Domain: m.Spec.Domain, Hosts: clientHosts(m), Features: clientFeatures(m)}, nil
+	case *keycloakv1alpha1.KeycloakUser:
+		return accessRequest{Namespace: m.Namespace, Instance: policyInstance(m.Spec.InstanceRef), Realm: m.Spec.Realm, Features: userFeatures(m)}, nil
+	case *keycloakv1alpha1.KeycloakIdentityProvider:
+		return accessRequest{Namespace: m.Namespace, Instance: policyInstance(m.Spec.InstanceRef), Realm: m.Spec.Realm}, nil
+	}
+	return accessRequest{}, fmt.Errorf("access policies are not supported for %T", obj)
+}
+
+// policyInstance returns how instance is referred in policies.
+func policyInstance(ref *keycloakv1alpha1.InstanceReference) keycloakv1alpha1.AccessPolicyInstance {
+	if ref == nil {
+		return keycloakv1alpha1.DefaultInstance
+	}
+	kind := ref.Kind
+	if kind != clusterInstanceKind {
+		kind = "KeycloakInstance"
+	}
+	return keycloakv1alpha1.AccessPolicyInstance(kind + "/" + ref.Name)
+}
+
+// policyViolation returns reason why request is not allowed by policy or empty string. Domains are checked only for
+// requests with domain, hosts of external URLs are checked against the same patterns.
+func policyViolation(spec *keycloakv1alpha1.KeycloakAccessPolicySpec, req accessRequest) string {
+	if !slices.Contains(spec.Realms, req.Realm) {
+		return fmt.Sprintf("realm %q is not allowed", req.Realm)
+	}
+	if req.Domain != "" && len(spec.Domains) > 0 && !matchDomains(spec.Domains, req.Domain) {
+		return fmt.Sprintf("domain %q is not allowed", req.Domain)
+	}
+	for _, host := range req.Hosts {
+		if len(spec.Domains) > 0 && !matchDomains(spec.Domains, host) {
+			return fmt.Sprintf("host %q is not allowed", host)
+		}
+	}
+	for _, feature := range req.Features {
+		if !hasFeature(spec.Features, feature) {
+			return fmt.Sprintf("feature %s is not allowed", feature)
+		}
+	}
+	return ""
+}
+
+// matchDomains checks domain against patterns: exact domain or *.domain for any subdomain.
+func matchDomains(patterns []string, domain string) bool {
+	domain = strings.ToLower(domain)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok && strings.HasSuffix(domain, "."+suffix) {
+			return true
+		}
+		if pattern == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// clientHosts returns hosts of external URLs which are set to Keycloak client besides domain. URLs without host
+// (relative URLs, URN as entity ID) are skipped, invalid URLs are returned as is, so they will not match any domain.
+func clientHosts(m *keycloakv1alpha1.KeycloakClient) []string {
+	var urls []string
+	if saml := m.Spec.SAML; saml != nil {
+		urls = append(urls, saml.ACSURLs...)
+		urls = append(urls, saml.EntityID)
+	}
+	if jwt := m.Spec.JWT; jwt != nil {
+		urls = append(urls, jwt.JWKSURL)
+	}
+	var hosts []string
+	for _, raw := range urls {
+		if raw == "" {
+			continue
+		}
+		u, err := url.Parse(raw)
+		if err != nil {
+			hosts = append(hosts, raw)
+			continue
+		}
+		if u.Host != "" {
+			hosts = append(hosts, u.Hostname())
+		}
+	}
+	return hosts
+}
+
+// clientFeatures returns features used by client. Service account and public access are derived from the same flags
+// which are set to Keycloak client.
+func clientFeatures(m *keycloakv1alpha1.KeycloakClient) []keycloakv1alpha1.AccessPolicyFeature {
+	var features []keycloakv1alpha1.AccessPolicyFeature
+	serviceAccount, public := clientFlags(m.Spec)
+	if serviceAccount {
+		features = append(features, keycloakv1alpha1.FeatureServiceAccounts)
+	}
+	if public {
+		features = append(features, keycloakv1alpha1.FeaturePublicClients)
+	}
+	if authz := m.Spec.Authorization; authz != nil {
+		for _, policy := range authz.Policies {
+			if policy.Type == "role" {
+				features = append(features, keycloakv1alpha1.FeatureRoles)
+				break
+			}
+		}
+	}
+	return features
+}
+
+// userFeatures returns features used by user: assigned roles and group memberships (groups may carry roles).
+func userFeatures(m *keycloakv1alpha1.KeycloakUser) []keycloakv1alpha1.AccessPolicyFeature {
+	if len(m.Spec.RealmRoles) == 0 && len(m.Spec.ClientRoles) == 0 && len(m.Spec.Groups) == 0 {
+		return nil
+	}
+	return []keycloakv1alpha1.AccessPolicyFeature{keycloakv1alpha1.FeatureRoles}
+}
+
+func hasFeature(features []keycloakv1alpha1.AccessPolicyFeature, feature keycloakv1alpha1.AccessPolicyFeature) bool {
+	for _, f := range features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAccess enforces access policies and reports result as Allowed condition. Returns false if client is denied.
+func (r *KeycloakClientReconciler) checkAccess(ctx context.Context, m *keycloakv1alpha1.KeycloakClient) (bool, error) {
+	return enforceAccess(ctx, r.Policies, r.Status(), r.Recorder, m, &m.Status.Conditions)
+}
+
+// checkAccess enforces access policies and reports result as Allowed condition. Returns false if user is denied.
+func (r *KeycloakUserReconciler) checkAccess(ctx context.Context, m *keycloakv1alpha1.KeycloakUser) (bool, error) {
+	return enforceAccess(ctx, r.Policies, r.Status(), r.Recorder, m, &m.Status.Conditions)
+}
+
+// checkAccess enforces access policies and reports result as Allowed condition. Returns false if identity provider is
+// denied.
+func (r *KeycloakIdentityProviderReconciler) checkAccess(ctx context.Context, m *keycloakv1alpha1.KeycloakIdentityProvider) (bool, error) {
+	return enforceAccess(ctx, r.Policies, r.Status(), r.Recorder, m, &m.Status.Conditions)
+}
+
+// enforceAccess checks resource against policies and saves result as Allowed condition in conditions of resource.
+// Event is emitted once access is denied.
+func enforceAccess(ctx context.Context, policies *AccessPolicies, status client.StatusWriter, recorder record.EventRecorder, m client.Object, conditions *[]metav1.Condition) (bool, error) {
+	err := policies.Check(ctx, m)
+	if err != nil && !errors.Is(err, ErrAccessDenied) {
+		return false, err
+	}
+	condition := metav1.Condition{
+		Type:   conditionAllowed,
+		Status: metav1.ConditionTrue,
+		Reason: "Allowed",
+	}
+	if err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "AccessDenied"
+		condition.Message = err.Error()
+	}
+	changed, updateErr := setStatusCondition(ctx, status, m, conditions, condition)
+	if updateErr != nil {
+		return false, updateErr
+	}
+	if changed && err != nil {
+		recorder.Event(m, v12.EventTypeWarning, "AccessDenied", err.Error())
+	}
+	return err == nil, nil
+}
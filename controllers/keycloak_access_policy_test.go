@@ -0,0 +1,252 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v12 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+func TestMatchDomains(t *testing.T) {
+	patterns := []string{"app.example.com", "*.team.example.com"}
+	cases := []struct {
+		domain string
+		match  bool
+	}{
+		{"app.example.com", true},
+		{"APP.Example.com", true},
+		{"other.example.com", false},
+		{"x.team.example.com", true},
+		{"a.b.team.example.com", true},
+		{"team.example.com", false},
+		{"evilteam.example.com", false},
+		{"app.example.com.evil.com", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		t.Run(c.domain, func(t *testing.T) {
+			assert.Equal(t, c.match, matchDomains(patterns, c.domain))
+		})
+	}
+}
+
+func TestPolicyViolation(t *testing.T) {
+	spec := &keycloakv1alpha1.KeycloakAccessPolicySpec{
+		Realms:   []string{"team"},
+		Domains:  []string{"*.team.example.com"},
+		Features: []keycloakv1alpha1.AccessPolicyFeature{keycloakv1alpha1.FeatureServiceAccounts},
+	}
+	cases := []struct {
+		name      string
+		spec      *keycloakv1alpha1.KeycloakAccessPolicySpec
+		req       accessRequest
+		violation string
+	}{
+		{
+			name: "allowed",
+			spec: spec,
+			req:  accessRequest{Realm: "team", Domain: "app.team.example.com"},
+		},
+		{
+			name:      "realm",
+			spec:      spec,
+			req:       accessRequest{Realm: "master", Domain: "app.team.example.com"},
+			violation: `realm "master" is not allowed`,
+		},
+		{
+			name:      "domain",
+			spec:      spec,
+			req:       accessRequest{Realm: "team", Domain: "app.example.com"},
+			violation: `domain "app.example.com" is not allowed`,
+		},
+		{
+			name: "no domain",
+			spec: spec,
+			req:  accessRequest{Realm: "team"},
+		},
+		{
+			name: "any domain",
+			spec: &keycloakv1alpha1.KeycloakAccessPolicySpec{Realms: []string{"team"}},
+			req:  accessRequest{Realm: "team", Domain: "app.example.com"},
+		},
+		{
+			name: "allowed feature",
+			spec: spec,
+			req:  accessRequest{Realm: "team", Features: []keycloakv1alpha1.AccessPolicyFeature{keycloakv1alpha1.FeatureServiceAccounts}},
+		},
+		{
+			name:      "host",
+			spec:      spec,
+			req:       accessRequest{Realm: "team", Domain: "app.team.example.com", Hosts: []string{"app.team.example.com", "evil.com"}},
+			violation: `host "evil.com" is not allowed`,
+		},
+		{
+			name:      "feature",
+			spec:      spec,
+			req:       accessRequest{Realm: "team", Features: []keycloakv1alpha1.AccessPolicyFeature{keycloakv1alpha1.FeatureRoles}},
+			violation: "feature roles is not allowed",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.violation, policyViolation(c.spec, c.req))
+		})
+	}
+}
+
+func TestAccessRequestOf(t *testing.T) {
+	user := &keycloakv1alpha1.KeycloakUser{Spec: keycloakv1alpha1.KeycloakUserSpec{Realm: "team"}}
+	req, err := accessRequestOf(user)
+	assert.NoError(t, err)
+	assert.Empty(t, req.Features)
+
+	user.Spec.RealmRoles = []string{"admin"}
+	req, err = accessRequestOf(user)
+	assert.NoError(t, err)
+	assert.Equal(t, []keycloakv1alpha1.AccessPolicyFeature{keycloakv1alpha1.FeatureRoles}, req.Features)
+
+	idp := &keycloakv1alpha1.KeycloakIdentityProvider{Spec: keycloakv1alpha1.KeycloakIdentityProviderSpec{Realm: "team"}}
+	req, err = accessRequestOf(idp)
+	assert.NoError(t, err)
+	assert.Equal(t, "team", req.Realm)
+	assert.Empty(t, req.Domain)
+}
+
+func TestClientFeatures(t *testing.T) {
+	const (
+		accounts = keycloakv1alpha1.FeatureServiceAccounts
+		roles    = keycloakv1alpha1.FeatureRoles
+		public   = keycloakv1alpha1.FeaturePublicClients
+	)
+	rolePolicy := &keycloakv1alpha1.ClientAuthorization{Policies: []keycloakv1alpha1.AuthorizationPolicy{{Name: "admins", Type: "role"}}}
+	cases := []struct {
+		name     string
+		spec     keycloakv1alpha1.KeycloakClientSpec
+		features []keycloakv1alpha1.AccessPolicyFeature
+	}{
+		{name: "confidential"},
+		{name: "service account", spec: keycloakv1alpha1.KeycloakClientSpec{ServiceAccount: true}, features: []keycloakv1alpha1.AccessPolicyFeature{accounts}},
+		{name: "authorization", spec: keycloakv1alpha1.KeycloakClientSpec{Authorization: &keycloakv1alpha1.ClientAuthorization{}}, features: []keycloakv1alpha1.AccessPolicyFeature{accounts}},
+		{name: "role policies", spec: keycloakv1alpha1.KeycloakClientSpec{Authorization: rolePolicy}, features: []keycloakv1alpha1.AccessPolicyFeature{accounts, roles}},
+		{name: "public", spec: keycloakv1alpha1.KeycloakClientSpec{PublicClient: true}, features: []keycloakv1alpha1.AccessPolicyFeature{public}},
+		{name: "saml has no service account", spec: keycloakv1alpha1.KeycloakClientSpec{Protocol: "saml", ServiceAccount: true}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.features, clientFeatures(&keycloakv1alpha1.KeycloakClient{Spec: c.spec}))
+		})
+	}
+}
+
+func TestAccessPolicies_Check_instances(t *testing.T) {
+	objects := []keycloakv1alpha1.KeycloakAccessPolicy{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "shared"},
+			Spec: keycloakv1alpha1.KeycloakAccessPolicySpec{
+				Instances: []keycloakv1alpha1.AccessPolicyInstance{keycloakv1alpha1.DefaultInstance, "ClusterKeycloakInstance/shared"},
+				Realms:    []string{"team"},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "own"},
+			Spec: keycloakv1alpha1.KeycloakAccessPolicySpec{
+				Instances: []keycloakv1alpha1.AccessPolicyInstance{"KeycloakInstance/own"},
+				Realms:    []string{"team", "master"},
+			},
+		},
+	}
+	builder := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(&v12.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team"}})
+	for i := range objects {
+		builder = builder.WithObjects(&objects[i])
+	}
+	policies := &AccessPolicies{Client: builder.Build()}
+
+	cases := []struct {
+		realm   string
+		ref     *keycloakv1alpha1.InstanceReference
+		allowed bool
+	}{
+		{realm: "team", allowed: true},
+		{realm: "master"},
+		{realm: "team", ref: &keycloakv1alpha1.InstanceReference{Kind: "ClusterKeycloakInstance", Name: "shared"}, allowed: true},
+		{realm: "team", ref: &keycloakv1alpha1.InstanceReference{Kind: "ClusterKeycloakInstance", Name: "other"}},
+		{realm: "master", ref: &keycloakv1alpha1.InstanceReference{Name: "own"}, allowed: true},
+		{realm: "master", ref: &keycloakv1alpha1.InstanceReference{Kind: "KeycloakInstance", Name: "own"}, allowed: true},
+	}
+	for _, c := range cases {
+		instance := policyInstance(c.ref)
+		t.Run(string(instance)+" "+c.realm, func(t *testing.T) {
+			user := &keycloakv1alpha1.KeycloakUser{
+				ObjectMeta: metav1.ObjectMeta{Name: "robot", Namespace: "team"},
+				Spec:       keycloakv1alpha1.KeycloakUserSpec{Realm: c.realm, InstanceRef: c.ref},
+			}
+			err := policies.Check(context.Background(), user)
+			if c.allowed {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrAccessDenied)
+			}
+		})
+	}
+}
+
+func TestAccessPolicies_Check_hosts(t *testing.T) {
+	policy := &keycloakv1alpha1.KeycloakAccessPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "team"},
+		Spec:       keycloakv1alpha1.KeycloakAccessPolicySpec{Realms: []string{"team"}, Domains: []string{"*.team.example.com"}},
+	}
+	policies := &AccessPolicies{Client: fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(
+		&v12.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team"}},
+		policy,
+	).Build()}
+
+	cases := []struct {
+		name    string
+		saml    *keycloakv1alpha1.SAMLSettings
+		jwt     *keycloakv1alpha1.ClientJWTSettings
+		allowed bool
+	}{
+		{name: "saml", saml: &keycloakv1alpha1.SAMLSettings{EntityID: "urn:app", ACSURLs: []string{"https://app.team.example.com/saml/acs", "/saml/acs"}}, allowed: true},
+		{name: "assertion consumer of other host", saml: &keycloakv1alpha1.SAMLSettings{ACSURLs: []string{"https://app.team.example.com/saml/acs", "https://evil.com/saml/acs"}}},
+		{name: "entity ID of other host", saml: &keycloakv1alpha1.SAMLSettings{EntityID: "https://evil.com/saml"}},
+		{name: "invalid assertion consumer", saml: &keycloakv1alpha1.SAMLSettings{ACSURLs: []string{"https://evil.com:port/saml/acs"}}},
+		{name: "jwks", jwt: &keycloakv1alpha1.ClientJWTSettings{JWKSURL: "https://app.team.example.com/jwks"}, allowed: true},
+		{name: "jwks of other host", jwt: &keycloakv1alpha1.ClientJWTSettings{JWKSURL: "https://evil.com/jwks"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := &keycloakv1alpha1.KeycloakClient{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "team"}}
+			m.Spec.Realm = "team"
+			m.Spec.Domain = "app.team.example.com"
+			m.Spec.SAML = c.saml
+			m.Spec.JWT = c.jwt
+			err := policies.Check(context.Background(), m)
+			if c.allowed {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrAccessDenied)
+			}
+		})
+	}
+}
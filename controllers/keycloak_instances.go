@@ -0,0 +1,174 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/reddec/keycloak-ext-operator/internal"
+	v12 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+const clusterInstanceKind = "ClusterKeycloakInstance"
+
+var ErrNoDefaultInstance = errors.New("default Keycloak instance is not configured, instanceRef is required")
+
+//+kubebuilder:rbac:groups=keycloak.k8s.reddec.net,resources=keycloakinstances;clusterkeycloakinstances,verbs=get;list;watch
+
+// Instances resolves Keycloak instances referenced by resources. Resources without reference use default instance
+// (configured by environment). Resolved instances are kept in pool, so authorized sessions are re-used.
+type Instances struct {
+	Client  client.Client
+	Default *internal.Keycloak
+	// DefaultCredentials (optional) overrides credentials of default instance. Credentials are read on each resolve
+	// and the session is replaced once they changed.
+	DefaultCredentials internal.CredentialsSource
+	Pool               internal.Pool
+}
+
+// SecretCredentials reads admin credentials (KEYCLOAK_USER and KEYCLOAK_PASSWORD keys) from secret.
+// Secrets are served from the manager cache, so changes are picked up without restart.
+type SecretCredentials struct {
+	Client client.Client
+	Name   types.NamespacedName
+}
+
+func (sc *SecretCredentials) Credentials(ctx context.Context) (string, string, error) {
+	var secret v12.Secret
+	if err := sc.Client.Get(ctx, sc.Name, &secret); err != nil {
+		return "", "", fmt.Errorf("get credentials secret %s: %w", sc.Name, err)
+	}
+	return string(secret.Data[internal.CredentialsUserKey]), string(secret.Data[internal.CredentialsPasswordKey]), nil
+}
+
+// Resolve Keycloak instance by reference from resource in namespace.
+func (in *Instances) Resolve(ctx context.Context, namespace string, ref *keycloakv1alpha1.InstanceReference) (*internal.Keycloak, error) {
+	if ref == nil {
+		if in.Default == nil {
+			return nil, ErrNoDefaultInstance
+		}
+		if in.DefaultCredentials == nil {
+			return in.Default, nil
+		}
+		return in.defaultInstance(ctx)
+	}
+
+	var spec keycloakv1alpha1.KeycloakInstanceSpec
+	var key string
+	secretNamespace := namespace
+	if ref.Kind == clusterInstanceKind {
+		var instance keycloakv1alpha1.ClusterKeycloakInstance
+		if err := in.Client.Get(ctx, types.NamespacedName{Name: ref.Name}, &instance); err != nil {
+			return nil, fmt.Errorf("get cluster instance %s: %w", ref.Name, err)
+		}
+		spec = instance.Spec
+		key = instanceKey(namespace, ref)
+		secretNamespace = "" // namespace of secrets must be set explicitly
+	} else {
+		var instance keycloakv1alpha1.KeycloakInstance
+		if err := in.Client.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: namespace}, &instance); err != nil {
+			return nil, fmt.Errorf("get instance %s: %w", ref.Name, err)
+		}
+		spec = *instance.Spec.DeepCopy()
+		key = instanceKey(namespace, ref)
+		// namespaced instance can use secrets only from own namespace
+		spec.CredentialsSecret.Namespace = ""
+		if spec.TLS != nil && spec.TLS.CASecret != nil {
+			spec.TLS.CASecret.Namespace = ""
+		}
+	}
+
+	cfg, err := in.instanceConfig(ctx, secretNamespace, spec)
+	if err != nil {
+		return nil, fmt.Errorf("instance %s: %w", key, err)
+	}
+	return in.Pool.Get(key, cfg)
+}
+
+// defaultInstance returns default instance with current credentials. Once credentials changed, new instance (and
+// session) atomically replaces previous one in pool.
+func (in *Instances) defaultInstance(ctx context.Context) (*internal.Keycloak, error) {
+	user, password, err := in.DefaultCredentials.Credentials(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("default instance: %w", err)
+	}
+	if user == "" || password == "" {
+		return nil, fmt.Errorf("default instance: empty credentials")
+	}
+	cfg := *in.Default
+	cfg.User, cfg.Password = user, password
+	return in.Pool.Get("default", cfg)
+}
+
+func (in *Instances) instanceConfig(ctx context.Context, namespace string, spec keycloakv1alpha1.KeycloakInstanceSpec) (internal.Keycloak, error) {
+	cfg := internal.Keycloak{
+		URL:             spec.URL,
+		PublicURL:       spec.PublicURL,
+		RealmPublicURLs: spec.RealmPublicURLs,
+		AuthMethod:      spec.AuthMethod,
+		AuthRealm:       spec.AuthRealm,
+	}
+	userKey, passwordKey := "username", "password"
+	if spec.AuthMethod == internal.AuthClientCredentials {
+		userKey, passwordKey = "clientID", "clientSecret"
+	}
+	credentials, err := in.secretData(ctx, namespace, spec.CredentialsSecret, userKey, passwordKey)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.User, cfg.Password = credentials[userKey], credentials[passwordKey]
+
+	if tls := spec.TLS; tls != nil {
+		cfg.InsecureSkipVerify = tls.InsecureSkipVerify
+		if tls.CASecret != nil {
+			ca, err := in.secretData(ctx, namespace, *tls.CASecret, "ca.crt")
+			if err != nil {
+				return cfg, err
+			}
+			cfg.CACertificate = ca["ca.crt"]
+		}
+	}
+	return cfg, nil
+}
+
+func (in *Instances) secretData(ctx context.Context, namespace string, ref keycloakv1alpha1.InstanceSecretReference, keys ...string) (map[string]string, error) {
+	if ref.Namespace != "" {
+		namespace = ref.Namespace
+	}
+	if namespace == "" {
+		return nil, fmt.Errorf("namespace of secret %s is not set", ref.Name)
+	}
+	var secret v12.Secret
+	if err := in.Client.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: namespace}, &secret); err != nil {
+		return nil, fmt.Errorf("get secret %s: %w", ref.Name, err)
+	}
+	var ans = make(map[string]string, len(keys))
+	for _, key := range keys {
+		value, ok := secret.Data[key]
+		if !ok {
+			return nil, fmt.Errorf("key %q not found in secret %s", key, ref.Name)
+		}
+		ans[key] = string(value)
+	}
+	return ans, nil
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/reddec/keycloak-ext-operator/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v12 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestInstances_Resolve_defaultCredentials(t *testing.T) {
+	secret := &v12.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "keycloak-admin", Namespace: "system"},
+		Data: map[string][]byte{
+			internal.CredentialsUserKey:     []byte("admin"),
+			internal.CredentialsPasswordKey: []byte("initial"),
+		},
+	}
+	k8s := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(secret).Build()
+	instances := &Instances{
+		Client:             k8s,
+		Default:            &internal.Keycloak{URL: "https://keycloak.example.com"},
+		DefaultCredentials: &SecretCredentials{Client: k8s, Name: types.NamespacedName{Name: "keycloak-admin", Namespace: "system"}},
+	}
+	ctx := context.Background()
+	first, err := instances.Resolve(ctx, "default", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "initial", first.Password)
+
+	steps := []struct {
+		name     string
+		password string
+		reused   bool
+		invalid  bool
+	}{
+		{name: "unchanged", password: "initial", reused: true},
+		{name: "rotated", password: "rotated"},
+		{name: "rotated again", password: "rotated-2"},
+		{name: "empty", password: "", invalid: true},
+	}
+	previous := first
+	for _, step := range steps {
+		secret.Data[internal.CredentialsPasswordKey] = []byte(step.password)
+		require.NoError(t, k8s.Update(ctx, secret))
+
+		instance, err := instances.Resolve(ctx, "default", nil)
+		if step.invalid {
+			assert.Error(t, err, step.name)
+			continue
+		}
+		require.NoError(t, err, step.name)
+		assert.Equal(t, step.password, instance.Password, step.name)
+		assert.Equal(t, step.reused, instance == previous, step.name)
+		previous = instance
+	}
+	assert.Empty(t, instances.Default.Password, "default configuration is not modified")
+
+	require.NoError(t, k8s.Delete(ctx, secret))
+	_, err = instances.Resolve(ctx, "default", nil)
+	assert.Error(t, err, "missing secret")
+}
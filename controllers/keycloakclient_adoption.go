@@ -0,0 +1,140 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/reddec/keycloak-ext-operator/internal"
+	v12 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+const (
+	adoptionNever  = "never"
+	adoptionAlways = "always"
+)
+
+var ErrAdoptionRefused = errors.New("adoption refused")
+
+// resourceOwner returns ownership markers of resource in cluster.
+func resourceOwner(cluster string, m metav1.Object) internal.Owner {
+	return internal.Owner{
+		Cluster:   cluster,
+		Namespace: m.GetNamespace(),
+		Name:      m.GetName(),
+		UID:       string(m.GetUID()),
+	}
+}
+
+// ownedBy returns true if ownership markers refer to the expected owner. Markers without cluster identifier (set before
+// it was configured) are matched by UID only.
+func ownedBy(markers internal.Owner, expected internal.Owner) bool {
+	if markers.Cluster != "" {
+		return markers.Is(expected)
+	}
+	return markers.UID == expected.UID
+}
+
+// refuseAdoption returns error if existent Keycloak object, not owned by resource, can not be adopted according to
+// adoption policy. Kind and name are used only for the message.
+func refuseAdoption(kind, name, adoption string, markers internal.Owner, managed bool) error {
+	switch {
+	case adoption == adoptionNever:
+		return fmt.Errorf("%w: %s %q already exists and adoption is disabled", ErrAdoptionRefused, kind, name)
+	case managed && adoption != adoptionAlways:
+		return fmt.Errorf("%w: %s %q is owned by %s", ErrAdoptionRefused, kind, name, markers)
+	}
+	return nil
+}
+
+// owner returns ownership markers of resource.
+func (r *KeycloakClientReconciler) owner(m *keycloakv1alpha1.KeycloakClient) internal.Owner {
+	return resourceOwner(r.ClusterID, m)
+}
+
+// owns returns true if client is marked as owned by resource in this cluster. Clients without markers (created before
+// markers were introduced) are owned if their ID is UID of resource. UID alone is not enough for clients marked with
+// cluster identifier: clusters restored from the same backup share UIDs.
+func (r *KeycloakClientReconciler) owns(info *internal.ClientDetails, m *keycloakv1alpha1.KeycloakClient) bool {
+	if owner, ok := info.Owner(); ok {
+		return ownedBy(owner, r.owner(m))
+	}
+	return info.ID == string(m.UID)
+}
+
+// skipForeign returns true if client found by name belongs to another cluster and own client should be created
+// instead. It is possible only if clientId is prefixed by cluster, otherwise clientId will be the same.
+func (r *KeycloakClientReconciler) skipForeign(info *internal.ClientDetails, m *keycloakv1alpha1.KeycloakClient) bool {
+	if !r.PrefixClientID || m.IsSAML() || info.ID == string(m.UID) || m.Spec.Adoption == adoptionAlways {
+		return false
+	}
+	owner, ok := info.Owner()
+	return ok && owner.Cluster != r.ClusterID
+}
+
+// checkAdoption checks if existent client (found by name, or by ID but owned by another cluster) can be managed by
+// resource according to spec.adoption. Like users, clients are never adopted by default. It includes clients which
+// older versions of operator took over by name (no markers, ID is not UID of resource): they require ifUnmanaged after
+// upgrade.
+func (r *KeycloakClientReconciler) checkAdoption(info *internal.ClientDetails, m *keycloakv1alpha1.KeycloakClient) error {
+	if r.owns(info, m) {
+		return nil
+	}
+	adoption := m.Spec.Adoption
+	if adoption == "" {
+		adoption = adoptionNever
+	}
+	owner, managed := info.Owner()
+	if err := refuseAdoption("client", info.ClientID, adoption, owner, managed); err != nil {
+		return err
+	}
+	log.Log.Info("Existent client will be adopted", "client_id", info.ClientID)
+	return nil
+}
+
+// conflictCondition of type set if conflict is not nil, or cleared otherwise.
+func conflictCondition(conditionType string, conflict error) metav1.Condition {
+	condition := metav1.Condition{
+		Type:   conditionType,
+		Status: metav1.ConditionFalse,
+		Reason: "Owned",
+	}
+	if conflict != nil {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "AdoptionRefused"
+		condition.Message = conflict.Error()
+	}
+	return condition
+}
+
+// reportClientConflict sets ClientConflict condition (if conflict is not nil) or clears it.
+func (r *KeycloakClientReconciler) reportClientConflict(ctx context.Context, m *keycloakv1alpha1.KeycloakClient, conflict error) error {
+	changed, err := r.setCondition(ctx, m, conflictCondition(conditionClientConflict, conflict))
+	if err != nil {
+		return err
+	}
+	if changed && conflict != nil {
+		r.Recorder.Event(m, v12.EventTypeWarning, "ClientConflict", conflict.Error())
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	"github.com/reddec/keycloak-ext-operator/internal"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+func TestKeycloakClientReconciler_owns(t *testing.T) {
+	m := &keycloakv1alpha1.KeycloakClient{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", UID: "uid-1"}}
+	cases := []struct {
+		name    string
+		cluster string
+		id      string
+		markers *internal.Owner
+		owns    bool
+	}{
+		{name: "created for resource", id: "uid-1", owns: true},
+		{name: "unmanaged", id: "other"},
+		{name: "marked", id: "other", markers: &internal.Owner{Namespace: "default", Name: "app", UID: "uid-1"}, owns: true},
+		{name: "marked by another resource", id: "other", markers: &internal.Owner{Namespace: "default", Name: "app", UID: "uid-2"}},
+		{name: "marked by this cluster", cluster: "c1", id: "other", markers: &internal.Owner{Cluster: "c1", UID: "uid-1"}, owns: true},
+		{name: "marked by another cluster with the same UID", cluster: "c1", id: "uid-1", markers: &internal.Owner{Cluster: "c2", UID: "uid-1"}},
+		{name: "marked before cluster ID was configured", cluster: "c1", id: "other", markers: &internal.Owner{UID: "uid-1"}, owns: true},
+		{name: "created for resource in another cluster", cluster: "c1", id: "uid-1", markers: &internal.Owner{Cluster: "c2", UID: "uid-2"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			info := &internal.ClientDetails{Client: internal.Client{ID: c.id, ClientID: "app"}}
+			if c.markers != nil {
+				info.Attributes = c.markers.Attributes()
+			}
+			r := &KeycloakClientReconciler{ClusterID: c.cluster}
+			assert.Equal(t, c.owns, r.owns(info, m))
+		})
+	}
+}
+
+func TestKeycloakClientReconciler_skipForeign(t *testing.T) {
+	foreign := internal.Owner{Cluster: "c2", UID: "uid-2"}.Attributes()
+	cases := []struct {
+		name    string
+		prefix  bool
+		spec    keycloakv1alpha1.KeycloakClientSpec
+		id      string
+		markers map[string]string
+		skip    bool
+	}{
+		{name: "foreign with prefix", prefix: true, markers: foreign, skip: true},
+		{name: "foreign without prefix", markers: foreign},
+		{name: "own cluster", prefix: true, markers: internal.Owner{Cluster: "c1", UID: "uid-2"}.Attributes()},
+		{name: "unmanaged", prefix: true},
+		{name: "saml is not prefixed", prefix: true, spec: keycloakv1alpha1.KeycloakClientSpec{Protocol: "saml"}, markers: foreign},
+		{name: "adoption always", prefix: true, spec: keycloakv1alpha1.KeycloakClientSpec{Adoption: adoptionAlways}, markers: foreign},
+		{name: "created for resource", prefix: true, id: "uid-1", markers: foreign},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := &KeycloakClientReconciler{ClusterID: "c1", PrefixClientID: c.prefix}
+			m := &keycloakv1alpha1.KeycloakClient{ObjectMeta: metav1.ObjectMeta{UID: "uid-1"}, Spec: c.spec}
+			id := c.id
+			if id == "" {
+				id = "other"
+			}
+			info := &internal.ClientDetails{Client: internal.Client{ID: id, ClientID: "c2-app", Attributes: c.markers}}
+			assert.Equal(t, c.skip, r.skipForeign(info, m))
+		})
+	}
+}
+
+func TestKeycloakClientReconciler_checkAdoption(t *testing.T) {
+	foreign := internal.Owner{Namespace: "other", Name: "app", UID: "uid-2"}.Attributes()
+	cases := []struct {
+		adoption string
+		markers  map[string]string
+		refused  bool
+	}{
+		{adoption: adoptionNever, refused: true},
+		{adoption: "", refused: true},
+		{adoption: "ifUnmanaged"},
+		{adoption: adoptionAlways},
+		{adoption: adoptionNever, markers: foreign, refused: true},
+		{adoption: "", markers: foreign, refused: true},
+		{adoption: "ifUnmanaged", markers: foreign, refused: true},
+		{adoption: adoptionAlways, markers: foreign},
+	}
+	for _, c := range cases {
+		name := c.adoption
+		if name == "" {
+			name = "default"
+		}
+		if c.markers != nil {
+			name = "managed " + name
+		} else {
+			name = "unmanaged " + name
+		}
+		t.Run(name, func(t *testing.T) {
+			m := &keycloakv1alpha1.KeycloakClient{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", UID: "uid-1"}}
+			m.Spec.Adoption = c.adoption
+			info := &internal.ClientDetails{Client: internal.Client{ID: "other", ClientID: "app", Attributes: c.markers}}
+
+			err := (&KeycloakClientReconciler{}).checkAdoption(info, m)
+			if c.refused {
+				assert.ErrorIs(t, err, ErrAdoptionRefused)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	t.Run("owned is never refused", func(t *testing.T) {
+		m := &keycloakv1alpha1.KeycloakClient{ObjectMeta: metav1.ObjectMeta{UID: "uid-1"}}
+		m.Spec.Adoption = adoptionNever
+		info := &internal.ClientDetails{Client: internal.Client{ID: "uid-1"}}
+		assert.NoError(t, (&KeycloakClientReconciler{}).checkAdoption(info, m))
+	})
+}
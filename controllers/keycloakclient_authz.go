@@ -0,0 +1,235 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/reddec/keycloak-ext-operator/internal"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+// syncAuthorization syncs resource server settings, scopes, resources, policies and permissions of client (by internal
+// ID). Objects are matched by name. Existent objects are updated only if authorization settings changed since last
+// sync (by hash), however missed objects are always created and unknown objects are always removed.
+// Returns hash of applied settings.
+func (r *KeycloakClientReconciler) syncAuthorization(ctx context.Context, kClient *internal.AuthorizedKeycloak, id string, manifest *keycloakv1alpha1.KeycloakClient) (string, error) {
+	authz := manifest.Spec.Authorization
+	if authz == nil {
+		return "", nil
+	}
+	realm := manifest.Spec.Realm
+	hash := authorizationHash(authz)
+	force := hash != manifest.Status.AuthorizationHash
+
+	if force {
+		err := kClient.UpdateResourceServer(ctx, realm, id, internal.ResourceServer{
+			PolicyEnforcementMode: orDefault(authz.PolicyEnforcementMode, "ENFORCING"),
+			DecisionStrategy:      orDefault(authz.DecisionStrategy, "UNANIMOUS"),
+		})
+		if err != nil {
+			return "", fmt.Errorf("update resource server: %w", err)
+		}
+	}
+
+	// scopes
+	existentScopes, err := kClient.AuthzScopes(ctx, realm, id)
+	if err != nil {
+		return "", fmt.Errorf("list scopes: %w", err)
+	}
+	var scopes = make([]internal.AuthzScope, 0, len(authz.Scopes))
+	for _, s := range authz.Scopes {
+		scopes = append(scopes, internal.AuthzScope{Name: s.Name, DisplayName: s.DisplayName})
+	}
+	staleScopes, err := syncByName(existentScopes, scopes, force, func(item internal.AuthzScope) string {
+		return item.Name
+	}, func(item internal.AuthzScope, old *internal.AuthzScope) error {
+		if old != nil {
+			item.ID = old.ID
+		}
+		return kClient.SaveAuthzScope(ctx, realm, id, item)
+	})
+	if err != nil {
+		return "", fmt.Errorf("sync scopes: %w", err)
+	}
+
+	// resources
+	existentResources, err := kClient.AuthzResources(ctx, realm, id)
+	if err != nil {
+		return "", fmt.Errorf("list resources: %w", err)
+	}
+	var resources = make([]internal.AuthzResource, 0, len(authz.Resources))
+	for _, res := range authz.Resources {
+		var resScopes = make([]internal.AuthzScope, 0, len(res.Scopes))
+		for _, s := range res.Scopes {
+			resScopes = append(resScopes, internal.AuthzScope{Name: s})
+		}
+		resources = append(resources, internal.AuthzResource{
+			Name:               res.Name,
+			DisplayName:        res.DisplayName,
+			Type:               res.Type,
+			URIs:               res.URIs,
+			Scopes:             resScopes,
+			OwnerManagedAccess: res.OwnerManagedAccess,
+		})
+	}
+	staleResources, err := syncByName(existentResources, resources, force, func(item internal.AuthzResource) string {
+		return item.Name
+	}, func(item internal.AuthzResource, old *internal.AuthzResource) error {
+		if old != nil {
+			item.ID = old.ID
+		}
+		return kClient.SaveAuthzResource(ctx, realm, id, item)
+	})
+	if err != nil {
+		return "", fmt.Errorf("sync resources: %w", err)
+	}
+
+	// policies and permissions are the same entities for Keycloak
+	savePolicy := func(item internal.AuthzPolicy, old *internal.AuthzPolicy) error {
+		if old != nil && old.Type != item.Type {
+			// type can not be changed
+			if err := kClient.DeleteAuthzPolicy(ctx, realm, id, old.ID); err != nil {
+				return err
+			}
+		} else if old != nil {
+			item.ID = old.ID
+		}
+		return kClient.SaveAuthzPolicy(ctx, realm, id, item)
+	}
+	policyName := func(item internal.AuthzPolicy) string {
+		return item.Name
+	}
+
+	existentPolicies, err := kClient.AuthzPolicies(ctx, realm, id, false)
+	if err != nil {
+		return "", fmt.Errorf("list policies: %w", err)
+	}
+	var policies = make([]internal.AuthzPolicy, 0, len(authz.Policies))
+	for _, p := range authz.Policies {
+		policy := internal.AuthzPolicy{
+			Name:        p.Name,
+			Type:        p.Type,
+			Description: p.Description,
+			Logic:       orDefault(p.Logic, "POSITIVE"),
+			Clients:     p.Clients,
+		}
+		for _, role := range p.Roles {
+			policy.Roles = append(policy.Roles, internal.AuthzPolicyRole{ID: role})
+		}
+		for _, group := range p.Groups {
+			policy.Groups = append(policy.Groups, internal.AuthzPolicyGroup{Path: group})
+		}
+		policies = append(policies, policy)
+	}
+	stalePolicies, err := syncByName(existentPolicies, policies, force, policyName, savePolicy)
+	if err != nil {
+		return "", fmt.Errorf("sync policies: %w", err)
+	}
+
+	existentPermissions, err := kClient.AuthzPolicies(ctx, realm, id, true)
+	if err != nil {
+		return "", fmt.Errorf("list permissions: %w", err)
+	}
+	var permissions = make([]internal.AuthzPolicy, 0, len(authz.Permissions))
+	for _, p := range authz.Permissions {
+		permissions = append(permissions, internal.AuthzPolicy{
+			Name:             p.Name,
+			Type:             p.Type,
+			Description:      p.Description,
+			DecisionStrategy: orDefault(p.DecisionStrategy, "UNANIMOUS"),
+			Resources:        p.Resources,
+			Scopes:           p.Scopes,
+			Policies:         p.Policies,
+		})
+	}
+	stalePermissions, err := syncByName(existentPermissions, permissions, force, policyName, savePolicy)
+	if err != nil {
+		return "", fmt.Errorf("sync permissions: %w", err)
+	}
+
+	// remove in reverse order of dependencies
+	for _, p := range append(stalePermissions, stalePolicies...) {
+		if err := kClient.DeleteAuthzPolicy(ctx, realm, id, p.ID); err != nil {
+			return "", fmt.Errorf("delete policy %q: %w", p.Name, err)
+		}
+	}
+	for _, res := range staleResources {
+		if err := kClient.DeleteAuthzResource(ctx, realm, id, res.ID); err != nil {
+			return "", fmt.Errorf("delete resource %q: %w", res.Name, err)
+		}
+	}
+	for _, s := range staleScopes {
+		if err := kClient.DeleteAuthzScope(ctx, realm, id, s.ID); err != nil {
+			return "", fmt.Errorf("delete scope %q: %w", s.Name, err)
+		}
+	}
+	if force {
+		log.Log.Info("Keycloak client authorization synced with manifest")
+	}
+	return hash, nil
+}
+
+// syncByName saves desired items which are not exists (old is nil) or all desired items if force is set.
+// Returns existent items which are not desired.
+func syncByName[T any](existent, desired []T, force bool, name func(item T) string, save func(item T, old *T) error) ([]T, error) {
+	var byName = make(map[string]T, len(existent))
+	for _, item := range existent {
+		byName[name(item)] = item
+	}
+	for _, item := range desired {
+		old, ok := byName[name(item)]
+		delete(byName, name(item))
+		if ok && !force {
+			continue
+		}
+		var ref *T
+		if ok {
+			ref = &old
+		}
+		if err := save(item, ref); err != nil {
+			return nil, fmt.Errorf("save %q: %w", name(item), err)
+		}
+	}
+	var stale = make([]T, 0, len(byName))
+	for _, item := range byName {
+		stale = append(stale, item)
+	}
+	return stale, nil
+}
+
+func authorizationHash(authz *keycloakv1alpha1.ClientAuthorization) string {
+	data, err := json.Marshal(authz)
+	if err != nil {
+		panic(err) // plain struct, should never happen
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func orDefault(value, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncByName(t *testing.T) {
+	type item struct {
+		name  string
+		value int
+	}
+	existent := []item{{"a", 1}, {"b", 2}, {"c", 3}}
+	cases := []struct {
+		name    string
+		desired []item
+		force   bool
+		saved   []string // name=old value (0 if created)
+		stale   []string
+	}{
+		{
+			name:    "create missed only",
+			desired: []item{{"a", 10}, {"d", 4}},
+			saved:   []string{"d/0"},
+			stale:   []string{"b", "c"},
+		},
+		{
+			name:    "force updates existent",
+			desired: []item{{"a", 10}, {"d", 4}},
+			force:   true,
+			saved:   []string{"a/1", "d/0"},
+			stale:   []string{"b", "c"},
+		},
+		{
+			name:  "nothing desired",
+			stale: []string{"a", "b", "c"},
+		},
+		{
+			name:    "all desired",
+			desired: []item{{"c", 3}, {"b", 2}, {"a", 1}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var saved []string
+			stale, err := syncByName(existent, c.desired, c.force, func(i item) string { return i.name }, func(i item, old *item) error {
+				var prev int
+				if old != nil {
+					prev = old.value
+				}
+				saved = append(saved, i.name+"/"+strconv.Itoa(prev))
+				return nil
+			})
+			require.NoError(t, err)
+			var names []string
+			for _, i := range stale {
+				names = append(names, i.name)
+			}
+			sort.Strings(names)
+			assert.Equal(t, c.saved, saved)
+			assert.Equal(t, c.stale, names)
+		})
+	}
+
+	t.Run("save error", func(t *testing.T) {
+		failure := errors.New("failure")
+		_, err := syncByName(existent, []item{{"d", 4}}, false, func(i item) string { return i.name }, func(item, *item) error {
+			return failure
+		})
+		assert.ErrorIs(t, err, failure)
+	})
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+const (
+	conditionAllowed          = "Allowed"
+	conditionSecretConflict   = "SecretConflict"
+	conditionClientConflict   = "ClientConflict"
+	conditionUserConflict     = "UserConflict"
+	conditionIdPConflict      = "IdentityProviderConflict"
+	conditionIssuerMismatch   = "IssuerMismatch"
+	conditionRealmUnavailable = "RealmUnavailable"
+)
+
+// setCondition updates condition in status. Returns true if condition changed.
+func (r *KeycloakClientReconciler) setCondition(ctx context.Context, m *keycloakv1alpha1.KeycloakClient, condition metav1.Condition) (bool, error) {
+	return setStatusCondition(ctx, r.Status(), m, &m.Status.Conditions, condition)
+}
+
+// setStatusCondition updates condition in conditions list of resource and saves status. Returns true if condition
+// changed.
+func setStatusCondition(ctx context.Context, status client.StatusWriter, m client.Object, conditions *[]metav1.Condition, condition metav1.Condition) (bool, error) {
+	condition.ObservedGeneration = m.GetGeneration()
+	current := meta.FindStatusCondition(*conditions, condition.Type)
+	if current != nil && current.Status == condition.Status && current.Reason == condition.Reason && current.Message == condition.Message && current.ObservedGeneration == condition.ObservedGeneration {
+		return false, nil
+	}
+	meta.SetStatusCondition(conditions, condition)
+	if err := status.Update(ctx, m); err != nil {
+		return false, fmt.Errorf("update status: %w", err)
+	}
+	return true, nil
+}
@@ -18,8 +18,11 @@ package controllers
 
 import (
 	"context"
+	"encoding/base64"
 	errors2 "errors"
 	"fmt"
+	"reflect"
+	"strconv"
 	"time"
 
 	"github.com/gogo/protobuf/proto"
@@ -29,10 +32,12 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/utils/strings/slices"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
@@ -41,16 +46,39 @@ import (
 // KeycloakClientReconciler reconciles a KeycloakClient object
 type KeycloakClientReconciler struct {
 	client.Client
-	Scheme   *runtime.Scheme
-	Keycloak *internal.Keycloak
+	Scheme    *runtime.Scheme
+	Instances *Instances
+	Policies  *AccessPolicies
+	Recorder  record.EventRecorder
+	// ClusterID (optional) identifies cluster in ownership markers of Keycloak clients.
+	ClusterID string
+	// PrefixClientID (optional) prefixes clientId of new openid-connect clients by cluster ID: <cluster>/<domain>.
+	PrefixClientID bool
+	// DefaultDeletionPolicy (optional) is used for resources without deletionPolicy. Default is Delete.
+	DefaultDeletionPolicy string
+	// DeletionTimeout (optional) after which finalizer is removed even if client can not be removed from Keycloak.
+	// Zero means no timeout.
+	DeletionTimeout time.Duration
 }
 
-const keycloakFinalizer = "reddec.net.k8s.keycloak-finalizer"
+const (
+	keycloakFinalizer     = "reddec.net.k8s.keycloak-finalizer"
+	forceDeleteAnnotation = "keycloak.k8s.reddec.net/force-delete"
+)
+
+// Deletion policies of Keycloak clients.
+const (
+	DeletionPolicyDelete  = "Delete"
+	DeletionPolicyRetain  = "Retain"
+	DeletionPolicyDisable = "Disable"
+)
 
 //+kubebuilder:rbac:groups=keycloak.k8s.reddec.net,resources=keycloakclients,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=keycloak.k8s.reddec.net,resources=keycloakclients/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=keycloak.k8s.reddec.net,resources=keycloakclients/finalizers,verbs=update
 //+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=apps,resources=deployments;statefulsets;daemonsets,verbs=get;list;watch;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -72,7 +100,7 @@ func (r *KeycloakClientReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 	}
 
 	if clientSpec.GetDeletionTimestamp() != nil {
-		if err := r.removeClient(ctx, clientSpec); err != nil {
+		if err := r.removeClient(ctx, clientSpec); err != nil && !r.abandonRemoval(clientSpec, err) {
 			logger.Error(err, "Failed to remove client")
 			return ctrl.Result{}, err
 		}
@@ -84,6 +112,16 @@ func (r *KeycloakClientReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 		return ctrl.Result{}, nil
 	}
 
+	// enforce access policies before any changes
+	allowed, err := r.checkAccess(ctx, clientSpec)
+	if err != nil {
+		logger.Error(err, "Check access policies")
+		return ctrl.Result{}, err
+	}
+	if !allowed {
+		return ctrl.Result{RequeueAfter: requeueAfter(clientSpec)}, nil
+	}
+
 	// add finalizer (to clean up Keycloak client)
 	if !controllerutil.ContainsFinalizer(clientSpec, keycloakFinalizer) {
 		controllerutil.AddFinalizer(clientSpec, keycloakFinalizer)
@@ -92,21 +130,101 @@ func (r *KeycloakClientReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 		}
 	}
 
+	keycloak, err := r.keycloak(ctx, clientSpec)
+	if err != nil {
+		logger.Error(err, "Resolve Keycloak instance")
+		return ctrl.Result{}, err
+	}
+
+	// validate realm and publish its endpoints; secret content is derived from discovery document, so nothing is changed
+	// until it is available
+	discovery, err := realmEndpoints(ctx, keycloak, clientSpec.Spec.Realm)
+	if err := r.reportRealmUnavailable(ctx, clientSpec, err); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err != nil {
+		logger.Error(err, "Get realm discovery document")
+		return ctrl.Result{}, err
+	}
+	if err := r.updateEndpoints(ctx, clientSpec, discovery); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.reportIssuerMismatch(ctx, clientSpec, discovery.Issuer, keycloak.RealmURL(clientSpec.Spec.Realm)); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// secrets are checked before any change in Keycloak, so new credentials will not be left unpublished
+	if err := r.checkSecretsOwnership(ctx, clientSpec); errors2.Is(err, ErrSecretConflict) {
+		return ctrl.Result{RequeueAfter: requeueAfter(clientSpec)}, r.reportSecretConflict(ctx, clientSpec, err)
+	} else if err != nil {
+		logger.Error(err, "Check secrets ownership")
+		return ctrl.Result{}, err
+	}
+
 	// get existent keycloak client (by ID or by name as domain) or create new one
 	keycloakClient, err := r.getOrCreateClient(ctx, string(clientSpec.UID), clientSpec)
+	if errors2.Is(err, ErrAdoptionRefused) {
+		return ctrl.Result{RequeueAfter: requeueAfter(clientSpec)}, r.reportClientConflict(ctx, clientSpec, err)
+	}
 	if err != nil {
 		logger.Error(err, "Create client")
 		return ctrl.Result{}, err
 	}
+	if err := r.reportClientConflict(ctx, clientSpec, nil); err != nil {
+		return ctrl.Result{}, err
+	}
 
 	// sync manifest and keycloak
-	if err := r.updateClient(ctx, keycloakClient, clientSpec.Spec); err != nil {
+	if err := r.updateClient(ctx, keycloakClient, clientSpec); err != nil {
 		logger.Error(err, "Update client")
 		return ctrl.Result{}, err
 	}
 
+	kClient := keycloak.Authorize(ctx)
+
+	if err := r.syncClientSecret(ctx, kClient, keycloakClient, clientSpec); err != nil {
+		logger.Error(err, "Sync client secret")
+		return ctrl.Result{}, err
+	}
+
+	if err := r.syncProtocolMappers(ctx, kClient, keycloakClient, clientSpec); err != nil {
+		logger.Error(err, "Sync protocol mappers")
+		return ctrl.Result{}, err
+	}
+
+	if err := r.syncClientScopes(ctx, kClient, keycloakClient.ID, clientSpec); err != nil {
+		logger.Error(err, "Sync client scopes")
+		return ctrl.Result{}, err
+	}
+
+	authzHash, err := r.syncAuthorization(ctx, kClient, keycloakClient.ID, clientSpec)
+	if err != nil {
+		logger.Error(err, "Sync authorization")
+		return ctrl.Result{}, err
+	}
+	if authzHash != clientSpec.Status.AuthorizationHash {
+		clientSpec.Status.AuthorizationHash = authzHash
+		if err := r.Status().Update(ctx, clientSpec); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// rotate credentials (if requested) and update current version of secret
+	if err := r.rotateCredentials(ctx, kClient, keycloakClient, clientSpec); err != nil {
+		logger.Error(err, "Rotate credentials")
+		return ctrl.Result{}, err
+	}
+
+	if err := r.expireRotatedSecret(ctx, kClient, keycloakClient, clientSpec); err != nil {
+		logger.Error(err, "Expire rotated secret")
+		return ctrl.Result{}, err
+	}
+
 	// Check if the secret already exists, if not create a new one
 	secret, err := r.getOrCreateSecret(ctx, keycloakClient, clientSpec)
+	if errors2.Is(err, ErrSecretConflict) {
+		return ctrl.Result{RequeueAfter: requeueAfter(clientSpec)}, r.reportSecretConflict(ctx, clientSpec, err)
+	}
 	if err != nil {
 		logger.Error(err, "Failed to get or create Secret")
 		return ctrl.Result{}, err
@@ -118,22 +236,59 @@ func (r *KeycloakClientReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 		logger.Error(err, "Failed to update Secret", "Secret.Namespace", secret.Namespace, "Secret.Name", secret.Name)
 		return ctrl.Result{}, err
 	}
-	return ctrl.Result{RequeueAfter: time.Minute}, nil
+
+	// key pair is stored in secret first, and only then registered in Keycloak, so it will never be lost
+	if err := r.syncClientCertificate(ctx, kClient, keycloakClient, clientSpec, secret); err != nil {
+		logger.Error(err, "Sync client certificate")
+		return ctrl.Result{}, err
+	}
+
+	if err := r.syncAliasSecret(ctx, secret, clientSpec); errors2.Is(err, ErrSecretConflict) {
+		return ctrl.Result{RequeueAfter: requeueAfter(clientSpec)}, r.reportSecretConflict(ctx, clientSpec, err)
+	} else if err != nil {
+		logger.Error(err, "Sync alias secret")
+		return ctrl.Result{}, err
+	}
+	if err := r.reportSecretConflict(ctx, clientSpec, nil); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if err := r.restartTargets(ctx, secret, clientSpec); err != nil {
+		logger.Error(err, "Restart targets")
+		return ctrl.Result{}, err
+	}
+
+	if err := r.cleanupSecretVersions(ctx, clientSpec); err != nil {
+		logger.Error(err, "Clean up secret versions")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter(clientSpec)}, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *KeycloakClientReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	err := mgr.GetFieldIndexer().IndexField(context.Background(), &keycloakv1alpha1.KeycloakClient{}, clientSecretIndex, func(object client.Object) []string {
+		kc := object.(*keycloakv1alpha1.KeycloakClient)
+		if kc.Spec.ClientSecretRef == nil {
+			return nil
+		}
+		return []string{kc.Spec.ClientSecretRef.Name}
+	})
+	if err != nil {
+		return fmt.Errorf("index client secret: %w", err)
+	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&keycloakv1alpha1.KeycloakClient{}).
 		Owns(&v12.Secret{}).
+		Watches(&v12.Secret{}, handler.EnqueueRequestsFromMapFunc(r.findBySecret)).
 		Complete(r)
 }
 
 func (r *KeycloakClientReconciler) getOrCreateSecret(ctx context.Context, info *internal.ClientDetails, clientSpec *keycloakv1alpha1.KeycloakClient) (*v12.Secret, error) {
 	found := &v12.Secret{}
-	err := r.Get(ctx, types.NamespacedName{Name: clientSpec.SecretName(), Namespace: clientSpec.Namespace}, found)
+	err := r.Get(ctx, types.NamespacedName{Name: targetSecretName(clientSpec), Namespace: clientSpec.Namespace}, found)
 	if err == nil {
-		return found, nil
+		return found, r.claimSecret(found, clientSpec)
 	}
 	if errors.IsNotFound(err) {
 		return r.createSecret(ctx, info, clientSpec)
@@ -142,30 +297,26 @@ func (r *KeycloakClientReconciler) getOrCreateSecret(ctx context.Context, info *
 }
 
 func (r *KeycloakClientReconciler) createSecret(ctx context.Context, info *internal.ClientDetails, manifest *keycloakv1alpha1.KeycloakClient) (*v12.Secret, error) {
-	var labels = map[string]string{
-		"keycloak-cr": manifest.Name,
-		"keycloak-id": info.ID,
+	// new version of rotated secret has new credentials, but generated values (ex: cookie secret) are kept
+	previous, err := r.previousVersionData(ctx, manifest)
+	if err != nil {
+		return nil, err
 	}
-	for k, v := range manifest.Spec.Labels {
-		labels[k] = v
+	data, err := r.secretData(ctx, info, manifest, nil, previous)
+	if err != nil {
+		return nil, err
 	}
 
 	sec := &v12.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:        manifest.SecretName(),
+			Name:        targetSecretName(manifest),
 			Namespace:   manifest.Namespace,
-			Labels:      labels,
+			Labels:      secretLabels(info, manifest),
 			Annotations: manifest.Spec.Annotations,
 		},
-		Immutable: proto.Bool(true),
-		Data: map[string][]byte{
-			"clientID":     []byte(info.ClientID),
-			"clientSecret": []byte(info.Secret),
-			"realm":        []byte(manifest.Spec.Realm),
-			"realmURL":     []byte(r.Keycloak.RealmURL(manifest.Spec.Realm)),
-			"discoveryURL": []byte(r.Keycloak.DiscoveryURL(manifest.Spec.Realm)),
-		},
-		Type: "Opaque",
+		Immutable: proto.Bool(immutableSecret(manifest, targetSecretName(manifest))),
+		Data:      data,
+		Type:      "Opaque",
 	}
 
 	if err := ctrl.SetControllerReference(manifest, sec, r.Scheme); err != nil {
@@ -186,46 +337,342 @@ func (r *KeycloakClientReconciler) updateSecret(ctx context.Context, secret *v12
 	for k, v := range m.Spec.Annotations {
 		secret.Annotations[k] = v
 	}
-	secret.Labels = map[string]string{
+	secret.Labels = secretLabels(info, m)
+	data, err := r.secretData(ctx, info, m, secret.Data, secret.Data)
+	if err != nil {
+		return err
+	}
+	if secret.Immutable != nil && *secret.Immutable && !reflect.DeepEqual(secret.Data, data) {
+		// content of immutable secret can not be changed, so it has to be re-created (secret with stable name, created
+		// immutable by previous versions of operator, becomes mutable)
+		if err := r.Delete(ctx, secret); err != nil {
+			return fmt.Errorf("delete outdated secret: %w", err)
+		}
+		secret.ObjectMeta = metav1.ObjectMeta{
+			Name:        secret.Name,
+			Namespace:   secret.Namespace,
+			Labels:      secret.Labels,
+			Annotations: secret.Annotations,
+		}
+		secret.Immutable = proto.Bool(immutableSecret(m, secret.Name))
+		secret.Data = data
+		secret.Type = "Opaque"
+		if err := ctrl.SetControllerReference(m, secret, r.Scheme); err != nil {
+			return fmt.Errorf("set controller refrence: %w", err)
+		}
+		log.Log.Info("Secret will be re-created", "Namespace", secret.Namespace, "Name", secret.Name)
+		return r.Create(ctx, secret)
+	}
+	secret.Data = data
+	secret.Type = "Opaque"
+	return r.Update(ctx, secret)
+}
+
+func secretLabels(info *internal.ClientDetails, m *keycloakv1alpha1.KeycloakClient) map[string]string {
+	var labels = map[string]string{
 		"keycloak-cr": m.Name,
 		"keycloak-id": info.ID,
 	}
+	if m.Spec.Rotation != nil && m.Status.SecretVersion > 0 {
+		labels[secretVersionLabel] = strconv.Itoa(m.Status.SecretVersion)
+	}
 	for k, v := range m.Spec.Labels {
-		secret.Labels[k] = v
+		labels[k] = v
+	}
+	return labels
+}
+
+// secretData returns content of secret: credentials and rendered templates.
+// Current content of secret (nil for new secret) is used to keep key pair, generated values are kept from
+// generated (current content, or content of previous version for new version of secret).
+func (r *KeycloakClientReconciler) secretData(ctx context.Context, info *internal.ClientDetails, m *keycloakv1alpha1.KeycloakClient, current, generated map[string][]byte) (map[string][]byte, error) {
+	data, err := r.credentialsData(ctx, info, m, current, generated)
+	if err != nil {
+		return nil, err
 	}
-	secret.Data = map[string][]byte{
-		"clientID":     []byte(info.ClientID),
-		"clientSecret": []byte(info.Secret),
-		"realm":        []byte(m.Spec.Realm),
-		"realmURL":     []byte(r.Keycloak.RealmURL(m.Spec.Realm)),
-		"discoveryURL": []byte(r.Keycloak.DiscoveryURL(m.Spec.Realm)),
+	addGeneratedValues(m, data, generated)
+	return renderSecretTemplate(info, m, data)
+}
+
+// credentialsData returns content of secret with credentials according to client protocol and authenticator.
+// Current content of secret (nil for new secret) is used to keep generated key pair, key pair from replaced content
+// (current content, or content of previous version) is kept during grace period.
+func (r *KeycloakClientReconciler) credentialsData(ctx context.Context, info *internal.ClientDetails, m *keycloakv1alpha1.KeycloakClient, current, replaced map[string][]byte) (map[string][]byte, error) {
+	realm := m.Spec.Realm
+	keycloak, err := r.keycloak(ctx, m)
+	if err != nil {
+		return nil, err
 	}
-	secret.Type = "Opaque"
-	return r.Update(ctx, secret)
+	if m.IsSAML() {
+		cert, err := keycloak.Authorize(ctx).SigningCertificate(ctx, realm, "RS256")
+		if err != nil {
+			return nil, fmt.Errorf("get signing certificate: %w", err)
+		}
+		return map[string][]byte{
+			"clientID":           []byte(info.ClientID),
+			"realm":              []byte(realm),
+			"realmURL":           []byte(keycloak.RealmURL(realm)),
+			"idpMetadataURL":     []byte(keycloak.SAMLDescriptorURL(realm)),
+			"idpEntityID":        []byte(keycloak.RealmURL(realm)),
+			"ssoURL":             []byte(keycloak.SAMLURL(realm)),
+			"signingCertificate": []byte(cert),
+		}, nil
+	}
+	discovery, err := keycloak.Discovery(ctx, realm)
+	if err != nil {
+		return nil, fmt.Errorf("get realm discovery document: %w", err)
+	}
+	// everything in secret is published by public URL
+	endpoints := discovery.Rebase(keycloak.RealmURL(realm))
+	data := map[string][]byte{
+		"clientID":              []byte(info.ClientID),
+		"clientSecret":          []byte(info.Secret),
+		"realm":                 []byte(realm),
+		"realmURL":              []byte(keycloak.RealmURL(realm)),
+		"discoveryURL":          []byte(keycloak.DiscoveryURL(realm)),
+		"issuer":                []byte(endpoints.Issuer),
+		"authorizationEndpoint": []byte(endpoints.AuthorizationEndpoint),
+		"tokenEndpoint":         []byte(endpoints.TokenEndpoint),
+		"userinfoEndpoint":      []byte(endpoints.UserinfoEndpoint),
+		"jwksURI":               []byte(endpoints.JWKSURI),
+		"endSessionEndpoint":    []byte(endpoints.EndSessionEndpoint),
+	}
+	if m.Spec.PublicClient {
+		// public clients have no credentials
+		delete(data, "clientSecret")
+		return data, nil
+	}
+	if !m.IsJWT() {
+		if previous := previousSecret(info, time.Now()); m.Spec.Rotation != nil && previous != "" {
+			// both secrets are valid during grace period
+			data["previousClientSecret"] = []byte(previous)
+		}
+		return data, nil
+	}
+	delete(data, "clientSecret")
+	if m.Spec.JWT != nil && m.Spec.JWT.JWKSURL != "" {
+		// keys are managed by client itself
+		return data, nil
+	}
+	key, previous, err := clientKey(m, current, replaced)
+	if err != nil {
+		return nil, err
+	}
+	data["privateKey"] = []byte(key.PrivateKey)
+	data["certificate"] = []byte(key.Certificate)
+	data["keyID"] = []byte(key.KeyID)
+	if previous != nil {
+		// both key pairs are valid during grace period
+		data["previousPrivateKey"] = []byte(previous.PrivateKey)
+		data["previousCertificate"] = []byte(previous.Certificate)
+		data["previousKeyID"] = []byte(previous.KeyID)
+	}
+	return data, nil
 }
 
-func mostlyTheSame(spec keycloakv1alpha1.KeycloakClientSpec, info *internal.ClientDetails) (internal.ClientDraft, bool) {
-	draft := internal.Generate(spec.Domain)
+// clientKey returns key pair from current secret content or generates new one if there is no key, algorithm changed,
+// or key is older than rotation period. Replaced key pair (from current content, or from replaced content if there is
+// no key in current one) is returned as previous and kept until grace period since generation of new key is over.
+func clientKey(m *keycloakv1alpha1.KeycloakClient, current, replaced map[string][]byte) (key, previous *internal.ClientKey, err error) {
+	algorithm := m.JWTAlgorithm()
+	if current != nil && len(current["privateKey"]) > 0 {
+		cert, certAlgorithm, err := internal.ParseCertificate(current["certificate"])
+		expired := err == nil && m.Spec.JWT != nil && m.Spec.JWT.RotationPeriod != nil &&
+			time.Since(cert.NotBefore) > m.Spec.JWT.RotationPeriod.Duration
+		if err == nil && certAlgorithm == algorithm && !expired {
+			if time.Since(cert.NotBefore) < gracePeriod(m) {
+				previous = storedKey(current, "previousPrivateKey", "previousCertificate", "previousKeyID")
+			}
+			return storedKey(current, "privateKey", "certificate", "keyID"), previous, nil
+		}
+		replaced = current
+	}
+	key, err = internal.GenerateClientKey(algorithm, m.Spec.Domain)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate client key: %w", err)
+	}
+	log.Log.Info("New client key pair generated", "algorithm", algorithm, "kid", key.KeyID)
+	if gracePeriod(m) > 0 {
+		previous = storedKey(replaced, "privateKey", "certificate", "keyID")
+	}
+	return key, previous, nil
+}
+
+// storedKey returns key pair stored in secret content by keys, or nil if there is no valid key pair.
+func storedKey(data map[string][]byte, privateKey, certificate, keyID string) *internal.ClientKey {
+	if len(data[privateKey]) == 0 {
+		return nil
+	}
+	if _, _, err := internal.ParseCertificate(data[certificate]); err != nil {
+		return nil
+	}
+	return &internal.ClientKey{
+		PrivateKey:  string(data[privateKey]),
+		Certificate: string(data[certificate]),
+		KeyID:       string(data[keyID]),
+	}
+}
+
+// syncClientCertificate registers certificate from secret in Keycloak for private-key JWT authentication. While
+// secret has previous certificate (during grace period), both keys are registered as JWKS of client.
+func (r *KeycloakClientReconciler) syncClientCertificate(ctx context.Context, kClient *internal.AuthorizedKeycloak, info *internal.ClientDetails, m *keycloakv1alpha1.KeycloakClient, secret *v12.Secret) error {
+	certPEM := secret.Data["certificate"]
+	if !m.IsJWT() || len(certPEM) == 0 {
+		return nil
+	}
+	cert, _, err := internal.ParseCertificate(certPEM)
+	if err != nil {
+		return fmt.Errorf("parse certificate from secret: %w", err)
+	}
+	registered, err := kClient.ClientCertificate(ctx, m.Spec.Realm, info.ID)
+	if err != nil {
+		return fmt.Errorf("get registered certificate: %w", err)
+	}
+	if registered != base64.StdEncoding.EncodeToString(cert.Raw) {
+		if err := kClient.UploadClientCertificate(ctx, m.Spec.Realm, info.ID, string(certPEM)); err != nil {
+			return fmt.Errorf("upload certificate: %w", err)
+		}
+		log.Log.Info("Client certificate registered", "kid", string(secret.Data["keyID"]))
+	}
+
+	var jwks string
+	if previous := secret.Data["previousCertificate"]; len(previous) > 0 {
+		jwks, err = internal.JWKS(certPEM, previous)
+		if err != nil {
+			return fmt.Errorf("build JWKS: %w", err)
+		}
+	}
+	if (info.Attributes[internal.AttrUseJWKSString] == "true") == (jwks != "") && info.Attributes[internal.AttrJWKSString] == jwks {
+		return nil
+	}
+	err = kClient.Update(ctx, info.ID, m.Spec.Realm, internal.ClientDraft{
+		Attributes: map[string]string{
+			internal.AttrUseJWKSString: strconv.FormatBool(jwks != ""),
+			internal.AttrJWKSString:    jwks,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("update client JWKS: %w", err)
+	}
+	log.Log.Info("Client keys registered", "kid", string(secret.Data["keyID"]), "previous_kid", string(secret.Data["previousKeyID"]))
+	return nil
+}
+
+// generateDraft generates new client according to protocol.
+func generateDraft(spec keycloakv1alpha1.KeycloakClientSpec) internal.ClientDraft {
+	if spec.Protocol != internal.ProtocolSAML {
+		draft := internal.Generate(spec.Domain)
+		if preset, ok := internal.LookupPreset(spec.Preset); ok {
+			preset.Apply(&draft)
+		}
+		serviceAccount, public := clientFlags(spec)
+		draft.PublicClient = proto.Bool(public)
+		if serviceAccount {
+			draft.ServiceAccountsEnabled = proto.Bool(true)
+		}
+		draft.ClientAuthenticatorType = internal.AuthenticatorSecret
+		if spec.ClientAuthenticator == internal.AuthenticatorJWT {
+			draft.ClientAuthenticatorType = internal.AuthenticatorJWT
+			draft.Attributes = map[string]string{
+				"use.jwks.url":                    "false",
+				"token.endpoint.auth.signing.alg": "RS256",
+			}
+			if jwt := spec.JWT; jwt != nil {
+				if jwt.Algorithm != "" {
+					draft.Attributes["token.endpoint.auth.signing.alg"] = jwt.Algorithm
+				}
+				if jwt.JWKSURL != "" {
+					draft.Attributes["use.jwks.url"] = "true"
+					draft.Attributes["jwks.url"] = jwt.JWKSURL
+				}
+			}
+		}
+		return draft
+	}
+	var opts = internal.SAMLOptions{
+		SignDocuments: true,
+	}
+	if saml := spec.SAML; saml != nil {
+		opts.EntityID = saml.EntityID
+		opts.ACSURLs = saml.ACSURLs
+		opts.NameIDFormat = saml.NameIDFormat
+		opts.SignDocuments = saml.SignDocuments == nil || *saml.SignDocuments
+		opts.SignAssertions = saml.SignAssertions
+		opts.ClientSignatureRequired = saml.ClientSignatureRequired
+		opts.EncryptAssertions = saml.EncryptAssertions
+		opts.SignatureAlgorithm = saml.SignatureAlgorithm
+	}
+	return internal.GenerateSAML(spec.Domain, opts)
+}
+
+// clientFlags returns if service account and public access are enabled by spec of openid-connect client. Service
+// account is required by authorization services.
+func clientFlags(spec keycloakv1alpha1.KeycloakClientSpec) (serviceAccount bool, public bool) {
+	if spec.Protocol == internal.ProtocolSAML {
+		return false, false
+	}
+	return spec.ServiceAccount || spec.Authorization != nil, spec.PublicClient
+}
+
+func mostlyTheSame(manifest *keycloakv1alpha1.KeycloakClient, info *internal.ClientDetails, owner internal.Owner) (internal.ClientDraft, bool) {
+	spec := manifest.Spec
+	draft := generateDraft(spec)
+	if draft.Attributes == nil {
+		draft.Attributes = make(map[string]string)
+	}
+	for k, v := range owner.Attributes() {
+		draft.Attributes[k] = v
+	}
 	draft.ClientSecret = info.Secret
-	draft.ClientID = info.ClientID
+	if draft.Protocol != internal.ProtocolSAML {
+		// keep client ID as-is, since it could be copied from existent client
+		draft.ClientID = info.ClientID
+	}
 	draft.ID = info.ID
 	draft.Description = info.Description
+
+	// authorization services are switched off only if they were enabled by operator
+	authz := info.AuthorizationServicesEnabled
+	if spec.Authorization != nil {
+		authz = true
+	} else if manifest.Status.AuthorizationHash != "" {
+		authz = false
+	}
+	draft.AuthorizationServicesEnabled = proto.Bool(authz)
+	if authz {
+		// required by Keycloak
+		draft.ServiceAccountsEnabled = proto.Bool(true)
+	}
+
 	return draft, draft.Name == info.Name &&
 		draft.RootURL == info.RootURL &&
 		draft.AdminURL == info.AdminURL &&
 		slices.Equal(draft.RedirectURIs, info.RedirectURIs) &&
-		slices.Equal(draft.WebOrigins, info.WebOrigins)
+		slices.Equal(draft.WebOrigins, info.WebOrigins) &&
+		draft.ClientID == info.ClientID &&
+		draft.Protocol == info.Protocol &&
+		(draft.ClientAuthenticatorType == "" || draft.ClientAuthenticatorType == info.ClientAuthenticatorType) &&
+		includes(info.Attributes, draft.Attributes) &&
+		(draft.FrontChannelLogout == nil || *draft.FrontChannelLogout == info.FrontChannelLogout) &&
+		authz == info.AuthorizationServicesEnabled &&
+		(draft.ServiceAccountsEnabled == nil || info.ServiceAccountsEnabled) &&
+		(draft.PublicClient == nil || *draft.PublicClient == info.PublicClient)
 }
 
-func (r *KeycloakClientReconciler) updateClient(ctx context.Context, info *internal.ClientDetails, spec keycloakv1alpha1.KeycloakClientSpec) error {
-	diff, same := mostlyTheSame(spec, info)
+func (r *KeycloakClientReconciler) updateClient(ctx context.Context, info *internal.ClientDetails, manifest *keycloakv1alpha1.KeycloakClient) error {
+	spec := manifest.Spec
+	diff, same := mostlyTheSame(manifest, info, r.owner(manifest))
 	if same {
 		return nil
 	}
 	// update client urls and name, keep creds the same
 	id := diff.ID
 	diff.ID = ""
-	if err := r.Keycloak.Authorize(ctx).Update(ctx, id, spec.Realm, diff); err != nil {
+	keycloak, err := r.keycloak(ctx, manifest)
+	if err != nil {
+		return err
+	}
+	if err := keycloak.Authorize(ctx).Update(ctx, id, spec.Realm, diff); err != nil {
 		return fmt.Errorf("update current client: %w", err)
 	}
 	log.Log.Info("Keycloak client synced with manifest")
@@ -233,21 +680,45 @@ func (r *KeycloakClientReconciler) updateClient(ctx context.Context, info *inter
 }
 
 func (r *KeycloakClientReconciler) getOrCreateClient(ctx context.Context, id string, info *keycloakv1alpha1.KeycloakClient) (*internal.ClientDetails, error) {
-
-	kClient := r.Keycloak.Authorize(ctx)
+	keycloak, err := r.keycloak(ctx, info)
+	if err != nil {
+		return nil, err
+	}
+	kClient := keycloak.Authorize(ctx)
 
 	existent, err := internal.Find(ctx, kClient, info.Spec.Realm, id, info.Spec.Domain)
+	if err == nil && r.skipForeign(existent, info) {
+		err = internal.ErrClientNotFound
+	}
 	if err == nil {
-		return existent, nil
+		// ownership markers of adopted client are saved by update
+		return existent, r.checkAdoption(existent, info)
 	}
 	if !errors2.Is(err, internal.ErrClientNotFound) {
 		return nil, fmt.Errorf("get client: %w", err)
 	}
 
 	// create new
-	draft := internal.Generate(info.Spec.Domain)
+	draft := generateDraft(info.Spec)
 	draft.ID = id
+	if info.Spec.ClientSecretRef != nil {
+		secret, err := r.referencedSecret(ctx, info)
+		if err != nil {
+			return nil, err
+		}
+		draft.ClientSecret = secret
+	}
 	draft.Description = "managed by kubernetes operator"
+	if r.PrefixClientID && r.ClusterID != "" && !info.IsSAML() {
+		// entity ID of SAML client can not be changed
+		draft.ClientID = r.ClusterID + "/" + draft.ClientID
+	}
+	if draft.Attributes == nil {
+		draft.Attributes = make(map[string]string)
+	}
+	for k, v := range r.owner(info).Attributes() {
+		draft.Attributes[k] = v
+	}
 
 	_, err = kClient.Create(ctx, info.Spec.Realm, draft)
 	if err != nil {
@@ -257,13 +728,71 @@ func (r *KeycloakClientReconciler) getOrCreateClient(ctx context.Context, id str
 	return kClient.Get(ctx, info.Spec.Realm, id)
 }
 
+// removeClient removes (or releases) Keycloak client. Client (or realm) which already not exists is not an error.
 func (r *KeycloakClientReconciler) removeClient(ctx context.Context, spec *keycloakv1alpha1.KeycloakClient) error {
-	kClient := r.Keycloak.Authorize(ctx)
+	if _, ok := spec.Annotations[forceDeleteAnnotation]; ok {
+		return fmt.Errorf("removal skipped by %s annotation", forceDeleteAnnotation)
+	}
+	keycloak, err := r.keycloak(ctx, spec)
+	if err != nil {
+		return err
+	}
+	kClient := keycloak.Authorize(ctx)
 	info, err := internal.Find(ctx, kClient, spec.Spec.Realm, string(spec.UID), spec.Spec.Domain)
+	if errors2.Is(err, internal.ErrClientNotFound) || errors2.Is(err, internal.ErrNotFound) {
+		log.Log.Info("Client already removed")
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	return kClient.Delete(ctx, spec.Spec.Realm, info.ID)
+	if !r.owns(info, spec) {
+		log.Log.Info("Client is not owned by resource and will not be removed", "client_id", info.ClientID)
+		return nil
+	}
+	switch policy := r.deletionPolicy(spec); policy {
+	case DeletionPolicyRetain, DeletionPolicyDisable:
+		if err := kClient.ReleaseClient(ctx, spec.Spec.Realm, info.ID, policy == DeletionPolicyDisable); err != nil {
+			return fmt.Errorf("release client: %w", err)
+		}
+		log.Log.Info("Client released", "client_id", info.ClientID, "policy", policy)
+		return nil
+	default:
+		if err := kClient.Delete(ctx, spec.Spec.Realm, info.ID); err != nil && !errors2.Is(err, internal.ErrClientNotFound) {
+			return err
+		}
+		return nil
+	}
+}
+
+// abandonRemoval returns true if client should be forgotten despite removal error: removal is forced by annotation or
+// deletion timeout passed. Abandoned client is reported by warning event.
+func (r *KeycloakClientReconciler) abandonRemoval(m *keycloakv1alpha1.KeycloakClient, err error) bool {
+	if _, ok := m.Annotations[forceDeleteAnnotation]; ok {
+		r.Recorder.Eventf(m, v12.EventTypeWarning, "ForceDeleted", "Client was not removed from Keycloak: %v", err)
+		return true
+	}
+	if r.DeletionTimeout > 0 && time.Since(m.DeletionTimestamp.Time) > r.DeletionTimeout {
+		r.Recorder.Eventf(m, v12.EventTypeWarning, "DeletionTimeout", "Client was not removed from Keycloak in %v: %v", r.DeletionTimeout, err)
+		return true
+	}
+	return false
+}
+
+// deletionPolicy returns deletion policy of resource or default policy.
+func (r *KeycloakClientReconciler) deletionPolicy(m *keycloakv1alpha1.KeycloakClient) string {
+	if m.Spec.DeletionPolicy != "" {
+		return m.Spec.DeletionPolicy
+	}
+	if r.DefaultDeletionPolicy != "" {
+		return r.DefaultDeletionPolicy
+	}
+	return DeletionPolicyDelete
+}
+
+// keycloak returns Keycloak instance referenced by manifest.
+func (r *KeycloakClientReconciler) keycloak(ctx context.Context, m *keycloakv1alpha1.KeycloakClient) (*internal.Keycloak, error) {
+	return r.Instances.Resolve(ctx, m.Namespace, m.Spec.InstanceRef)
 }
 
 func includes(src map[string]string, subset map[string]string) bool {
@@ -274,3 +803,80 @@ func includes(src map[string]string, subset map[string]string) bool {
 	}
 	return true
 }
+
+// realmEndpoints returns discovery document of realm. Issuer is kept as-is, while endpoints are re-based to public URL
+// of realm.
+func realmEndpoints(ctx context.Context, keycloak *internal.Keycloak, realm string) (internal.Discovery, error) {
+	discovery, err := keycloak.Discovery(ctx, realm)
+	if err != nil {
+		return internal.Discovery{}, err
+	}
+	return discovery.Rebase(keycloak.RealmURL(realm)), nil
+}
+
+// reportRealmUnavailable sets RealmUnavailable condition if discovery document of realm can not be fetched (realm
+// does not exist or Keycloak is not reachable). Event is emitted only when failure is detected first time.
+func (r *KeycloakClientReconciler) reportRealmUnavailable(ctx context.Context, m *keycloakv1alpha1.KeycloakClient, failure error) error {
+	condition := metav1.Condition{
+		Type:   conditionRealmUnavailable,
+		Status: metav1.ConditionFalse,
+		Reason: "Available",
+	}
+	if failure != nil {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "DiscoveryFailed"
+		condition.Message = fmt.Sprintf("Discovery document of realm %q is not available: %v", m.Spec.Realm, failure)
+	}
+	changed, err := r.setCondition(ctx, m, condition)
+	if err != nil {
+		return err
+	}
+	if changed && failure != nil {
+		r.Recorder.Event(m, v12.EventTypeWarning, "RealmUnavailable", condition.Message)
+	}
+	return nil
+}
+
+// reportIssuerMismatch sets IssuerMismatch condition if issuer of realm differs from published URL of realm: tokens
+// will contain issuer from Keycloak, which will not pass validation by applications. Event is emitted only when
+// mismatch is detected first time.
+func (r *KeycloakClientReconciler) reportIssuerMismatch(ctx context.Context, m *keycloakv1alpha1.KeycloakClient, issuer string, published string) error {
+	condition := metav1.Condition{
+		Type:   conditionIssuerMismatch,
+		Status: metav1.ConditionFalse,
+		Reason: "Match",
+	}
+	if issuer != published {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "Mismatch"
+		condition.Message = fmt.Sprintf("Issuer of realm %q does not match published URL %q", issuer, published)
+	}
+	changed, err := r.setCondition(ctx, m, condition)
+	if err != nil {
+		return err
+	}
+	if changed && condition.Status == metav1.ConditionTrue {
+		r.Recorder.Event(m, v12.EventTypeWarning, "IssuerMismatch", condition.Message)
+	}
+	return nil
+}
+
+// updateEndpoints saves realm endpoints from discovery document to status.
+func (r *KeycloakClientReconciler) updateEndpoints(ctx context.Context, m *keycloakv1alpha1.KeycloakClient, discovery internal.Discovery) error {
+	endpoints := &keycloakv1alpha1.RealmEndpoints{
+		Issuer:                discovery.Issuer,
+		AuthorizationEndpoint: discovery.AuthorizationEndpoint,
+		TokenEndpoint:         discovery.TokenEndpoint,
+		UserinfoEndpoint:      discovery.UserinfoEndpoint,
+		JWKSURI:               discovery.JWKSURI,
+		EndSessionEndpoint:    discovery.EndSessionEndpoint,
+	}
+	if m.Status.Endpoints != nil && *m.Status.Endpoints == *endpoints {
+		return nil
+	}
+	m.Status.Endpoints = endpoints
+	if err := r.Status().Update(ctx, m); err != nil {
+		return fmt.Errorf("update status: %w", err)
+	}
+	return nil
+}
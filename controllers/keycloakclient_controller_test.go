@@ -0,0 +1,510 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/reddec/keycloak-ext-operator/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v12 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+func TestGenerateDraft(t *testing.T) {
+	cases := []struct {
+		name     string
+		spec     keycloakv1alpha1.KeycloakClientSpec
+		protocol string
+		clientID string
+		signed   string
+		account  *bool
+		public   *bool
+	}{
+		{
+			name:     "oidc",
+			spec:     keycloakv1alpha1.KeycloakClientSpec{Domain: "app.example.com"},
+			protocol: internal.ProtocolOIDC,
+			clientID: "app.example.com",
+			public:   proto.Bool(false),
+		},
+		{
+			name:     "oidc with service account",
+			spec:     keycloakv1alpha1.KeycloakClientSpec{Domain: "api.example.com", ServiceAccount: true},
+			protocol: internal.ProtocolOIDC,
+			clientID: "api.example.com",
+			account:  proto.Bool(true),
+			public:   proto.Bool(false),
+		},
+		{
+			name:     "oidc with authorization",
+			spec:     keycloakv1alpha1.KeycloakClientSpec{Domain: "api.example.com", Authorization: &keycloakv1alpha1.ClientAuthorization{}},
+			protocol: internal.ProtocolOIDC,
+			clientID: "api.example.com",
+			account:  proto.Bool(true),
+			public:   proto.Bool(false),
+		},
+		{
+			name:     "public oidc",
+			spec:     keycloakv1alpha1.KeycloakClientSpec{Domain: "spa.example.com", PublicClient: true},
+			protocol: internal.ProtocolOIDC,
+			clientID: "spa.example.com",
+			public:   proto.Bool(true),
+		},
+		{
+			name:     "saml signs documents by default",
+			spec:     keycloakv1alpha1.KeycloakClientSpec{Domain: "app.example.com", Protocol: "saml"},
+			protocol: internal.ProtocolSAML,
+			clientID: "https://app.example.com",
+			signed:   "true",
+		},
+		{
+			name: "saml settings",
+			spec: keycloakv1alpha1.KeycloakClientSpec{Domain: "app.example.com", Protocol: "saml", SAML: &keycloakv1alpha1.SAMLSettings{
+				EntityID:      "urn:app",
+				SignDocuments: proto.Bool(false),
+			}},
+			protocol: internal.ProtocolSAML,
+			clientID: "urn:app",
+			signed:   "false",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			draft := generateDraft(c.spec)
+			assert.Equal(t, c.protocol, draft.Protocol)
+			assert.Equal(t, c.clientID, draft.ClientID)
+			assert.Equal(t, c.signed, draft.Attributes["saml.server.signature"])
+			assert.Equal(t, c.account, draft.ServiceAccountsEnabled)
+			assert.Equal(t, c.public, draft.PublicClient)
+		})
+	}
+}
+
+// clientsServer is a fake admin API of Keycloak with clients of realm "test". Modifying requests are recorded as
+// "METHOD id" with decoded payload.
+type clientsServer struct {
+	clients  []internal.ClientDetails
+	requests []string
+	updates  []internal.ClientDraft
+}
+
+func (cs *clientsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	const prefix = "/admin/realms/test/clients"
+	if r.URL.Path == "/realms/master/protocol/openid-connect/token" {
+		_ = json.NewEncoder(w).Encode(map[string]any{"token_type": "Bearer", "access_token": "token", "expires_in": 60})
+		return
+	}
+	if r.URL.Path == prefix && r.Method == http.MethodGet {
+		var list []internal.Client
+		for _, c := range cs.clients {
+			list = append(list, c.Client)
+		}
+		_ = json.NewEncoder(w).Encode(list)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, prefix+"/")
+	for _, c := range cs.clients {
+		if c.ID != id || !strings.HasPrefix(r.URL.Path, prefix+"/") {
+			continue
+		}
+		switch r.Method {
+		case http.MethodGet:
+			_ = json.NewEncoder(w).Encode(c)
+		case http.MethodPut:
+			var draft internal.ClientDraft
+			_ = json.NewDecoder(r.Body).Decode(&draft)
+			cs.requests = append(cs.requests, "PUT "+id)
+			cs.updates = append(cs.updates, draft)
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodDelete:
+			cs.requests = append(cs.requests, "DELETE "+id)
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+func TestKeycloakClientReconciler_removeClient(t *testing.T) {
+	cases := []struct {
+		name     string
+		policy   string
+		fallback string
+		markers  *internal.Owner
+		requests []string
+		disabled bool
+	}{
+		{name: "default", requests: []string{"DELETE uid-1"}},
+		{name: "delete", policy: DeletionPolicyDelete, fallback: DeletionPolicyRetain, requests: []string{"DELETE uid-1"}},
+		{name: "retain", policy: DeletionPolicyRetain, requests: []string{"PUT uid-1"}},
+		{name: "disable", policy: DeletionPolicyDisable, requests: []string{"PUT uid-1"}, disabled: true},
+		{name: "default policy of operator", fallback: DeletionPolicyDisable, requests: []string{"PUT uid-1"}, disabled: true},
+		{name: "not owned", policy: DeletionPolicyDelete, markers: &internal.Owner{UID: "uid-2"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			info := internal.ClientDetails{Client: internal.Client{ID: "uid-1", ClientID: "app.example.com", Name: "app.example.com"}}
+			if c.markers != nil {
+				info.ID = "other"
+				info.Attributes = c.markers.Attributes()
+			}
+			kc := &clientsServer{clients: []internal.ClientDetails{info}}
+			srv := httptest.NewServer(kc)
+			defer srv.Close()
+
+			r := &KeycloakClientReconciler{
+				Instances:             &Instances{Default: &internal.Keycloak{URL: srv.URL}},
+				DefaultDeletionPolicy: c.fallback,
+			}
+			m := &keycloakv1alpha1.KeycloakClient{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", UID: "uid-1"}}
+			m.Spec.Realm = "test"
+			m.Spec.Domain = "app.example.com"
+			m.Spec.DeletionPolicy = c.policy
+
+			require.NoError(t, r.removeClient(context.Background(), m))
+			assert.Equal(t, c.requests, kc.requests)
+			if len(kc.updates) == 0 {
+				return
+			}
+			update := kc.updates[0]
+			assert.Empty(t, update.Attributes[internal.AttrOwnerUID], "ownership markers are removed")
+			if c.disabled {
+				require.NotNil(t, update.Enabled)
+				assert.False(t, *update.Enabled)
+			} else {
+				assert.Nil(t, update.Enabled)
+			}
+		})
+	}
+}
+
+func TestKeycloakClientReconciler_removeClient_tolerated(t *testing.T) {
+	kc := &clientsServer{clients: []internal.ClientDetails{{Client: internal.Client{ID: "other", ClientID: "other", Name: "other"}}}}
+	srv := httptest.NewServer(kc)
+	defer srv.Close()
+
+	cases := []struct {
+		name        string
+		url         string
+		realm       string
+		annotations map[string]string
+		failed      bool
+	}{
+		{name: "client not found", url: srv.URL, realm: "test"},
+		{name: "realm not found", url: srv.URL, realm: "removed"},
+		{name: "keycloak unreachable", url: "http://127.0.0.1:1", realm: "test", failed: true},
+		{name: "forced", url: srv.URL, realm: "test", annotations: map[string]string{forceDeleteAnnotation: ""}, failed: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := &KeycloakClientReconciler{Instances: &Instances{Default: &internal.Keycloak{URL: c.url}}}
+			m := &keycloakv1alpha1.KeycloakClient{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", UID: "uid-1", Annotations: c.annotations}}
+			m.Spec.Realm = c.realm
+			m.Spec.Domain = "app.example.com"
+
+			err := r.removeClient(context.Background(), m)
+			assert.Equal(t, c.failed, err != nil, err)
+			assert.Empty(t, kc.requests)
+		})
+	}
+}
+
+func TestKeycloakClientReconciler_abandonRemoval(t *testing.T) {
+	cases := []struct {
+		name        string
+		timeout     time.Duration
+		deleted     time.Duration // ago
+		annotations map[string]string
+		event       string
+	}{
+		{name: "no timeout", deleted: time.Hour},
+		{name: "before timeout", timeout: time.Hour, deleted: time.Minute},
+		{name: "after timeout", timeout: time.Hour, deleted: 2 * time.Hour, event: "DeletionTimeout"},
+		{name: "forced", deleted: time.Second, annotations: map[string]string{forceDeleteAnnotation: "true"}, event: "ForceDeleted"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(1)
+			r := &KeycloakClientReconciler{Recorder: recorder, DeletionTimeout: c.timeout}
+			m := &keycloakv1alpha1.KeycloakClient{ObjectMeta: metav1.ObjectMeta{
+				Name:              "app",
+				Annotations:       c.annotations,
+				DeletionTimestamp: &metav1.Time{Time: time.Now().Add(-c.deleted)},
+			}}
+
+			abandoned := r.abandonRemoval(m, assert.AnError)
+			assert.Equal(t, c.event != "", abandoned)
+			if c.event == "" {
+				assert.Empty(t, recorder.Events)
+				return
+			}
+			require.Len(t, recorder.Events, 1)
+			assert.Contains(t, <-recorder.Events, c.event)
+		})
+	}
+}
+
+func TestKeycloakClientReconciler_reportIssuerMismatch(t *testing.T) {
+	const published = "https://sso.example.com/realms/test"
+	m := &keycloakv1alpha1.KeycloakClient{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"}}
+	k8s := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(m).WithStatusSubresource(m).Build()
+	recorder := record.NewFakeRecorder(10)
+	r := &KeycloakClientReconciler{Client: k8s, Recorder: recorder}
+	ctx := context.Background()
+
+	var events []string
+	for _, issuer := range []string{
+		"http://keycloak.keycloak.svc/realms/test",
+		"http://keycloak.keycloak.svc/realms/test",
+		published,
+		"http://keycloak:8080/realms/test",
+	} {
+		require.NoError(t, r.reportIssuerMismatch(ctx, m, issuer, published))
+		for len(recorder.Events) > 0 {
+			events = append(events, <-recorder.Events)
+		}
+	}
+	assert.Equal(t, []string{
+		`Warning IssuerMismatch Issuer of realm "http://keycloak.keycloak.svc/realms/test" does not match published URL "https://sso.example.com/realms/test"`,
+		`Warning IssuerMismatch Issuer of realm "http://keycloak:8080/realms/test" does not match published URL "https://sso.example.com/realms/test"`,
+	}, events)
+	condition := meta.FindStatusCondition(m.Status.Conditions, conditionIssuerMismatch)
+	require.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionTrue, condition.Status)
+	assert.Equal(t, "Mismatch", condition.Reason)
+
+	require.NoError(t, r.reportIssuerMismatch(ctx, m, published, published))
+	assert.True(t, meta.IsStatusConditionFalse(m.Status.Conditions, conditionIssuerMismatch))
+	assert.Empty(t, recorder.Events)
+}
+
+func TestKeycloakClientReconciler_Reconcile_discoveryFailed(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/realms/master/protocol/openid-connect/token" {
+			_ = json.NewEncoder(w).Encode(map[string]any{"token_type": "Bearer", "access_token": "token", "expires_in": 60})
+			return
+		}
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	m := &keycloakv1alpha1.KeycloakClient{ObjectMeta: metav1.ObjectMeta{
+		Name:       "app",
+		Namespace:  "default",
+		UID:        "uid-1",
+		Finalizers: []string{keycloakFinalizer},
+	}}
+	m.Spec.Realm = "test"
+	m.Spec.Domain = "app.example.com"
+	k8s := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(m).WithStatusSubresource(m).Build()
+	recorder := record.NewFakeRecorder(10)
+	r := &KeycloakClientReconciler{
+		Client:    k8s,
+		Scheme:    testScheme(t),
+		Instances: &Instances{Default: &internal.Keycloak{URL: srv.URL}},
+		Recorder:  recorder,
+	}
+	ctx := context.Background()
+	key := types.NamespacedName{Name: "app", Namespace: "default"}
+
+	for i := 0; i < 2; i++ {
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		require.Error(t, err)
+	}
+	assert.Equal(t, []string{
+		"GET /realms/test/.well-known/openid-configuration",
+		"GET /realms/test/.well-known/openid-configuration",
+	}, requests, "nothing is changed in Keycloak")
+	require.Len(t, recorder.Events, 1, "event is emitted once")
+	assert.Contains(t, <-recorder.Events, "Warning RealmUnavailable")
+
+	var saved keycloakv1alpha1.KeycloakClient
+	require.NoError(t, k8s.Get(ctx, key, &saved))
+	condition := meta.FindStatusCondition(saved.Status.Conditions, conditionRealmUnavailable)
+	require.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionTrue, condition.Status)
+	assert.Equal(t, "DiscoveryFailed", condition.Reason)
+	var secrets v12.SecretList
+	require.NoError(t, k8s.List(ctx, &secrets))
+	assert.Empty(t, secrets.Items, "secret is not created")
+}
+
+func TestClientKey(t *testing.T) {
+	stored := func(prefix string, key *internal.ClientKey) map[string][]byte {
+		names := [3]string{"privateKey", "certificate", "keyID"}
+		if prefix != "" {
+			names = [3]string{prefix + "PrivateKey", prefix + "Certificate", prefix + "KeyID"}
+		}
+		return map[string][]byte{names[0]: []byte(key.PrivateKey), names[1]: []byte(key.Certificate), names[2]: []byte(key.KeyID)}
+	}
+	merge := func(items ...map[string][]byte) map[string][]byte {
+		ans := make(map[string][]byte)
+		for _, item := range items {
+			for k, v := range item {
+				ans[k] = v
+			}
+		}
+		return ans
+	}
+	old, err := internal.GenerateClientKey("RS256", "app.example.com")
+	require.NoError(t, err)
+	fresh, err := internal.GenerateClientKey("RS256", "app.example.com")
+	require.NoError(t, err)
+	elliptic, err := internal.GenerateClientKey("ES256", "app.example.com")
+	require.NoError(t, err)
+
+	cases := []struct {
+		name      string
+		algorithm string
+		rotation  time.Duration // JWT rotation period
+		grace     *metav1.Duration
+		current   map[string][]byte
+		replaced  map[string][]byte
+		kept      *internal.ClientKey // expected current key, nil means generated
+		previous  *internal.ClientKey
+	}{
+		{name: "new secret"},
+		{name: "key kept", current: stored("", fresh), replaced: stored("", fresh), kept: fresh},
+		{name: "rotated in place", rotation: time.Nanosecond, current: stored("", old), replaced: stored("", old), previous: old},
+		{name: "algorithm changed", algorithm: "ES256", current: stored("", old), replaced: stored("", old), previous: old},
+		{name: "new version of secret", replaced: stored("", old), previous: old},
+		{name: "previous kept during grace period", current: merge(stored("", fresh), stored("previous", old)), kept: fresh, previous: old},
+		{name: "previous dropped after grace period", grace: &metav1.Duration{Duration: time.Nanosecond}, current: merge(stored("", fresh), stored("previous", old)), kept: fresh},
+		{name: "grace period disabled", rotation: time.Nanosecond, grace: &metav1.Duration{}, current: stored("", elliptic)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := &keycloakv1alpha1.KeycloakClient{}
+			m.Spec.Domain = "app.example.com"
+			m.Spec.ClientAuthenticator = internal.AuthenticatorJWT
+			m.Spec.JWT = &keycloakv1alpha1.ClientJWTSettings{Algorithm: c.algorithm}
+			if c.rotation > 0 {
+				m.Spec.JWT.RotationPeriod = &metav1.Duration{Duration: c.rotation}
+			}
+			if c.grace != nil {
+				m.Spec.Rotation = &keycloakv1alpha1.SecretRotation{GracePeriod: c.grace}
+			}
+
+			key, previous, err := clientKey(m, c.current, c.replaced)
+			require.NoError(t, err)
+			if c.kept != nil {
+				assert.Equal(t, c.kept, key)
+			} else {
+				assert.NotEqual(t, old.KeyID, key.KeyID, "new key is generated")
+				assert.NotEqual(t, fresh.KeyID, key.KeyID, "new key is generated")
+			}
+			assert.Equal(t, c.previous, previous)
+		})
+	}
+}
+
+func TestKeycloakClientReconciler_syncClientCertificate(t *testing.T) {
+	current, err := internal.GenerateClientKey("RS256", "app.example.com")
+	require.NoError(t, err)
+	previous, err := internal.GenerateClientKey("ES256", "app.example.com")
+	require.NoError(t, err)
+	bothKeys, err := internal.JWKS([]byte(current.Certificate), []byte(previous.Certificate))
+	require.NoError(t, err)
+	registered, _, err := internal.ParseCertificate([]byte(current.Certificate))
+	require.NoError(t, err)
+
+	cases := []struct {
+		name       string
+		registered string            // certificate registered in Keycloak
+		attributes map[string]string // attributes of client in Keycloak
+		previous   bool              // secret has previous key pair
+		uploaded   bool
+		update     map[string]string // expected update of attributes, nil means no update
+	}{
+		{name: "new certificate", uploaded: true},
+		{name: "certificate registered", registered: base64.StdEncoding.EncodeToString(registered.Raw)},
+		{
+			name:       "grace period started",
+			registered: base64.StdEncoding.EncodeToString(registered.Raw),
+			previous:   true,
+			update:     map[string]string{internal.AttrUseJWKSString: "true", internal.AttrJWKSString: bothKeys},
+		},
+		{
+			name:       "both keys registered",
+			registered: base64.StdEncoding.EncodeToString(registered.Raw),
+			attributes: map[string]string{internal.AttrUseJWKSString: "true", internal.AttrJWKSString: bothKeys},
+			previous:   true,
+		},
+		{
+			name:       "grace period over",
+			registered: base64.StdEncoding.EncodeToString(registered.Raw),
+			attributes: map[string]string{internal.AttrUseJWKSString: "true", internal.AttrJWKSString: bothKeys},
+			update:     map[string]string{internal.AttrUseJWKSString: "false", internal.AttrJWKSString: ""},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			info := internal.ClientDetails{Client: internal.Client{ID: "uid-1", ClientID: "app.example.com", Attributes: c.attributes}}
+			kc := &clientsServer{clients: []internal.ClientDetails{info}}
+			var uploaded bool
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/admin/realms/test/clients/uid-1/certificates/jwt.credential":
+					_ = json.NewEncoder(w).Encode(map[string]string{"certificate": c.registered})
+				case "/admin/realms/test/clients/uid-1/certificates/jwt.credential/upload-certificate":
+					uploaded = true
+				default:
+					kc.ServeHTTP(w, r)
+				}
+			}))
+			defer srv.Close()
+			keycloak := &internal.Keycloak{URL: srv.URL}
+
+			m := &keycloakv1alpha1.KeycloakClient{}
+			m.Spec.Realm = "test"
+			m.Spec.ClientAuthenticator = internal.AuthenticatorJWT
+			secret := &v12.Secret{Data: map[string][]byte{"certificate": []byte(current.Certificate), "keyID": []byte(current.KeyID)}}
+			if c.previous {
+				secret.Data["previousCertificate"] = []byte(previous.Certificate)
+			}
+
+			r := &KeycloakClientReconciler{}
+			require.NoError(t, r.syncClientCertificate(context.Background(), keycloak.Authorize(context.Background()), &info, m, secret))
+			assert.Equal(t, c.uploaded, uploaded)
+			if c.update == nil {
+				assert.Empty(t, kc.updates)
+				return
+			}
+			require.Len(t, kc.updates, 1)
+			assert.Equal(t, c.update, kc.updates[0].Attributes)
+		})
+	}
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/reddec/keycloak-ext-operator/internal"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+// desiredProtocolMappers returns all protocol mappers declared by manifest (directly or by preset).
+func desiredProtocolMappers(manifest *keycloakv1alpha1.KeycloakClient, clientID string) []internal.ProtocolMapper {
+	var ans []internal.ProtocolMapper
+	if preset, ok := internal.LookupPreset(manifest.Spec.Preset); ok && !manifest.IsSAML() {
+		ans = append(ans, preset.ProtocolMappers(clientID)...)
+	}
+	if manifest.IsSAML() && manifest.Spec.SAML != nil {
+		for _, m := range manifest.Spec.SAML.AttributeMappers {
+			ans = append(ans, internal.ProtocolMapper{
+				Name:           m.Name,
+				Protocol:       internal.ProtocolSAML,
+				ProtocolMapper: m.Type,
+				Config:         m.Config,
+			})
+		}
+	}
+	return ans
+}
+
+// syncProtocolMappers creates and updates protocol mappers of client (by internal ID) to match manifest. Mappers are
+// matched by name. Names of managed mappers are saved in status, and only such mappers are removed once they are not
+// declared anymore: mappers created manually (for example, in adopted client) are never touched.
+func (r *KeycloakClientReconciler) syncProtocolMappers(ctx context.Context, kClient *internal.AuthorizedKeycloak, info *internal.ClientDetails, manifest *keycloakv1alpha1.KeycloakClient) error {
+	desired := desiredProtocolMappers(manifest, info.ClientID)
+	if len(desired) == 0 && len(manifest.Status.ProtocolMappers) == 0 {
+		return nil
+	}
+	id := info.ID
+	realm := manifest.Spec.Realm
+	existent, err := kClient.ProtocolMappers(ctx, realm, id)
+	if err != nil {
+		return fmt.Errorf("list protocol mappers: %w", err)
+	}
+	var byName = make(map[string]internal.ProtocolMapper, len(existent))
+	for _, m := range existent {
+		byName[m.Name] = m
+	}
+
+	var managed = make([]string, 0, len(desired))
+	for _, m := range desired {
+		managed = append(managed, m.Name)
+		old, ok := byName[m.Name]
+		delete(byName, m.Name)
+		if !ok {
+			if err := kClient.CreateProtocolMapper(ctx, realm, id, m); err != nil {
+				return fmt.Errorf("create protocol mapper %q: %w", m.Name, err)
+			}
+			continue
+		}
+		if old.ProtocolMapper == m.ProtocolMapper && old.Protocol == m.Protocol && includes(old.Config, m.Config) {
+			continue
+		}
+		m.ID = old.ID
+		if err := kClient.UpdateProtocolMapper(ctx, realm, id, m); err != nil {
+			return fmt.Errorf("update protocol mapper %q: %w", m.Name, err)
+		}
+	}
+
+	for _, name := range manifest.Status.ProtocolMappers {
+		m, ok := byName[name]
+		if !ok {
+			continue
+		}
+		if err := kClient.DeleteProtocolMapper(ctx, realm, id, m.ID); err != nil {
+			return fmt.Errorf("delete protocol mapper %q: %w", m.Name, err)
+		}
+	}
+
+	sort.Strings(managed)
+	if sameSet(managed, manifest.Status.ProtocolMappers) {
+		return nil
+	}
+	manifest.Status.ProtocolMappers = managed
+	if err := r.Status().Update(ctx, manifest); err != nil {
+		return fmt.Errorf("update status: %w", err)
+	}
+	return nil
+}
+
+// syncClientScopes assigns default client scopes required by preset. Scopes are only added, never removed.
+func (r *KeycloakClientReconciler) syncClientScopes(ctx context.Context, kClient *internal.AuthorizedKeycloak, id string, manifest *keycloakv1alpha1.KeycloakClient) error {
+	preset, ok := internal.LookupPreset(manifest.Spec.Preset)
+	if !ok || len(preset.Scopes) == 0 || manifest.IsSAML() {
+		return nil
+	}
+	realm := manifest.Spec.Realm
+	assigned, err := kClient.DefaultClientScopes(ctx, realm, id)
+	if err != nil {
+		return fmt.Errorf("list default client scopes: %w", err)
+	}
+	var missed = make(map[string]bool, len(preset.Scopes))
+	for _, name := range preset.Scopes {
+		missed[name] = true
+	}
+	for _, scope := range assigned {
+		delete(missed, scope.Name)
+	}
+	if len(missed) == 0 {
+		return nil
+	}
+
+	scopes, err := kClient.ClientScopes(ctx, realm)
+	if err != nil {
+		return fmt.Errorf("list client scopes: %w", err)
+	}
+	for _, scope := range scopes {
+		if !missed[scope.Name] {
+			continue
+		}
+		if err := kClient.AddDefaultClientScope(ctx, realm, id, scope.ID); err != nil {
+			return fmt.Errorf("add default client scope %q: %w", scope.Name, err)
+		}
+		delete(missed, scope.Name)
+	}
+	for name := range missed {
+		log.Log.Info("Client scope required by preset not found in realm", "scope", name, "preset", manifest.Spec.Preset)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+func TestDesiredProtocolMappers(t *testing.T) {
+	attributeMappers := []keycloakv1alpha1.ProtocolMapper{{Name: "email", Type: "saml-user-property-mapper"}}
+	cases := []struct {
+		name    string
+		spec    keycloakv1alpha1.KeycloakClientSpec
+		mappers []string
+	}{
+		{name: "nothing declared"},
+		{name: "preset", spec: keycloakv1alpha1.KeycloakClientSpec{Preset: "oauth2-proxy"}, mappers: []string{"groups", "audience"}},
+		{name: "unknown preset", spec: keycloakv1alpha1.KeycloakClientSpec{Preset: "unknown"}},
+		{
+			name: "saml ignores preset",
+			spec: keycloakv1alpha1.KeycloakClientSpec{
+				Preset:   "grafana",
+				Protocol: "saml",
+				SAML:     &keycloakv1alpha1.SAMLSettings{AttributeMappers: attributeMappers},
+			},
+			mappers: []string{"email"},
+		},
+		{
+			name: "attribute mappers of oidc client are ignored",
+			spec: keycloakv1alpha1.KeycloakClientSpec{SAML: &keycloakv1alpha1.SAMLSettings{AttributeMappers: attributeMappers}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var names []string
+			for _, m := range desiredProtocolMappers(&keycloakv1alpha1.KeycloakClient{Spec: c.spec}, "app") {
+				names = append(names, m.Name)
+			}
+			assert.Equal(t, c.mappers, names)
+		})
+	}
+}
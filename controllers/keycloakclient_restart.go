@@ -0,0 +1,128 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v12 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+const secretHashAnnotation = "keycloak.k8s.reddec.net/secret-hash"
+
+// restartTargets patches pod templates of restart targets by hash of secret content if the content changed since last
+// reconcile. Hash of the first observed content is only recorded, so workloads are not restarted on adoption. Missing
+// named targets are skipped with warning event.
+func (r *KeycloakClientReconciler) restartTargets(ctx context.Context, secret *v12.Secret, m *keycloakv1alpha1.KeycloakClient) error {
+	hash := secretHash(secret.Data)
+	if hash == m.Status.SecretHash {
+		return nil
+	}
+	if m.Status.SecretHash != "" {
+		patch, err := json.Marshal(map[string]any{
+			"spec": map[string]any{
+				"template": map[string]any{
+					"metadata": map[string]any{
+						"annotations": map[string]string{secretHashAnnotation: hash},
+					},
+				},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("encode patch: %w", err)
+		}
+		for _, target := range m.Spec.RestartTargets {
+			workloads, err := r.findWorkloads(ctx, m.Namespace, target)
+			if err != nil {
+				return fmt.Errorf("find %s workloads: %w", target.Kind, err)
+			}
+			for _, workload := range workloads {
+				err := r.Patch(ctx, workload, client.RawPatch(types.MergePatchType, patch))
+				if errors.IsNotFound(err) {
+					// workload may be not created yet or already removed: it should not block reconcile
+					log.Log.Info("Restart target not found", "Kind", target.Kind, "Namespace", workload.GetNamespace(), "Name", workload.GetName())
+					r.Recorder.Eventf(m, v12.EventTypeWarning, "RestartTargetNotFound", "%s %s not found", target.Kind, workload.GetName())
+					continue
+				}
+				if err != nil {
+					return fmt.Errorf("restart %s %s: %w", target.Kind, workload.GetName(), err)
+				}
+				log.Log.Info("Workload restarted", "Kind", target.Kind, "Namespace", workload.GetNamespace(), "Name", workload.GetName())
+			}
+		}
+	}
+	m.Status.SecretHash = hash
+	if err := r.Status().Update(ctx, m); err != nil {
+		return fmt.Errorf("update status: %w", err)
+	}
+	return nil
+}
+
+// findWorkloads returns metadata of workloads matched by target.
+func (r *KeycloakClientReconciler) findWorkloads(ctx context.Context, namespace string, target keycloakv1alpha1.RestartTarget) ([]client.Object, error) {
+	gvk := appsv1.SchemeGroupVersion.WithKind(target.Kind)
+
+	if target.Selector == nil {
+		workload := &metav1.PartialObjectMetadata{}
+		workload.SetGroupVersionKind(gvk)
+		workload.Name = target.Name
+		workload.Namespace = namespace
+		return []client.Object{workload}, nil
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(target.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("parse selector: %w", err)
+	}
+	var list metav1.PartialObjectMetadataList
+	list.SetGroupVersionKind(gvk.GroupVersion().WithKind(target.Kind + "List"))
+	if err := r.List(ctx, &list, client.InNamespace(namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+	var workloads = make([]client.Object, 0, len(list.Items))
+	for i := range list.Items {
+		workloads = append(workloads, &list.Items[i])
+	}
+	return workloads, nil
+}
+
+// secretHash returns stable hash of secret content.
+func secretHash(data map[string][]byte) string {
+	var keys = make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		_, _ = fmt.Fprintf(h, "%s=%x\n", k, data[k])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
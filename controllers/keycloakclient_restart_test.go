@@ -0,0 +1,100 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	v12 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+func TestSecretHash(t *testing.T) {
+	base := map[string][]byte{"clientID": []byte("app"), "clientSecret": []byte("s3cr3t")}
+	cases := []struct {
+		name  string
+		data  map[string][]byte
+		equal bool
+	}{
+		{
+			name:  "same content",
+			data:  map[string][]byte{"clientSecret": []byte("s3cr3t"), "clientID": []byte("app")},
+			equal: true,
+		},
+		{
+			name: "changed value",
+			data: map[string][]byte{"clientID": []byte("app"), "clientSecret": []byte("other")},
+		},
+		{
+			name: "added key",
+			data: map[string][]byte{"clientID": []byte("app"), "clientSecret": []byte("s3cr3t"), "extra": nil},
+		},
+		{
+			name: "value moved between keys",
+			data: map[string][]byte{"clientID": []byte("s3cr3t"), "clientSecret": []byte("app")},
+		},
+		{
+			name: "separator in value",
+			data: map[string][]byte{"clientID": []byte("app\nclientSecret=s3cr3t")},
+		},
+		{
+			name: "empty",
+		},
+	}
+	expected := secretHash(base)
+	assert.Len(t, expected, 64)
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.equal, secretHash(c.data) == expected)
+		})
+	}
+}
+
+func TestKeycloakClientReconciler_restartTargets_missing(t *testing.T) {
+	m := &keycloakv1alpha1.KeycloakClient{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"}}
+	m.Spec.RestartTargets = []keycloakv1alpha1.RestartTarget{
+		{Kind: "Deployment", Name: "removed"},
+		{Kind: "Deployment", Name: "app"},
+	}
+	m.Status.SecretHash = "outdated"
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"}}
+	k8s := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(m, deployment).WithStatusSubresource(m).Build()
+	recorder := record.NewFakeRecorder(10)
+	r := &KeycloakClientReconciler{Client: k8s, Recorder: recorder}
+	ctx := context.Background()
+	secret := &v12.Secret{Data: map[string][]byte{"clientSecret": []byte("new")}}
+
+	require.NoError(t, r.restartTargets(ctx, secret, m))
+	require.Len(t, recorder.Events, 1)
+	assert.Equal(t, "Warning RestartTargetNotFound Deployment removed not found", <-recorder.Events)
+
+	var restarted appsv1.Deployment
+	require.NoError(t, k8s.Get(ctx, types.NamespacedName{Name: "app", Namespace: "default"}, &restarted))
+	assert.Equal(t, secretHash(secret.Data), restarted.Spec.Template.Annotations[secretHashAnnotation])
+	var saved keycloakv1alpha1.KeycloakClient
+	require.NoError(t, k8s.Get(ctx, types.NamespacedName{Name: "app", Namespace: "default"}, &saved))
+	assert.Equal(t, secretHash(secret.Data), saved.Status.SecretHash)
+}
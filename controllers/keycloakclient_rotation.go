@@ -0,0 +1,394 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	errors2 "errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/reddec/keycloak-ext-operator/internal"
+	"github.com/robfig/cron/v3"
+	v12 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+const (
+	secretVersionLabel   = "keycloak-secret-version"
+	supersededAnnotation = "keycloak.k8s.reddec.net/superseded-at"
+	rotateNowAnnotation  = "keycloak.k8s.reddec.net/rotate-now"
+	defaultGracePeriod   = 24 * time.Hour
+)
+
+// targetSecretName returns name of secret with current credentials.
+func targetSecretName(m *keycloakv1alpha1.KeycloakClient) string {
+	if m.Spec.Rotation != nil && m.Status.SecretVersion > 0 {
+		return m.VersionedSecretName(m.Status.SecretVersion)
+	}
+	return m.SecretName()
+}
+
+// immutableSecret returns true if secret with the name should be immutable. Only versions of rotated secret are
+// immutable, while secret with stable name is updated in place.
+func immutableSecret(m *keycloakv1alpha1.KeycloakClient, name string) bool {
+	return name != m.SecretName()
+}
+
+// previousVersionData returns content of the latest secret version before current one, or content of unversioned
+// secret (created before rotation was enabled). Returns nil if rotation is not enabled or there is no such secret.
+// Only secrets controlled by resource are used.
+func (r *KeycloakClientReconciler) previousVersionData(ctx context.Context, m *keycloakv1alpha1.KeycloakClient) (map[string][]byte, error) {
+	if m.Spec.Rotation == nil || m.Status.SecretVersion == 0 {
+		return nil, nil
+	}
+	var list v12.SecretList
+	err := r.List(ctx, &list, client.InNamespace(m.Namespace), client.MatchingLabels{"keycloak-cr": m.Name}, client.HasLabels{secretVersionLabel})
+	if err != nil {
+		return nil, fmt.Errorf("list secrets: %w", err)
+	}
+	var previous *v12.Secret
+	latest := 0
+	for i := range list.Items {
+		secret := &list.Items[i]
+		version, err := strconv.Atoi(secret.Labels[secretVersionLabel])
+		if err != nil || version >= m.Status.SecretVersion || version <= latest || !metav1.IsControlledBy(secret, m) {
+			continue
+		}
+		previous, latest = secret, version
+	}
+	if previous != nil {
+		return previous.Data, nil
+	}
+
+	var unversioned v12.Secret
+	err = r.Get(ctx, types.NamespacedName{Name: m.SecretName(), Namespace: m.Namespace}, &unversioned)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !metav1.IsControlledBy(&unversioned, m) {
+		return nil, nil
+	}
+	return unversioned.Data, nil
+}
+
+// syncAliasSecret keeps secret with stable (unversioned) name as a copy of the current version, so consumers which
+// refer to the secret by name keep working after rotation is enabled. Unlike versions, alias secret is mutable and
+// updated in place on rotation.
+func (r *KeycloakClientReconciler) syncAliasSecret(ctx context.Context, current *v12.Secret, m *keycloakv1alpha1.KeycloakClient) error {
+	name := m.SecretName()
+	if current.Name == name {
+		return nil
+	}
+	var labels = make(map[string]string, len(current.Labels))
+	for k, v := range current.Labels {
+		labels[k] = v
+	}
+	delete(labels, secretVersionLabel)
+
+	alias := &v12.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: m.Namespace}, alias)
+	if err == nil {
+		if err := r.claimSecret(alias, m); err != nil {
+			return err
+		}
+		if alias.Immutable == nil || !*alias.Immutable {
+			if reflect.DeepEqual(alias.Data, current.Data) && reflect.DeepEqual(alias.Labels, labels) {
+				return nil
+			}
+			alias.Labels = labels
+			alias.Data = current.Data
+			return r.Update(ctx, alias)
+		}
+		// secret created before rotation was enabled is immutable
+		if err := r.Delete(ctx, alias); err != nil {
+			return fmt.Errorf("delete immutable alias secret: %w", err)
+		}
+	} else if !errors.IsNotFound(err) {
+		return err
+	}
+
+	alias = &v12.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   m.Namespace,
+			Labels:      labels,
+			Annotations: m.Spec.Annotations,
+		},
+		Data: current.Data,
+		Type: "Opaque",
+	}
+	if err := ctrl.SetControllerReference(m, alias, r.Scheme); err != nil {
+		return fmt.Errorf("set controller refrence: %w", err)
+	}
+	log.Log.Info("Alias secret will be created", "Namespace", alias.Namespace, "Name", alias.Name)
+	return r.Create(ctx, alias)
+}
+
+// rotateCredentials bumps version of credentials if rotation is requested (by version in spec, by schedule, by max age
+// or by annotation). For client-secret authenticator new secret is generated in Keycloak, for client-jwt authenticator
+// new key pair will be generated for new secret.
+//
+// Rotation is resumable: target version is saved in status before any change in Keycloak, and the secret is regenerated
+// only while Keycloak still has the published one (from secret of current version). Failed attempt (for example,
+// conflict on status update) is finished on retry instead of regenerating secret again.
+func (r *KeycloakClientReconciler) rotateCredentials(ctx context.Context, kClient *internal.AuthorizedKeycloak, info *internal.ClientDetails, m *keycloakv1alpha1.KeycloakClient) error {
+	rotation := m.Spec.Rotation
+	current := m.Status.SecretVersion
+	_, rotateNow := m.Annotations[rotateNowAnnotation]
+	changed := false
+	if rotation == nil {
+		changed = current != 0 || m.Status.PendingSecretVersion != 0
+		m.Status.SecretVersion = 0
+		m.Status.PendingSecretVersion = 0
+	} else if m.Status.PendingSecretVersion == 0 {
+		due, err := rotationDue(m, time.Now())
+		if err != nil {
+			return err
+		}
+		if current == 0 || rotation.Version > current || due || rotateNow {
+			// first version uses existent credentials
+			m.Status.PendingSecretVersion = max(current+1, rotation.Version)
+			if err := r.Status().Update(ctx, m); err != nil {
+				return fmt.Errorf("save pending version: %w", err)
+			}
+		}
+	}
+
+	if pending := m.Status.PendingSecretVersion; pending > 0 {
+		if current > 0 {
+			published, found, err := r.publishedClientSecret(ctx, m)
+			if err != nil {
+				return err
+			}
+			// secret which differs from the published one has been already regenerated by failed attempt
+			if !found || published == info.Secret {
+				if err := r.regenerateSecret(ctx, kClient, info, m, published); err != nil {
+					return err
+				}
+			}
+		}
+		m.Status.SecretVersion = pending
+		m.Status.PendingSecretVersion = 0
+		m.Status.LastRotationTime = &metav1.Time{Time: time.Now()}
+		changed = true
+	}
+
+	if name := targetSecretName(m); changed || m.Status.SecretName != name {
+		m.Status.SecretName = name
+		if err := r.Status().Update(ctx, m); err != nil {
+			return fmt.Errorf("update status: %w", err)
+		}
+	}
+
+	if rotateNow {
+		// request handled (or ignored if rotation is not enabled)
+		patch := client.MergeFrom(m.DeepCopy())
+		delete(m.Annotations, rotateNowAnnotation)
+		if err := r.Patch(ctx, m, patch); err != nil {
+			return fmt.Errorf("remove %s annotation: %w", rotateNowAnnotation, err)
+		}
+	}
+	return nil
+}
+
+// publishedClientSecret returns client secret from secret of current version. Returns false if there is no such
+// secret, or it is not controlled by resource.
+func (r *KeycloakClientReconciler) publishedClientSecret(ctx context.Context, m *keycloakv1alpha1.KeycloakClient) (string, bool, error) {
+	var secret v12.Secret
+	err := r.Get(ctx, types.NamespacedName{Name: targetSecretName(m), Namespace: m.Namespace}, &secret)
+	if errors.IsNotFound(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("get published secret: %w", err)
+	}
+	if !metav1.IsControlledBy(&secret, m) {
+		return "", false, nil
+	}
+	return string(secret.Data["clientSecret"]), true, nil
+}
+
+// nextRotation returns time of next scheduled rotation or zero time if automatic rotation is not enabled.
+// If both schedule and max age are set, the earliest time is used.
+func nextRotation(m *keycloakv1alpha1.KeycloakClient) (time.Time, error) {
+	rotation := m.Spec.Rotation
+	if rotation == nil || m.Status.LastRotationTime == nil {
+		return time.Time{}, nil
+	}
+	last := m.Status.LastRotationTime.Time
+	var next time.Time
+	if rotation.Schedule != "" {
+		schedule, err := cron.ParseStandard(rotation.Schedule)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("parse rotation schedule: %w", err)
+		}
+		next = schedule.Next(last)
+	}
+	if rotation.MaxAge != nil && rotation.MaxAge.Duration > 0 {
+		expire := last.Add(rotation.MaxAge.Duration)
+		if next.IsZero() || expire.Before(next) {
+			next = expire
+		}
+	}
+	return next, nil
+}
+
+// rotationDue returns true if scheduled rotation time passed.
+func rotationDue(m *keycloakv1alpha1.KeycloakClient, now time.Time) (bool, error) {
+	next, err := nextRotation(m)
+	if err != nil {
+		return false, err
+	}
+	return !next.IsZero() && !now.Before(next), nil
+}
+
+// requeueAfter returns delay before next reconcile: regular resync interval, or time till next scheduled rotation or
+// till the end of grace period of the last rotation (to expire previous secret), whatever comes first. Resync is never
+// skipped, since neither Keycloak nor access policies are watched.
+func requeueAfter(m *keycloakv1alpha1.KeycloakClient) time.Duration {
+	const resync = time.Minute
+	next, err := nextRotation(m)
+	if err != nil || next.IsZero() {
+		return resync
+	}
+	// small gap after grace period, so the previous version is definitely expired on reconcile
+	if expire := m.Status.LastRotationTime.Add(gracePeriod(m) + time.Minute); time.Now().Before(expire) && expire.Before(next) {
+		next = expire
+	}
+	return min(resync, max(time.Until(next), time.Second))
+}
+
+// regenerateSecret generates new client secret in Keycloak (for client-secret authenticator only). Previous secret
+// stays valid in Keycloak during grace period, but only if it is the published one: secret which was never published
+// (for example, left by interrupted rotation) should not replace the rotated one.
+func (r *KeycloakClientReconciler) regenerateSecret(ctx context.Context, kClient *internal.AuthorizedKeycloak, info *internal.ClientDetails, m *keycloakv1alpha1.KeycloakClient, published string) error {
+	if m.IsSAML() || m.IsJWT() || m.Spec.ClientSecretRef != nil {
+		return nil
+	}
+	var previous string
+	if info.Secret != "" && info.Secret == published {
+		previous = info.Secret
+	}
+	if _, err := kClient.RotateSecret(ctx, m.Spec.Realm, info.ID, previous, gracePeriod(m)); err != nil {
+		return fmt.Errorf("regenerate client secret: %w", err)
+	}
+	updated, err := kClient.Get(ctx, m.Spec.Realm, info.ID)
+	if err != nil {
+		return fmt.Errorf("get client: %w", err)
+	}
+	*info = *updated
+	log.Log.Info("Client secret regenerated", "client_id", info.ClientID)
+	return nil
+}
+
+// expireRotatedSecret invalidates previous client secret in Keycloak once grace period is over.
+func (r *KeycloakClientReconciler) expireRotatedSecret(ctx context.Context, kClient *internal.AuthorizedKeycloak, info *internal.ClientDetails, m *keycloakv1alpha1.KeycloakClient) error {
+	previous, expiration := info.RotatedSecret()
+	if previous == "" || time.Now().Before(expiration) {
+		return nil
+	}
+	if err := kClient.InvalidateRotatedSecret(ctx, m.Spec.Realm, info.ID); err != nil && !errors2.Is(err, internal.ErrNotFound) {
+		return fmt.Errorf("invalidate rotated secret: %w", err)
+	}
+	delete(info.Attributes, internal.AttrRotatedSecret)
+	log.Log.Info("Previous client secret expired", "client_id", info.ClientID)
+	return nil
+}
+
+// previousSecret returns previous client secret if it is still valid.
+func previousSecret(info *internal.ClientDetails, now time.Time) string {
+	previous, expiration := info.RotatedSecret()
+	if previous == "" || !now.Before(expiration) {
+		return ""
+	}
+	return previous
+}
+
+func gracePeriod(m *keycloakv1alpha1.KeycloakClient) time.Duration {
+	if m.Spec.Rotation != nil && m.Spec.Rotation.GracePeriod != nil {
+		return m.Spec.Rotation.GracePeriod.Duration
+	}
+	return defaultGracePeriod
+}
+
+// cleanupSecretVersions marks previous versions of secret as superseded and removes them after grace period.
+// If rotation is disabled, the latest version (which alias secret was pointing to) is kept, while older ones are removed.
+func (r *KeycloakClientReconciler) cleanupSecretVersions(ctx context.Context, m *keycloakv1alpha1.KeycloakClient) error {
+	grace := gracePeriod(m)
+
+	var list v12.SecretList
+	err := r.List(ctx, &list, client.InNamespace(m.Namespace), client.MatchingLabels{"keycloak-cr": m.Name}, client.HasLabels{secretVersionLabel})
+	if err != nil {
+		return fmt.Errorf("list secrets: %w", err)
+	}
+	current := m.Status.SecretVersion
+	if m.Spec.Rotation == nil {
+		current = latestSecretVersion(list.Items, m)
+	}
+	for i := range list.Items {
+		secret := &list.Items[i]
+		version, err := strconv.Atoi(secret.Labels[secretVersionLabel])
+		if err != nil || version >= current || !metav1.IsControlledBy(secret, m) {
+			continue
+		}
+		superseded, err := time.Parse(time.RFC3339, secret.Annotations[supersededAnnotation])
+		if err != nil {
+			// metadata of immutable secret still can be changed
+			if secret.Annotations == nil {
+				secret.Annotations = make(map[string]string)
+			}
+			secret.Annotations[supersededAnnotation] = time.Now().UTC().Format(time.RFC3339)
+			if err := r.Update(ctx, secret); err != nil {
+				return fmt.Errorf("mark secret %s as superseded: %w", secret.Name, err)
+			}
+			continue
+		}
+		if time.Since(superseded) < grace {
+			continue
+		}
+		if err := r.Delete(ctx, secret); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("delete secret %s: %w", secret.Name, err)
+		}
+		log.Log.Info("Outdated secret version removed", "Namespace", secret.Namespace, "Name", secret.Name)
+	}
+	return nil
+}
+
+// latestSecretVersion returns the highest version among secrets controlled by resource (zero if there is no version).
+func latestSecretVersion(secrets []v12.Secret, m *keycloakv1alpha1.KeycloakClient) int {
+	latest := 0
+	for i := range secrets {
+		version, err := strconv.Atoi(secrets[i].Labels[secretVersionLabel])
+		if err == nil && version > latest && metav1.IsControlledBy(&secrets[i], m) {
+			latest = version
+		}
+	}
+	return latest
+}
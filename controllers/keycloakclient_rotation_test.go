@@ -0,0 +1,407 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/reddec/keycloak-ext-operator/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v12 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+func rotatedClient(rotation *keycloakv1alpha1.SecretRotation, last time.Time) *keycloakv1alpha1.KeycloakClient {
+	m := &keycloakv1alpha1.KeycloakClient{}
+	m.Spec.Rotation = rotation
+	if !last.IsZero() {
+		m.Status.LastRotationTime = &metav1.Time{Time: last}
+	}
+	return m
+}
+
+func TestNextRotation(t *testing.T) {
+	last := time.Date(2023, 1, 15, 10, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name     string
+		rotation *keycloakv1alpha1.SecretRotation
+		last     time.Time
+		next     time.Time
+	}{
+		{
+			name: "disabled",
+			last: last,
+		},
+		{
+			name:     "never rotated",
+			rotation: &keycloakv1alpha1.SecretRotation{MaxAge: &metav1.Duration{Duration: time.Hour}},
+		},
+		{
+			name:     "manual only",
+			rotation: &keycloakv1alpha1.SecretRotation{Version: 2},
+			last:     last,
+		},
+		{
+			name:     "schedule",
+			rotation: &keycloakv1alpha1.SecretRotation{Schedule: "0 3 1 * *"},
+			last:     last,
+			next:     time.Date(2023, 2, 1, 3, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "max age",
+			rotation: &keycloakv1alpha1.SecretRotation{MaxAge: &metav1.Duration{Duration: 48 * time.Hour}},
+			last:     last,
+			next:     last.Add(48 * time.Hour),
+		},
+		{
+			name:     "max age before schedule",
+			rotation: &keycloakv1alpha1.SecretRotation{Schedule: "0 3 1 * *", MaxAge: &metav1.Duration{Duration: 48 * time.Hour}},
+			last:     last,
+			next:     last.Add(48 * time.Hour),
+		},
+		{
+			name:     "schedule before max age",
+			rotation: &keycloakv1alpha1.SecretRotation{Schedule: "0 3 1 * *", MaxAge: &metav1.Duration{Duration: 2160 * time.Hour}},
+			last:     last,
+			next:     time.Date(2023, 2, 1, 3, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			next, err := nextRotation(rotatedClient(c.rotation, c.last))
+			require.NoError(t, err)
+			assert.True(t, c.next.Equal(next), "expected %v, got %v", c.next, next)
+		})
+	}
+
+	_, err := nextRotation(rotatedClient(&keycloakv1alpha1.SecretRotation{Schedule: "every day"}, last))
+	assert.Error(t, err)
+}
+
+func TestRotationDue(t *testing.T) {
+	last := time.Date(2023, 1, 15, 10, 0, 0, 0, time.UTC)
+	maxAge := &keycloakv1alpha1.SecretRotation{MaxAge: &metav1.Duration{Duration: time.Hour}}
+	cases := []struct {
+		name     string
+		rotation *keycloakv1alpha1.SecretRotation
+		now      time.Time
+		due      bool
+	}{
+		{name: "disabled", now: last.Add(time.Hour)},
+		{name: "manual only", rotation: &keycloakv1alpha1.SecretRotation{Version: 1}, now: last.Add(1000 * time.Hour)},
+		{name: "before", rotation: maxAge, now: last.Add(time.Hour - time.Second)},
+		{name: "exactly", rotation: maxAge, now: last.Add(time.Hour), due: true},
+		{name: "after", rotation: maxAge, now: last.Add(2 * time.Hour), due: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			due, err := rotationDue(rotatedClient(c.rotation, last), c.now)
+			require.NoError(t, err)
+			assert.Equal(t, c.due, due)
+		})
+	}
+}
+
+func TestRequeueAfter(t *testing.T) {
+	grace := &metav1.Duration{Duration: time.Hour}
+	cases := []struct {
+		name     string
+		rotation *keycloakv1alpha1.SecretRotation
+		last     time.Time
+		min, max time.Duration
+	}{
+		{
+			name: "not scheduled",
+			last: time.Now(),
+			min:  time.Minute,
+			max:  time.Minute,
+		},
+		{
+			name:     "far rotation",
+			rotation: &keycloakv1alpha1.SecretRotation{MaxAge: &metav1.Duration{Duration: 720 * time.Hour}, GracePeriod: grace},
+			last:     time.Now().Add(-2 * time.Hour),
+			min:      time.Minute,
+			max:      time.Minute,
+		},
+		{
+			name:     "far schedule",
+			rotation: &keycloakv1alpha1.SecretRotation{Schedule: "0 3 1 */3 *"},
+			last:     time.Now(),
+			min:      time.Minute,
+			max:      time.Minute,
+		},
+		{
+			name:     "rotation before resync",
+			rotation: &keycloakv1alpha1.SecretRotation{MaxAge: &metav1.Duration{Duration: time.Hour}, GracePeriod: grace},
+			last:     time.Now().Add(-time.Hour + 30*time.Second),
+			min:      29 * time.Second,
+			max:      30 * time.Second,
+		},
+		{
+			name:     "grace period ends before resync",
+			rotation: &keycloakv1alpha1.SecretRotation{MaxAge: &metav1.Duration{Duration: 720 * time.Hour}, GracePeriod: grace},
+			last:     time.Now().Add(-time.Hour - 30*time.Second),
+			min:      29 * time.Second,
+			max:      30 * time.Second,
+		},
+		{
+			name:     "overdue",
+			rotation: &keycloakv1alpha1.SecretRotation{MaxAge: &metav1.Duration{Duration: time.Hour}},
+			last:     time.Now().Add(-2 * time.Hour),
+			min:      time.Second,
+			max:      time.Second,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			delay := requeueAfter(rotatedClient(c.rotation, c.last))
+			assert.GreaterOrEqual(t, delay, c.min)
+			assert.LessOrEqual(t, delay, c.max)
+		})
+	}
+}
+
+func TestPreviousSecret(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	rotated := func(secret string, expiration time.Time) *internal.ClientDetails {
+		info := &internal.ClientDetails{Client: internal.Client{Attributes: map[string]string{}}}
+		if secret != "" {
+			info.Attributes[internal.AttrRotatedSecret] = secret
+			info.Attributes[internal.AttrRotatedExpirationTime] = strconv.FormatInt(expiration.Unix(), 10)
+		}
+		return info
+	}
+	cases := []struct {
+		name     string
+		info     *internal.ClientDetails
+		previous string
+	}{
+		{
+			name: "not rotated",
+			info: rotated("", time.Time{}),
+		},
+		{
+			name:     "valid",
+			info:     rotated("old", now.Add(time.Hour)),
+			previous: "old",
+		},
+		{
+			name: "expired",
+			info: rotated("old", now.Add(-time.Hour)),
+		},
+		{
+			name: "expires now",
+			info: rotated("old", now),
+		},
+		{
+			name: "no attributes",
+			info: &internal.ClientDetails{},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.previous, previousSecret(c.info, now))
+		})
+	}
+}
+
+func TestKeycloakClientReconciler_secretImmutability(t *testing.T) {
+	info := internal.ClientDetails{Client: internal.Client{ID: "uid-1", ClientID: "app.example.com", Name: "app.example.com"}, Secret: "new"}
+	kc := &clientsServer{clients: []internal.ClientDetails{info}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/realms/test/.well-known/openid-configuration" {
+			_ = json.NewEncoder(w).Encode(map[string]string{"issuer": "https://sso.example.com/realms/test"})
+			return
+		}
+		kc.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		name      string
+		rotation  *keycloakv1alpha1.SecretRotation
+		existent  *bool  // immutable flag of existent secret, nil means no secret
+		secret    string // name of target secret
+		recreated bool
+		immutable bool
+	}{
+		{name: "new secret", secret: "app"},
+		{name: "new version", rotation: &keycloakv1alpha1.SecretRotation{}, secret: "app-v1", immutable: true},
+		{name: "secret updated in place", existent: proto.Bool(false), secret: "app"},
+		{name: "legacy immutable secret", existent: proto.Bool(true), secret: "app", recreated: true},
+		{name: "version re-created", rotation: &keycloakv1alpha1.SecretRotation{}, existent: proto.Bool(true), secret: "app-v1", recreated: true, immutable: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := &keycloakv1alpha1.KeycloakClient{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", UID: "uid-1"}}
+			m.Spec.Realm = "test"
+			m.Spec.Domain = "app.example.com"
+			m.Spec.Rotation = c.rotation
+			if c.rotation != nil {
+				m.Status.SecretVersion = 1
+			}
+
+			builder := fake.NewClientBuilder().WithScheme(testScheme(t))
+			if c.existent != nil {
+				existent := &v12.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: c.secret, Namespace: "default"},
+					Immutable:  c.existent,
+					Data:       map[string][]byte{"clientSecret": []byte("old")},
+				}
+				require.NoError(t, controllerutil.SetControllerReference(m, existent, testScheme(t)))
+				builder = builder.WithObjects(existent)
+			}
+			var deleted bool
+			k8s := builder.WithInterceptorFuncs(interceptor.Funcs{
+				Delete: func(ctx context.Context, cl client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+					deleted = true
+					return cl.Delete(ctx, obj, opts...)
+				},
+			}).Build()
+			r := &KeycloakClientReconciler{
+				Client:    k8s,
+				Scheme:    k8s.Scheme(),
+				Instances: &Instances{Default: &internal.Keycloak{URL: srv.URL}},
+			}
+
+			secret, err := r.getOrCreateSecret(context.Background(), &info, m)
+			require.NoError(t, err)
+			if c.existent != nil {
+				require.NoError(t, r.updateSecret(context.Background(), secret, m))
+			}
+			assert.Equal(t, c.recreated, deleted)
+
+			var saved v12.Secret
+			require.NoError(t, k8s.Get(context.Background(), types.NamespacedName{Name: c.secret, Namespace: "default"}, &saved))
+			assert.Equal(t, "new", string(saved.Data["clientSecret"]))
+			require.NotNil(t, saved.Immutable)
+			assert.Equal(t, c.immutable, *saved.Immutable)
+		})
+	}
+}
+
+func TestKeycloakClientReconciler_cleanupSecretVersions(t *testing.T) {
+	expired := time.Now().Add(-2 * defaultGracePeriod).UTC().Format(time.RFC3339)
+	cases := []struct {
+		name     string
+		rotation *keycloakv1alpha1.SecretRotation
+		current  int
+		kept     []string
+	}{
+		{name: "rotation enabled", rotation: &keycloakv1alpha1.SecretRotation{}, current: 3, kept: []string{"app-v3"}},
+		{name: "rotation disabled", kept: []string{"app-v3"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := &keycloakv1alpha1.KeycloakClient{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", UID: "uid-1"}}
+			m.Spec.Rotation = c.rotation
+			m.Status.SecretVersion = c.current
+
+			builder := fake.NewClientBuilder().WithScheme(testScheme(t))
+			for version := 1; version <= 3; version++ {
+				secret := &v12.Secret{ObjectMeta: metav1.ObjectMeta{
+					Name:        m.VersionedSecretName(version),
+					Namespace:   "default",
+					Labels:      map[string]string{"keycloak-cr": "app", secretVersionLabel: strconv.Itoa(version)},
+					Annotations: map[string]string{supersededAnnotation: expired},
+				}}
+				require.NoError(t, controllerutil.SetControllerReference(m, secret, testScheme(t)))
+				builder = builder.WithObjects(secret)
+			}
+			k8s := builder.Build()
+			r := &KeycloakClientReconciler{Client: k8s, Scheme: k8s.Scheme()}
+
+			require.NoError(t, r.cleanupSecretVersions(context.Background(), m))
+			var list v12.SecretList
+			require.NoError(t, k8s.List(context.Background(), &list))
+			var kept []string
+			for _, secret := range list.Items {
+				kept = append(kept, secret.Name)
+			}
+			assert.Equal(t, c.kept, kept)
+		})
+	}
+}
+
+func TestKeycloakClientReconciler_rotateCredentials(t *testing.T) {
+	cases := []struct {
+		name      string
+		published string // client secret in secret of current version, empty means no secret
+		requests  []string
+	}{
+		{name: "published secret is kept during grace period", published: "old", requests: []string{"PUT uid-1", "POST uid-1/client-secret"}},
+		{name: "already regenerated by failed attempt", published: "older"},
+		{name: "no published secret", requests: []string{"POST uid-1/client-secret"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			info := internal.ClientDetails{Client: internal.Client{ID: "uid-1", ClientID: "app.example.com", Name: "app.example.com"}, Secret: "old"}
+			kc := &clientsServer{clients: []internal.ClientDetails{info}}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/admin/realms/test/clients/uid-1/client-secret" && r.Method == http.MethodPost {
+					kc.requests = append(kc.requests, "POST uid-1/client-secret")
+					kc.clients[0].Secret = "new"
+					_ = json.NewEncoder(w).Encode(map[string]string{"type": "secret", "value": "new"})
+					return
+				}
+				kc.ServeHTTP(w, r)
+			}))
+			defer srv.Close()
+
+			m := &keycloakv1alpha1.KeycloakClient{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", UID: "uid-1"}}
+			m.Spec.Realm = "test"
+			m.Spec.Domain = "app.example.com"
+			m.Spec.Rotation = &keycloakv1alpha1.SecretRotation{Version: 2}
+			m.Status.SecretVersion = 1
+			builder := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(m).WithStatusSubresource(m)
+			if c.published != "" {
+				secret := &v12.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: "app-v1", Namespace: "default"},
+					Data:       map[string][]byte{"clientSecret": []byte(c.published)},
+				}
+				require.NoError(t, controllerutil.SetControllerReference(m, secret, testScheme(t)))
+				builder = builder.WithObjects(secret)
+			}
+			k8s := builder.Build()
+			r := &KeycloakClientReconciler{Client: k8s, Scheme: k8s.Scheme()}
+			kClient := (&internal.Keycloak{URL: srv.URL}).Authorize(context.Background())
+
+			require.NoError(t, r.rotateCredentials(context.Background(), kClient, &info, m))
+			assert.Equal(t, c.requests, kc.requests)
+			assert.Equal(t, 2, m.Status.SecretVersion)
+			assert.Zero(t, m.Status.PendingSecretVersion)
+			if len(kc.updates) > 0 {
+				assert.Equal(t, "old", kc.updates[0].Attributes[internal.AttrRotatedSecret], "published secret stays valid")
+			}
+		})
+	}
+}
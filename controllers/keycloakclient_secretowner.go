@@ -0,0 +1,120 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	v12 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+const secretAdoptionAdopt = "adopt"
+
+var ErrSecretConflict = errors.New("secret is not owned by resource")
+
+// claimSecret checks that existent secret is controlled by resource. Secret without controller is adopted only if
+// allowed by spec.secretAdoption, secret controlled by anything else is never touched.
+func (r *KeycloakClientReconciler) claimSecret(secret *v12.Secret, m *keycloakv1alpha1.KeycloakClient) error {
+	return claimSecret(r.Scheme, secret, m, m.Spec.SecretAdoption)
+}
+
+// checkSecretsOwnership checks that existent secrets which may be written during reconcile are controlled by resource
+// or can be adopted. It's done before any change of credentials in Keycloak, so new credentials are never left
+// unpublished because of conflict.
+func (r *KeycloakClientReconciler) checkSecretsOwnership(ctx context.Context, m *keycloakv1alpha1.KeycloakClient) error {
+	for _, name := range outputSecretNames(m) {
+		secret := &v12.Secret{}
+		err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: m.Namespace}, secret)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := r.claimSecret(secret, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// outputSecretNames returns names of secrets which may be written during reconcile: secret with stable name, current
+// and pending versions of rotated secret, and the version which will be created on next rotation.
+func outputSecretNames(m *keycloakv1alpha1.KeycloakClient) []string {
+	names := []string{m.SecretName()}
+	if rotation := m.Spec.Rotation; rotation != nil {
+		for _, version := range []int{m.Status.SecretVersion, m.Status.PendingSecretVersion, max(m.Status.SecretVersion+1, rotation.Version)} {
+			if version > 0 {
+				names = append(names, m.VersionedSecretName(version))
+			}
+		}
+	}
+	return names
+}
+
+// claimSecret checks that existent secret is controlled by owner. Secret without controller is adopted (owner
+// reference is set, but not saved) only if adoption is adopt.
+func claimSecret(scheme *runtime.Scheme, secret *v12.Secret, owner client.Object, adoption string) error {
+	if metav1.IsControlledBy(secret, owner) {
+		return nil
+	}
+	if controller := metav1.GetControllerOf(secret); controller != nil {
+		return fmt.Errorf("%w: secret %s is controlled by %s %s", ErrSecretConflict, secret.Name, controller.Kind, controller.Name)
+	}
+	if adoption != secretAdoptionAdopt {
+		return fmt.Errorf("%w: secret %s already exists, set secretAdoption to adopt to take it over", ErrSecretConflict, secret.Name)
+	}
+	// owner reference is saved together with content of secret
+	if err := ctrl.SetControllerReference(owner, secret, scheme); err != nil {
+		return fmt.Errorf("set controller refrence: %w", err)
+	}
+	log.Log.Info("Existent secret will be adopted", "Namespace", secret.Namespace, "Name", secret.Name)
+	return nil
+}
+
+// reportSecretConflict sets SecretConflict condition (if conflict is not nil) or clears it.
+func (r *KeycloakClientReconciler) reportSecretConflict(ctx context.Context, m *keycloakv1alpha1.KeycloakClient, conflict error) error {
+	condition := metav1.Condition{
+		Type:   conditionSecretConflict,
+		Status: metav1.ConditionFalse,
+		Reason: "Owned",
+	}
+	if conflict != nil {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "NotOwned"
+		condition.Message = conflict.Error()
+	}
+	changed, err := r.setCondition(ctx, m, condition)
+	if err != nil {
+		return err
+	}
+	if changed && conflict != nil {
+		r.Recorder.Event(m, v12.EventTypeWarning, "SecretConflict", conflict.Error())
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/reddec/keycloak-ext-operator/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v12 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+func TestKeycloakClientReconciler_claimSecret(t *testing.T) {
+	controller := true
+	cases := []struct {
+		name     string
+		owners   []metav1.OwnerReference
+		adoption string
+		conflict bool
+		adopted  bool
+	}{
+		{
+			name:   "controlled by resource",
+			owners: []metav1.OwnerReference{{Kind: "KeycloakClient", Name: "app", UID: "uid-1", Controller: &controller}},
+		},
+		{
+			name:     "controlled by another resource",
+			owners:   []metav1.OwnerReference{{Kind: "KeycloakClient", Name: "app", UID: "uid-2", Controller: &controller}},
+			adoption: secretAdoptionAdopt,
+			conflict: true,
+		},
+		{
+			name:     "controlled by another kind",
+			owners:   []metav1.OwnerReference{{Kind: "SealedSecret", Name: "app", UID: "uid-3", Controller: &controller}},
+			adoption: secretAdoptionAdopt,
+			conflict: true,
+		},
+		{
+			name:     "not controlled",
+			conflict: true,
+		},
+		{
+			name:     "not controlled, owner only",
+			owners:   []metav1.OwnerReference{{Kind: "ConfigMap", Name: "app", UID: "uid-4"}},
+			conflict: true,
+		},
+		{
+			name:     "not controlled, adoption allowed",
+			adoption: secretAdoptionAdopt,
+			adopted:  true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := &KeycloakClientReconciler{Scheme: testScheme(t)}
+			m := &keycloakv1alpha1.KeycloakClient{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", UID: "uid-1"}}
+			m.Spec.SecretAdoption = c.adoption
+			secret := &v12.Secret{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", OwnerReferences: c.owners}}
+
+			err := r.claimSecret(secret, m)
+			if c.conflict {
+				assert.ErrorIs(t, err, ErrSecretConflict)
+				assert.Equal(t, c.owners, secret.OwnerReferences, "secret is not modified")
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, metav1.IsControlledBy(secret, m))
+			if c.adopted {
+				assert.Len(t, secret.OwnerReferences, 1)
+			}
+		})
+	}
+}
+
+func TestKeycloakClientReconciler_reportSecretConflict(t *testing.T) {
+	m := &keycloakv1alpha1.KeycloakClient{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Generation: 1}}
+	k8s := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(m).WithStatusSubresource(m).Build()
+	recorder := record.NewFakeRecorder(10)
+	r := &KeycloakClientReconciler{Client: k8s, Recorder: recorder}
+	ctx := context.Background()
+	conflict := ErrSecretConflict
+
+	steps := []struct {
+		name     string
+		conflict error
+		status   metav1.ConditionStatus
+		events   int
+	}{
+		{name: "conflict", conflict: conflict, status: metav1.ConditionTrue, events: 1},
+		{name: "the same conflict", conflict: conflict, status: metav1.ConditionTrue},
+		{name: "resolved", status: metav1.ConditionFalse},
+		{name: "conflict again", conflict: conflict, status: metav1.ConditionTrue, events: 1},
+	}
+	for _, step := range steps {
+		require.NoError(t, r.reportSecretConflict(ctx, m, step.conflict), step.name)
+		condition := meta.FindStatusCondition(m.Status.Conditions, conditionSecretConflict)
+		require.NotNil(t, condition, step.name)
+		assert.Equal(t, step.status, condition.Status, step.name)
+		assert.Len(t, recorder.Events, step.events, step.name)
+		for len(recorder.Events) > 0 {
+			<-recorder.Events
+		}
+	}
+}
+
+func TestKeycloakClientReconciler_checkSecretsOwnership(t *testing.T) {
+	cases := []struct {
+		name     string
+		secret   string
+		conflict bool
+	}{
+		{name: "alias", secret: "app", conflict: true},
+		{name: "current version", secret: "app-v1", conflict: true},
+		{name: "next version", secret: "app-v2", conflict: true},
+		{name: "unrelated version", secret: "app-v3"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := &keycloakv1alpha1.KeycloakClient{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", UID: "uid-1"}}
+			m.Spec.Rotation = &keycloakv1alpha1.SecretRotation{Version: 2}
+			m.Status.SecretVersion = 1
+			secret := &v12.Secret{ObjectMeta: metav1.ObjectMeta{Name: c.secret, Namespace: "default"}}
+			k8s := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(secret).Build()
+			r := &KeycloakClientReconciler{Client: k8s, Scheme: testScheme(t)}
+
+			err := r.checkSecretsOwnership(context.Background(), m)
+			if c.conflict {
+				assert.ErrorIs(t, err, ErrSecretConflict)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestKeycloakClientReconciler_Reconcile_secretConflict(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/realms/master/protocol/openid-connect/token":
+			_ = json.NewEncoder(w).Encode(map[string]any{"token_type": "Bearer", "access_token": "token", "expires_in": 60})
+		case "/realms/test/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(map[string]string{"issuer": "http://" + r.Host + "/realms/test"})
+		default:
+			requests = append(requests, r.Method+" "+r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	m := &keycloakv1alpha1.KeycloakClient{ObjectMeta: metav1.ObjectMeta{
+		Name:       "app",
+		Namespace:  "default",
+		UID:        "uid-1",
+		Finalizers: []string{keycloakFinalizer},
+	}}
+	m.Spec.Realm = "test"
+	m.Spec.Domain = "app.example.com"
+	m.Spec.Rotation = &keycloakv1alpha1.SecretRotation{Version: 2}
+	m.Status.SecretVersion = 1
+	next := &v12.Secret{ObjectMeta: metav1.ObjectMeta{Name: "app-v2", Namespace: "default"}}
+	k8s := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(m, next).WithStatusSubresource(m).Build()
+	r := &KeycloakClientReconciler{
+		Client:    k8s,
+		Scheme:    testScheme(t),
+		Instances: &Instances{Default: &internal.Keycloak{URL: srv.URL}},
+		Recorder:  record.NewFakeRecorder(10),
+	}
+	ctx := context.Background()
+	key := types.NamespacedName{Name: "app", Namespace: "default"}
+
+	result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	assert.NotZero(t, result.RequeueAfter)
+	assert.Empty(t, requests, "credentials in Keycloak are not changed")
+
+	var saved keycloakv1alpha1.KeycloakClient
+	require.NoError(t, k8s.Get(ctx, key, &saved))
+	assert.True(t, meta.IsStatusConditionTrue(saved.Status.Conditions, conditionSecretConflict))
+	assert.Equal(t, 1, saved.Status.SecretVersion)
+	assert.Zero(t, saved.Status.PendingSecretVersion)
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/reddec/keycloak-ext-operator/internal"
+	v12 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+const clientSecretIndex = ".spec.clientSecretRef.name"
+
+// findBySecret returns all clients in the same namespace which are referencing secret as client secret source.
+func (r *KeycloakClientReconciler) findBySecret(ctx context.Context, secret client.Object) []reconcile.Request {
+	var list keycloakv1alpha1.KeycloakClientList
+	err := r.List(ctx, &list, client.InNamespace(secret.GetNamespace()), client.MatchingFields{clientSecretIndex: secret.GetName()})
+	if err != nil {
+		log.FromContext(ctx).Error(err, "list clients by secret")
+		return nil
+	}
+	var requests = make([]reconcile.Request, 0, len(list.Items))
+	for _, item := range list.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: item.Name, Namespace: item.Namespace}})
+	}
+	return requests
+}
+
+// referencedSecret returns value of client secret from referenced secret.
+func (r *KeycloakClientReconciler) referencedSecret(ctx context.Context, manifest *keycloakv1alpha1.KeycloakClient) (string, error) {
+	ref := manifest.Spec.ClientSecretRef
+	var secret v12.Secret
+	if err := r.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: manifest.Namespace}, &secret); err != nil {
+		return "", fmt.Errorf("get secret %s: %w", ref.Name, err)
+	}
+	value, ok := secret.Data[ref.Key]
+	if !ok || len(value) == 0 {
+		return "", fmt.Errorf("key %q not found in secret %s", ref.Key, ref.Name)
+	}
+	return string(value), nil
+}
+
+// syncClientSecret pushes client secret from referenced secret to Keycloak if it differs.
+func (r *KeycloakClientReconciler) syncClientSecret(ctx context.Context, kClient *internal.AuthorizedKeycloak, info *internal.ClientDetails, manifest *keycloakv1alpha1.KeycloakClient) error {
+	if manifest.Spec.ClientSecretRef == nil || manifest.IsSAML() || manifest.IsJWT() {
+		return nil
+	}
+	secret, err := r.referencedSecret(ctx, manifest)
+	if err != nil {
+		return err
+	}
+	if secret == info.Secret {
+		return nil
+	}
+	if err := kClient.Update(ctx, info.ID, manifest.Spec.Realm, internal.ClientDraft{ClientSecret: secret}); err != nil {
+		return fmt.Errorf("update client secret: %w", err)
+	}
+	info.Secret = secret
+	log.Log.Info("Client secret synced with referenced secret", "client_id", info.ClientID)
+	return nil
+}
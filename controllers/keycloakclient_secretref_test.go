@@ -0,0 +1,142 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/reddec/keycloak-ext-operator/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v12 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+func testScheme(t *testing.T) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, keycloakv1alpha1.AddToScheme(scheme))
+	return scheme
+}
+
+func TestKeycloakClientReconciler_referencedSecret(t *testing.T) {
+	k8s := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(
+		&v12.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "creds", Namespace: "default"},
+			Data:       map[string][]byte{"secret": []byte("s3cr3t"), "empty": nil},
+		},
+		&v12.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "foreign", Namespace: "other"},
+			Data:       map[string][]byte{"secret": []byte("foreign")},
+		},
+	).Build()
+	r := &KeycloakClientReconciler{Client: k8s}
+
+	cases := []struct {
+		name   string
+		ref    v12.SecretKeySelector
+		secret string
+	}{
+		{name: "value", ref: v12.SecretKeySelector{LocalObjectReference: v12.LocalObjectReference{Name: "creds"}, Key: "secret"}, secret: "s3cr3t"},
+		{name: "missing key", ref: v12.SecretKeySelector{LocalObjectReference: v12.LocalObjectReference{Name: "creds"}, Key: "unknown"}},
+		{name: "empty value", ref: v12.SecretKeySelector{LocalObjectReference: v12.LocalObjectReference{Name: "creds"}, Key: "empty"}},
+		{name: "missing secret", ref: v12.SecretKeySelector{LocalObjectReference: v12.LocalObjectReference{Name: "unknown"}, Key: "secret"}},
+		{name: "other namespace", ref: v12.SecretKeySelector{LocalObjectReference: v12.LocalObjectReference{Name: "foreign"}, Key: "secret"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := &keycloakv1alpha1.KeycloakClient{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"}}
+			m.Spec.ClientSecretRef = &c.ref
+			secret, err := r.referencedSecret(context.Background(), m)
+			if c.secret == "" {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, c.secret, secret)
+		})
+	}
+}
+
+func TestKeycloakClientReconciler_syncClientSecret(t *testing.T) {
+	cases := []struct {
+		name          string
+		protocol      string
+		authenticator string
+		ref           bool
+		current       string
+		pushed        string
+	}{
+		{name: "changed", ref: true, current: "old", pushed: "s3cr3t"},
+		{name: "unchanged", ref: true, current: "s3cr3t"},
+		{name: "no reference", current: "old"},
+		{name: "saml", ref: true, protocol: "saml", current: "old"},
+		{name: "private-key JWT", ref: true, authenticator: "client-jwt", current: "old"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var pushed []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodPost && r.URL.Path == "/realms/master/protocol/openid-connect/token":
+					_ = json.NewEncoder(w).Encode(map[string]any{"token_type": "Bearer", "access_token": "token", "expires_in": 60})
+				case r.Method == http.MethodPut && r.URL.Path == "/admin/realms/test/clients/client-id":
+					var draft internal.ClientDraft
+					_ = json.NewDecoder(r.Body).Decode(&draft)
+					pushed = append(pushed, draft.ClientSecret)
+					w.WriteHeader(http.StatusNoContent)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer srv.Close()
+
+			k8s := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(&v12.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "creds", Namespace: "default"},
+				Data:       map[string][]byte{"secret": []byte("s3cr3t")},
+			}).Build()
+			r := &KeycloakClientReconciler{Client: k8s}
+			m := &keycloakv1alpha1.KeycloakClient{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"}}
+			m.Spec.Realm = "test"
+			m.Spec.Protocol = c.protocol
+			m.Spec.ClientAuthenticator = c.authenticator
+			if c.ref {
+				m.Spec.ClientSecretRef = &v12.SecretKeySelector{LocalObjectReference: v12.LocalObjectReference{Name: "creds"}, Key: "secret"}
+			}
+			info := &internal.ClientDetails{Client: internal.Client{ID: "client-id", ClientID: "app"}, Secret: c.current}
+
+			kClient := (&internal.Keycloak{URL: srv.URL}).Authorize(context.Background())
+			require.NoError(t, r.syncClientSecret(context.Background(), kClient, info, m))
+			if c.pushed == "" {
+				assert.Empty(t, pushed)
+				assert.Equal(t, c.current, info.Secret)
+				return
+			}
+			assert.Equal(t, []string{c.pushed}, pushed)
+			assert.Equal(t, c.pushed, info.Secret)
+		})
+	}
+}
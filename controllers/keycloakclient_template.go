@@ -0,0 +1,126 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/reddec/keycloak-ext-operator/internal"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+// secretTemplateContext is a value available in secret templates.
+type secretTemplateContext struct {
+	ClientID     string
+	ClientSecret string
+	Realm        string
+	RealmURL     string
+	DiscoveryURL string
+	Domain       string
+	SecretName   string
+	Client       *internal.ClientDetails
+	Data         map[string]string
+}
+
+var secretTemplateFuncs = template.FuncMap{
+	"join":      strings.Join,
+	"upper":     strings.ToUpper,
+	"lower":     strings.ToLower,
+	"quote":     strconv.Quote,
+	"b64enc":    func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"b64urlenc": func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) },
+	"default": func(def string, value string) string {
+		if value == "" {
+			return def
+		}
+		return value
+	},
+}
+
+// renderSecretTemplate renders secret templates of preset and manifest (if any) and adds results to data.
+// All templates are rendered using generated data only, so templates can not refer to each other.
+func renderSecretTemplate(info *internal.ClientDetails, m *keycloakv1alpha1.KeycloakClient, data map[string][]byte) (map[string][]byte, error) {
+	var templates = make(map[string]string)
+	if preset, ok := internal.LookupPreset(m.Spec.Preset); ok && !m.IsSAML() {
+		for k, v := range preset.SecretTemplate {
+			templates[k] = v
+		}
+	}
+	for k, v := range m.Spec.SecretTemplate {
+		templates[k] = v
+	}
+	if len(templates) == 0 {
+		return data, nil
+	}
+	var generated = make(map[string]string, len(data))
+	for k, v := range data {
+		generated[k] = string(v)
+	}
+	ctx := secretTemplateContext{
+		ClientID:     info.ClientID,
+		ClientSecret: generated["clientSecret"],
+		Realm:        m.Spec.Realm,
+		RealmURL:     generated["realmURL"],
+		DiscoveryURL: generated["discoveryURL"],
+		Domain:       m.Spec.Domain,
+		SecretName:   m.SecretName(),
+		Client:       info,
+		Data:         generated,
+	}
+
+	for key, text := range templates {
+		tpl, err := template.New(key).Funcs(secretTemplateFuncs).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("parse template %q: %w", key, err)
+		}
+		var out bytes.Buffer
+		if err := tpl.Execute(&out, ctx); err != nil {
+			return nil, fmt.Errorf("render template %q: %w", key, err)
+		}
+		data[key] = out.Bytes()
+	}
+	return data, nil
+}
+
+// addGeneratedValues adds random values declared by preset and manifest to data. Values from current content of secret
+// are preserved.
+func addGeneratedValues(m *keycloakv1alpha1.KeycloakClient, data, current map[string][]byte) {
+	var values []keycloakv1alpha1.GeneratedValue
+	if preset, ok := internal.LookupPreset(m.Spec.Preset); ok && !m.IsSAML() {
+		for key, encoding := range preset.RandomValues {
+			values = append(values, keycloakv1alpha1.GeneratedValue{Key: key, Encoding: encoding})
+		}
+	}
+	values = append(values, m.Spec.GeneratedValues...)
+	for _, v := range values {
+		if old, ok := current[v.Key]; ok && len(old) > 0 {
+			data[v.Key] = old
+			continue
+		}
+		length := v.Length
+		if length <= 0 {
+			length = 32
+		}
+		data[v.Key] = []byte(internal.GenerateRandom(length, v.Encoding))
+	}
+}
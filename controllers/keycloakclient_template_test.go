@@ -0,0 +1,183 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	"github.com/reddec/keycloak-ext-operator/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+func TestRenderSecretTemplate(t *testing.T) {
+	info := &internal.ClientDetails{Client: internal.Client{ClientID: "app-client"}, Secret: "s3cr3t"}
+	cases := []struct {
+		name     string
+		spec     keycloakv1alpha1.KeycloakClientSpec
+		expected map[string]string // checked keys only
+		invalid  bool
+	}{
+		{
+			name:     "no templates",
+			expected: map[string]string{"clientSecret": "s3cr3t"},
+		},
+		{
+			name: "context and functions",
+			spec: keycloakv1alpha1.KeycloakClientSpec{SecretTemplate: map[string]string{
+				"url":   "https://{{ .Domain }}/{{ .Realm }}/{{ .ClientID | upper }}",
+				"auth":  `{{ printf "%s:%s" .ClientID .ClientSecret | b64enc }}`,
+				"scope": `{{ default "openid" .Data.scope }}`,
+				"env":   `ISSUER={{ .Data.issuer | quote }}`,
+				"name":  "{{ .SecretName }}",
+			}},
+			expected: map[string]string{
+				"url":          "https://app.example.com/test/APP-CLIENT",
+				"auth":         "YXBwLWNsaWVudDpzM2NyM3Q=",
+				"scope":        "openid",
+				"env":          `ISSUER="https://keycloak/realms/test"`,
+				"clientSecret": "s3cr3t",
+				"name":         "app",
+			},
+		},
+		{
+			name: "preset",
+			spec: keycloakv1alpha1.KeycloakClientSpec{Preset: "gitea"},
+			expected: map[string]string{
+				"key":             "app-client",
+				"secret":          "s3cr3t",
+				"autoDiscoverUrl": "https://keycloak/realms/test/.well-known/openid-configuration",
+			},
+		},
+		{
+			name:     "manifest overrides preset",
+			spec:     keycloakv1alpha1.KeycloakClientSpec{Preset: "gitea", SecretTemplate: map[string]string{"key": "custom"}},
+			expected: map[string]string{"key": "custom", "secret": "s3cr3t"},
+		},
+		{
+			name:     "argocd refers to own secret",
+			spec:     keycloakv1alpha1.KeycloakClientSpec{Preset: "argocd"},
+			expected: map[string]string{"oidc.config": "name: Keycloak\nissuer: https://keycloak/realms/test\nclientID: app-client\nclientSecret: $app:clientSecret\nrequestedScopes: [\"openid\", \"profile\", \"email\", \"groups\"]\n"},
+		},
+		{
+			name:     "saml ignores preset",
+			spec:     keycloakv1alpha1.KeycloakClientSpec{Preset: "gitea", Protocol: "saml"},
+			expected: map[string]string{"key": ""},
+		},
+		{
+			name: "templates do not see each other",
+			spec: keycloakv1alpha1.KeycloakClientSpec{SecretTemplate: map[string]string{
+				"a": "value",
+				"b": "{{ .Data.a }}",
+			}},
+			invalid: true,
+		},
+		{
+			name:    "parse error",
+			spec:    keycloakv1alpha1.KeycloakClientSpec{SecretTemplate: map[string]string{"x": "{{ .ClientID "}},
+			invalid: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := &keycloakv1alpha1.KeycloakClient{ObjectMeta: metav1.ObjectMeta{Name: "app"}, Spec: c.spec}
+			m.Spec.Realm = "test"
+			m.Spec.Domain = "app.example.com"
+			data := map[string][]byte{
+				"clientSecret": []byte("s3cr3t"),
+				"issuer":       []byte("https://keycloak/realms/test"),
+				"discoveryURL": []byte("https://keycloak/realms/test/.well-known/openid-configuration"),
+				"scope":        nil,
+			}
+			out, err := renderSecretTemplate(info, m, data)
+			if c.invalid {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			for key, value := range c.expected {
+				assert.Equal(t, value, string(out[key]), key)
+			}
+		})
+	}
+}
+
+func TestAddGeneratedValues(t *testing.T) {
+	cases := []struct {
+		name    string
+		preset  string
+		values  []keycloakv1alpha1.GeneratedValue
+		current map[string][]byte
+		lengths map[string]int // expected length of encoded values
+		kept    map[string]string
+	}{
+		{
+			name:    "nothing declared",
+			lengths: map[string]int{},
+		},
+		{
+			name: "defaults",
+			values: []keycloakv1alpha1.GeneratedValue{
+				{Key: "hex"},
+				{Key: "short", Length: 4},
+				{Key: "base64", Encoding: internal.EncodingBase64},
+				{Key: "base64url", Length: 16, Encoding: internal.EncodingBase64URL},
+			},
+			lengths: map[string]int{"hex": 64, "short": 8, "base64": 44, "base64url": 24},
+		},
+		{
+			name:    "preset",
+			preset:  "oauth2-proxy",
+			lengths: map[string]int{"OAUTH2_PROXY_COOKIE_SECRET": 44},
+		},
+		{
+			name:    "current values are kept",
+			preset:  "oauth2-proxy",
+			values:  []keycloakv1alpha1.GeneratedValue{{Key: "cookie"}, {Key: "empty"}},
+			current: map[string][]byte{"OAUTH2_PROXY_COOKIE_SECRET": []byte("old-cookie"), "cookie": []byte("old"), "empty": nil},
+			lengths: map[string]int{"empty": 64},
+			kept:    map[string]string{"OAUTH2_PROXY_COOKIE_SECRET": "old-cookie", "cookie": "old"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := &keycloakv1alpha1.KeycloakClient{Spec: keycloakv1alpha1.KeycloakClientSpec{Preset: c.preset, GeneratedValues: c.values}}
+			data := map[string][]byte{"clientSecret": []byte("s3cr3t")}
+			addGeneratedValues(m, data, c.current)
+
+			assert.Len(t, data, 1+len(c.lengths)+len(c.kept))
+			for key, length := range c.lengths {
+				assert.Len(t, data[key], length, key)
+			}
+			for key, value := range c.kept {
+				assert.Equal(t, value, string(data[key]), key)
+			}
+		})
+	}
+
+	t.Run("random", func(t *testing.T) {
+		m := &keycloakv1alpha1.KeycloakClient{}
+		m.Spec.GeneratedValues = []keycloakv1alpha1.GeneratedValue{{Key: "a"}}
+		first, second := map[string][]byte{}, map[string][]byte{}
+		addGeneratedValues(m, first, nil)
+		addGeneratedValues(m, second, nil)
+		assert.NotEqual(t, first["a"], second["a"])
+	})
+}
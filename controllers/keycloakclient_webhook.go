@@ -0,0 +1,250 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/robfig/cron/v3"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+const clientIDIndex = ".spec.clientID"
+
+//+kubebuilder:webhook:path=/validate-keycloak-k8s-reddec-net-v1alpha1-keycloakclient,mutating=false,failurePolicy=fail,sideEffects=None,groups=keycloak.k8s.reddec.net,resources=keycloakclients,verbs=create;update,versions=v1alpha1,name=vkeycloakclient.kb.io,admissionReviewVersions=v1
+
+// KeycloakClientValidator validates KeycloakClient on admission: format of fields, immutable fields, uniqueness of
+// clientId in realm across the cluster and access policies.
+type KeycloakClientValidator struct {
+	Client   client.Reader
+	Policies *AccessPolicies
+}
+
+var _ admission.CustomValidator = &KeycloakClientValidator{}
+
+func (v *KeycloakClientValidator) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	err := mgr.GetFieldIndexer().IndexField(context.Background(), &keycloakv1alpha1.KeycloakClient{}, clientIDIndex, func(object client.Object) []string {
+		return []string{clientIDKey(object.(*keycloakv1alpha1.KeycloakClient))}
+	})
+	if err != nil {
+		return fmt.Errorf("index clientID: %w", err)
+	}
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&keycloakv1alpha1.KeycloakClient{}).
+		WithValidator(v).
+		Complete()
+}
+
+func (v *KeycloakClientValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	m, err := asClient(obj)
+	if err != nil {
+		return nil, err
+	}
+	return nil, v.validate(ctx, nil, m)
+}
+
+func (v *KeycloakClientValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	old, err := asClient(oldObj)
+	if err != nil {
+		return nil, err
+	}
+	m, err := asClient(newObj)
+	if err != nil {
+		return nil, err
+	}
+	// metadata changes (finalizers, annotations) should not be blocked, otherwise invalid clients can not be removed
+	if m.DeletionTimestamp != nil || equality.Semantic.DeepEqual(old.Spec, m.Spec) {
+		return nil, nil
+	}
+	return nil, v.validate(ctx, old, m)
+}
+
+func (v *KeycloakClientValidator) ValidateDelete(context.Context, runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (v *KeycloakClientValidator) validate(ctx context.Context, old, m *keycloakv1alpha1.KeycloakClient) error {
+	errs := validateClientSpec(m)
+	if old != nil {
+		errs = append(errs, validateImmutable(old, m)...)
+	}
+	if len(errs) == 0 {
+		duplicate, err := v.duplicateClientID(ctx, m)
+		if err != nil {
+			return err
+		}
+		errs = append(errs, duplicate...)
+	}
+	if len(errs) > 0 {
+		return apierrors.NewInvalid(keycloakv1alpha1.GroupVersion.WithKind("KeycloakClient").GroupKind(), m.Name, errs)
+	}
+	return v.Policies.Check(ctx, m)
+}
+
+// duplicateClientID checks that no other resource in cluster manages client with the same clientId in the same realm.
+func (v *KeycloakClientValidator) duplicateClientID(ctx context.Context, m *keycloakv1alpha1.KeycloakClient) (field.ErrorList, error) {
+	var list keycloakv1alpha1.KeycloakClientList
+	if err := v.Client.List(ctx, &list, client.MatchingFields{clientIDIndex: clientIDKey(m)}); err != nil {
+		return nil, fmt.Errorf("list clients: %w", err)
+	}
+	for _, item := range list.Items {
+		if item.Namespace == m.Namespace && item.Name == m.Name {
+			continue
+		}
+		path := field.NewPath("spec", "domain")
+		if m.IsSAML() {
+			path = field.NewPath("spec", "saml", "entityID")
+		}
+		return field.ErrorList{
+			field.Duplicate(path, fmt.Sprintf("clientId %q in realm %q is already used by %s/%s", m.ClientID(), m.Spec.Realm, item.Namespace, item.Name)),
+		}, nil
+	}
+	return nil, nil
+}
+
+// clientIDKey identifies Keycloak client across instances: instance, realm and clientId.
+func clientIDKey(m *keycloakv1alpha1.KeycloakClient) string {
+	return instanceKey(m.Namespace, m.Spec.InstanceRef) + "|" + m.Spec.Realm + "|" + m.ClientID()
+}
+
+// instanceKey identifies Keycloak instance referenced from namespace (and its session in pool). Empty for default
+// instance.
+func instanceKey(namespace string, ref *keycloakv1alpha1.InstanceReference) string {
+	if ref == nil {
+		return ""
+	}
+	if ref.Kind == clusterInstanceKind {
+		return ref.Kind + "/" + ref.Name
+	}
+	return "KeycloakInstance/" + namespace + "/" + ref.Name
+}
+
+func validateClientSpec(m *keycloakv1alpha1.KeycloakClient) field.ErrorList {
+	var errs field.ErrorList
+	spec := field.NewPath("spec")
+	if m.Spec.Realm == "" {
+		errs = append(errs, field.Required(spec.Child("realm"), ""))
+	}
+	errs = append(errs, validateDomain(spec.Child("domain"), m.Spec.Domain)...)
+	if name := m.Spec.SecretName; name != "" {
+		for _, msg := range validation.IsDNS1123Subdomain(name) {
+			errs = append(errs, field.Invalid(spec.Child("secretName"), name, msg))
+		}
+	}
+	for key, value := range m.Spec.Labels {
+		for _, msg := range validation.IsQualifiedName(key) {
+			errs = append(errs, field.Invalid(spec.Child("labels"), key, msg))
+		}
+		for _, msg := range validation.IsValidLabelValue(value) {
+			errs = append(errs, field.Invalid(spec.Child("labels").Key(key), value, msg))
+		}
+	}
+	for key := range m.Spec.Annotations {
+		for _, msg := range validation.IsQualifiedName(strings.ToLower(key)) {
+			errs = append(errs, field.Invalid(spec.Child("annotations"), key, msg))
+		}
+	}
+	for i, value := range m.Spec.GeneratedValues {
+		for _, msg := range validation.IsConfigMapKey(value.Key) {
+			errs = append(errs, field.Invalid(spec.Child("generatedValues").Index(i).Child("key"), value.Key, msg))
+		}
+	}
+	for key := range m.Spec.SecretTemplate {
+		for _, msg := range validation.IsConfigMapKey(key) {
+			errs = append(errs, field.Invalid(spec.Child("secretTemplate"), key, msg))
+		}
+	}
+	if rotation := m.Spec.Rotation; rotation != nil && rotation.Schedule != "" {
+		if _, err := cron.ParseStandard(rotation.Schedule); err != nil {
+			errs = append(errs, field.Invalid(spec.Child("rotation", "schedule"), rotation.Schedule, err.Error()))
+		}
+	}
+	if m.Spec.PublicClient {
+		public := spec.Child("publicClient")
+		switch {
+		case m.IsSAML():
+			errs = append(errs, field.Invalid(public, true, "not supported for saml"))
+		case m.IsJWT():
+			errs = append(errs, field.Invalid(public, true, "public client can not use client-jwt"))
+		case m.Spec.ClientSecretRef != nil:
+			errs = append(errs, field.Invalid(public, true, "public client has no client secret"))
+		case m.Spec.ServiceAccount || m.Spec.Authorization != nil:
+			errs = append(errs, field.Invalid(public, true, "public client can not have service account or authorization"))
+		}
+	}
+	for i, target := range m.Spec.RestartTargets {
+		if (target.Name == "") == (target.Selector == nil) {
+			errs = append(errs, field.Invalid(spec.Child("restartTargets").Index(i), target.Name, "exactly one of name or selector should be set"))
+		}
+	}
+	return errs
+}
+
+// validateDomain checks that domain is a host name with optional port and without scheme or path.
+func validateDomain(path *field.Path, domain string) field.ErrorList {
+	if domain == "" {
+		return field.ErrorList{field.Required(path, "")}
+	}
+	if strings.Contains(domain, "://") {
+		return field.ErrorList{field.Invalid(path, domain, "should not contain scheme")}
+	}
+	if strings.ContainsAny(domain, "/?#") {
+		return field.ErrorList{field.Invalid(path, domain, "should not contain path")}
+	}
+	host := domain
+	if h, _, err := net.SplitHostPort(domain); err == nil {
+		host = h
+	}
+	var errs field.ErrorList
+	for _, msg := range validation.IsDNS1123Subdomain(strings.ToLower(host)) {
+		errs = append(errs, field.Invalid(path, domain, msg))
+	}
+	return errs
+}
+
+// validateImmutable rejects changes which would make operator manage another Keycloak client.
+func validateImmutable(old, m *keycloakv1alpha1.KeycloakClient) field.ErrorList {
+	var errs field.ErrorList
+	spec := field.NewPath("spec")
+	if old.Spec.Realm != m.Spec.Realm {
+		errs = append(errs, field.Forbidden(spec.Child("realm"), "field is immutable"))
+	}
+	if !equality.Semantic.DeepEqual(old.Spec.InstanceRef, m.Spec.InstanceRef) {
+		errs = append(errs, field.Forbidden(spec.Child("instanceRef"), "field is immutable"))
+	}
+	return errs
+}
+
+func asClient(obj runtime.Object) (*keycloakv1alpha1.KeycloakClient, error) {
+	m, ok := obj.(*keycloakv1alpha1.KeycloakClient)
+	if !ok {
+		return nil, fmt.Errorf("expected KeycloakClient, got %T", obj)
+	}
+	return m, nil
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+func TestValidateDomain(t *testing.T) {
+	cases := []struct {
+		domain string
+		valid  bool
+	}{
+		{"app.example.com", true},
+		{"App.Example.com", true},
+		{"localhost", true},
+		{"app.example.com:8443", true},
+		{"127.0.0.1:8080", true},
+		{"", false},
+		{"https://app.example.com", false},
+		{"app.example.com/path", false},
+		{"app.example.com?query", false},
+		{"app.example.com#fragment", false},
+		{"app_example.com", false},
+		{"-app.example.com", false},
+	}
+	for _, c := range cases {
+		t.Run(c.domain, func(t *testing.T) {
+			errs := validateDomain(field.NewPath("spec", "domain"), c.domain)
+			assert.Equal(t, c.valid, len(errs) == 0, errs)
+		})
+	}
+}
+
+func TestValidateClientSpec(t *testing.T) {
+	valid := func() *keycloakv1alpha1.KeycloakClient {
+		return &keycloakv1alpha1.KeycloakClient{
+			Spec: keycloakv1alpha1.KeycloakClientSpec{
+				Realm:  "test",
+				Domain: "app.example.com",
+			},
+		}
+	}
+	cases := []struct {
+		name   string
+		modify func(m *keycloakv1alpha1.KeycloakClient)
+		fields []string // paths of expected errors
+	}{
+		{
+			name:   "valid",
+			modify: func(m *keycloakv1alpha1.KeycloakClient) {},
+		},
+		{
+			name:   "no realm",
+			modify: func(m *keycloakv1alpha1.KeycloakClient) { m.Spec.Realm = "" },
+			fields: []string{"spec.realm"},
+		},
+		{
+			name:   "domain with scheme",
+			modify: func(m *keycloakv1alpha1.KeycloakClient) { m.Spec.Domain = "https://app.example.com" },
+			fields: []string{"spec.domain"},
+		},
+		{
+			name:   "secret name",
+			modify: func(m *keycloakv1alpha1.KeycloakClient) { m.Spec.SecretName = "Bad_Name" },
+			fields: []string{"spec.secretName"},
+		},
+		{
+			name: "labels",
+			modify: func(m *keycloakv1alpha1.KeycloakClient) {
+				m.Spec.Labels = map[string]string{"bad key": "value", "key": "bad value"}
+			},
+			fields: []string{"spec.labels", "spec.labels[key]"},
+		},
+		{
+			name:   "annotations",
+			modify: func(m *keycloakv1alpha1.KeycloakClient) { m.Spec.Annotations = map[string]string{"bad key": ""} },
+			fields: []string{"spec.annotations"},
+		},
+		{
+			name: "generated values",
+			modify: func(m *keycloakv1alpha1.KeycloakClient) {
+				m.Spec.GeneratedValues = []keycloakv1alpha1.GeneratedValue{{Key: "cookie"}, {Key: "bad/key"}}
+			},
+			fields: []string{"spec.generatedValues[1].key"},
+		},
+		{
+			name:   "secret template",
+			modify: func(m *keycloakv1alpha1.KeycloakClient) { m.Spec.SecretTemplate = map[string]string{"bad/key": ""} },
+			fields: []string{"spec.secretTemplate"},
+		},
+		{
+			name: "rotation schedule",
+			modify: func(m *keycloakv1alpha1.KeycloakClient) {
+				m.Spec.Rotation = &keycloakv1alpha1.SecretRotation{Schedule: "every day"}
+			},
+			fields: []string{"spec.rotation.schedule"},
+		},
+		{
+			name: "restart targets",
+			modify: func(m *keycloakv1alpha1.KeycloakClient) {
+				m.Spec.RestartTargets = []keycloakv1alpha1.RestartTarget{
+					{Name: "app"},
+					{Selector: &metav1.LabelSelector{}},
+					{},
+					{Name: "app", Selector: &metav1.LabelSelector{}},
+				}
+			},
+			fields: []string{"spec.restartTargets[2]", "spec.restartTargets[3]"},
+		},
+		{
+			name:   "public client",
+			modify: func(m *keycloakv1alpha1.KeycloakClient) { m.Spec.PublicClient = true },
+		},
+		{
+			name: "public client with service account",
+			modify: func(m *keycloakv1alpha1.KeycloakClient) {
+				m.Spec.PublicClient = true
+				m.Spec.ServiceAccount = true
+			},
+			fields: []string{"spec.publicClient"},
+		},
+		{
+			name: "public client with private-key JWT",
+			modify: func(m *keycloakv1alpha1.KeycloakClient) {
+				m.Spec.PublicClient = true
+				m.Spec.ClientAuthenticator = "client-jwt"
+			},
+			fields: []string{"spec.publicClient"},
+		},
+		{
+			name: "public saml client",
+			modify: func(m *keycloakv1alpha1.KeycloakClient) {
+				m.Spec.PublicClient = true
+				m.Spec.Protocol = "saml"
+			},
+			fields: []string{"spec.publicClient"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := valid()
+			c.modify(m)
+			var fields []string
+			for _, err := range validateClientSpec(m) {
+				fields = append(fields, err.Field)
+			}
+			assert.ElementsMatch(t, c.fields, fields)
+		})
+	}
+}
+
+func TestValidateImmutable(t *testing.T) {
+	old := keycloakv1alpha1.KeycloakClientSpec{
+		Realm:       "test",
+		Domain:      "app.example.com",
+		InstanceRef: &keycloakv1alpha1.InstanceReference{Name: "keycloak"},
+	}
+	cases := []struct {
+		name   string
+		spec   keycloakv1alpha1.KeycloakClientSpec
+		fields []string
+	}{
+		{
+			name: "domain changed",
+			spec: keycloakv1alpha1.KeycloakClientSpec{Realm: "test", Domain: "new.example.com", InstanceRef: &keycloakv1alpha1.InstanceReference{Name: "keycloak"}},
+		},
+		{
+			name:   "realm changed",
+			spec:   keycloakv1alpha1.KeycloakClientSpec{Realm: "other", Domain: "app.example.com", InstanceRef: &keycloakv1alpha1.InstanceReference{Name: "keycloak"}},
+			fields: []string{"spec.realm"},
+		},
+		{
+			name:   "instance removed",
+			spec:   keycloakv1alpha1.KeycloakClientSpec{Realm: "test", Domain: "app.example.com"},
+			fields: []string{"spec.instanceRef"},
+		},
+		{
+			name:   "instance kind changed",
+			spec:   keycloakv1alpha1.KeycloakClientSpec{Realm: "other", InstanceRef: &keycloakv1alpha1.InstanceReference{Kind: clusterInstanceKind, Name: "keycloak"}},
+			fields: []string{"spec.realm", "spec.instanceRef"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var fields []string
+			errs := validateImmutable(&keycloakv1alpha1.KeycloakClient{Spec: old}, &keycloakv1alpha1.KeycloakClient{Spec: c.spec})
+			for _, err := range errs {
+				fields = append(fields, err.Field)
+			}
+			assert.Equal(t, c.fields, fields)
+		})
+	}
+}
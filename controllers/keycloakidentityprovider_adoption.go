@@ -0,0 +1,69 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/reddec/keycloak-ext-operator/internal"
+	v12 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+// owner returns ownership markers of resource.
+func (r *KeycloakIdentityProviderReconciler) owner(m *keycloakv1alpha1.KeycloakIdentityProvider) internal.Owner {
+	return resourceOwner(r.ClusterID, m)
+}
+
+// owns returns true if identity provider is marked as owned by resource in this cluster. Providers without markers are
+// never owned: alias alone does not say who created provider.
+func (r *KeycloakIdentityProviderReconciler) owns(idp *internal.IdentityProvider, m *keycloakv1alpha1.KeycloakIdentityProvider) bool {
+	owner, ok := idp.Owner()
+	return ok && ownedBy(owner, r.owner(m))
+}
+
+// checkAdoption checks if existent identity provider (found by alias) can be managed by resource according to
+// spec.adoption. Identity providers are never adopted by default.
+func (r *KeycloakIdentityProviderReconciler) checkAdoption(idp *internal.IdentityProvider, m *keycloakv1alpha1.KeycloakIdentityProvider) error {
+	if r.owns(idp, m) {
+		return nil
+	}
+	adoption := m.Spec.Adoption
+	if adoption == "" {
+		adoption = adoptionNever
+	}
+	owner, managed := idp.Owner()
+	if err := refuseAdoption("identity provider", idp.Alias, adoption, owner, managed); err != nil {
+		return err
+	}
+	log.Log.Info("Existent identity provider will be adopted", "alias", idp.Alias)
+	return nil
+}
+
+// reportConflict sets IdentityProviderConflict condition (if conflict is not nil) or clears it.
+func (r *KeycloakIdentityProviderReconciler) reportConflict(ctx context.Context, m *keycloakv1alpha1.KeycloakIdentityProvider, conflict error) error {
+	changed, err := setStatusCondition(ctx, r.Status(), m, &m.Status.Conditions, conflictCondition(conditionIdPConflict, conflict))
+	if err != nil {
+		return err
+	}
+	if changed && conflict != nil {
+		r.Recorder.Event(m, v12.EventTypeWarning, "IdentityProviderConflict", conflict.Error())
+	}
+	return nil
+}
@@ -0,0 +1,345 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	errors2 "errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/reddec/keycloak-ext-operator/internal"
+	v12 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+const idpSecretIndex = ".spec.clientSecretRef.name"
+
+// KeycloakIdentityProviderReconciler reconciles a KeycloakIdentityProvider object
+type KeycloakIdentityProviderReconciler struct {
+	client.Client
+	Scheme    *runtime.Scheme
+	Instances *Instances
+	Policies  *AccessPolicies
+	Recorder  record.EventRecorder
+	// ClusterID is stored in ownership markers of identity providers.
+	ClusterID string
+}
+
+//+kubebuilder:rbac:groups=keycloak.k8s.reddec.net,resources=keycloakidentityproviders,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=keycloak.k8s.reddec.net,resources=keycloakidentityproviders/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=keycloak.k8s.reddec.net,resources=keycloakidentityproviders/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile creates (or updates) identity provider and its mappers in Keycloak realm. Existent providers not created
+// for the resource are not touched unless adoption is allowed.
+func (r *KeycloakIdentityProviderReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	idpSpec := &keycloakv1alpha1.KeycloakIdentityProvider{}
+	err := r.Get(ctx, req.NamespacedName, idpSpec)
+	if errors.IsNotFound(err) {
+		return ctrl.Result{}, nil
+	}
+	if err != nil {
+		logger.Error(err, "get identity provider spec")
+		return ctrl.Result{}, err
+	}
+
+	if idpSpec.GetDeletionTimestamp() != nil {
+		if err := r.removeIdentityProvider(ctx, idpSpec); err != nil {
+			logger.Error(err, "Failed to remove identity provider")
+			return ctrl.Result{}, err
+		}
+		controllerutil.RemoveFinalizer(idpSpec, keycloakFinalizer)
+		if err := r.Update(ctx, idpSpec); err != nil {
+			return ctrl.Result{}, err
+		}
+		log.Log.Info("Identity provider removed")
+		return ctrl.Result{}, nil
+	}
+
+	// enforce access policies before any changes
+	allowed, err := r.checkAccess(ctx, idpSpec)
+	if err != nil {
+		logger.Error(err, "Check access policies")
+		return ctrl.Result{}, err
+	}
+	if !allowed {
+		return ctrl.Result{RequeueAfter: time.Minute}, nil
+	}
+
+	// add finalizer (to clean up Keycloak identity provider)
+	if !controllerutil.ContainsFinalizer(idpSpec, keycloakFinalizer) {
+		controllerutil.AddFinalizer(idpSpec, keycloakFinalizer)
+		if err := r.Update(ctx, idpSpec); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	clientSecret, secretVersion, err := r.getClientSecret(ctx, idpSpec)
+	if err != nil {
+		logger.Error(err, "Get client secret")
+		return ctrl.Result{}, err
+	}
+
+	keycloak, err := r.Instances.Resolve(ctx, idpSpec.Namespace, idpSpec.Spec.InstanceRef)
+	if err != nil {
+		logger.Error(err, "Resolve Keycloak instance")
+		return ctrl.Result{}, err
+	}
+	kClient := keycloak.Authorize(ctx)
+
+	err = r.syncIdentityProvider(ctx, kClient, idpSpec, clientSecret, secretVersion != idpSpec.Status.SecretVersion)
+	if errors2.Is(err, ErrAdoptionRefused) {
+		return ctrl.Result{RequeueAfter: time.Minute}, r.reportConflict(ctx, idpSpec, err)
+	}
+	if err != nil {
+		logger.Error(err, "Sync identity provider")
+		return ctrl.Result{}, err
+	}
+	if err := r.reportConflict(ctx, idpSpec, nil); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if err := r.syncMappers(ctx, kClient, idpSpec); err != nil {
+		logger.Error(err, "Sync identity provider mappers")
+		return ctrl.Result{}, err
+	}
+
+	if idpSpec.Status.SecretVersion != secretVersion {
+		idpSpec.Status.SecretVersion = secretVersion
+		if err := r.Status().Update(ctx, idpSpec); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{RequeueAfter: time.Minute}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *KeycloakIdentityProviderReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	err := mgr.GetFieldIndexer().IndexField(context.Background(), &keycloakv1alpha1.KeycloakIdentityProvider{}, idpSecretIndex, func(object client.Object) []string {
+		idp := object.(*keycloakv1alpha1.KeycloakIdentityProvider)
+		if idp.Spec.ClientSecretRef == nil {
+			return nil
+		}
+		return []string{idp.Spec.ClientSecretRef.Name}
+	})
+	if err != nil {
+		return fmt.Errorf("index client secret: %w", err)
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&keycloakv1alpha1.KeycloakIdentityProvider{}).
+		Watches(&v12.Secret{}, handler.EnqueueRequestsFromMapFunc(r.findBySecret)).
+		Complete(r)
+}
+
+// findBySecret returns all identity providers in the same namespace which are referencing secret.
+func (r *KeycloakIdentityProviderReconciler) findBySecret(ctx context.Context, secret client.Object) []reconcile.Request {
+	var list keycloakv1alpha1.KeycloakIdentityProviderList
+	err := r.List(ctx, &list, client.InNamespace(secret.GetNamespace()), client.MatchingFields{idpSecretIndex: secret.GetName()})
+	if err != nil {
+		log.FromContext(ctx).Error(err, "list identity providers by secret")
+		return nil
+	}
+	var requests = make([]reconcile.Request, 0, len(list.Items))
+	for _, item := range list.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: item.Name, Namespace: item.Namespace}})
+	}
+	return requests
+}
+
+// getClientSecret returns client secret and resource version of secret. Empty values returned if reference is not set.
+func (r *KeycloakIdentityProviderReconciler) getClientSecret(ctx context.Context, manifest *keycloakv1alpha1.KeycloakIdentityProvider) (string, string, error) {
+	ref := manifest.Spec.ClientSecretRef
+	if ref == nil {
+		return "", "", nil
+	}
+	var secret v12.Secret
+	if err := r.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: manifest.Namespace}, &secret); err != nil {
+		return "", "", fmt.Errorf("get secret %s: %w", ref.Name, err)
+	}
+	value, ok := secret.Data[ref.Key]
+	if !ok {
+		return "", "", fmt.Errorf("key %q not found in secret %s", ref.Key, ref.Name)
+	}
+	return string(value), secret.ResourceVersion, nil
+}
+
+// identityProviderDraft from spec. Ownership markers are added to config.
+func identityProviderDraft(manifest *keycloakv1alpha1.KeycloakIdentityProvider, owner internal.Owner) internal.IdentityProvider {
+	spec := manifest.Spec
+	var config = owner.Attributes()
+	for k, v := range spec.Config {
+		config[k] = v
+	}
+	return internal.IdentityProvider{
+		Alias:                     manifest.Alias(),
+		DisplayName:               spec.DisplayName,
+		ProviderID:                spec.ProviderID,
+		Enabled:                   spec.Enabled == nil || *spec.Enabled,
+		TrustEmail:                spec.TrustEmail,
+		FirstBrokerLoginFlowAlias: spec.FirstBrokerLoginFlowAlias,
+		Config:                    config,
+	}
+}
+
+// syncIdentityProvider creates or updates identity provider. Client secret is masked by Keycloak, so it is pushed
+// only for new provider or if secret changed. Returns ErrAdoptionRefused if provider exists and can not be managed by
+// resource.
+func (r *KeycloakIdentityProviderReconciler) syncIdentityProvider(ctx context.Context, kClient *internal.AuthorizedKeycloak, manifest *keycloakv1alpha1.KeycloakIdentityProvider, clientSecret string, secretChanged bool) error {
+	realm := manifest.Spec.Realm
+	draft := identityProviderDraft(manifest, r.owner(manifest))
+	existent, err := kClient.IdentityProvider(ctx, realm, draft.Alias)
+	if errors2.Is(err, internal.ErrNotFound) {
+		if clientSecret != "" {
+			draft.Config["clientSecret"] = clientSecret
+		}
+		if err := kClient.CreateIdentityProvider(ctx, realm, draft); err != nil {
+			return fmt.Errorf("create identity provider: %w", err)
+		}
+		log.Log.Info("Identity provider created", "alias", draft.Alias)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("get identity provider: %w", err)
+	}
+	if err := r.checkAdoption(existent, manifest); err != nil {
+		return err
+	}
+
+	same := existent.DisplayName == draft.DisplayName &&
+		existent.ProviderID == draft.ProviderID &&
+		existent.Enabled == draft.Enabled &&
+		existent.TrustEmail == draft.TrustEmail &&
+		(draft.FirstBrokerLoginFlowAlias == "" || existent.FirstBrokerLoginFlowAlias == draft.FirstBrokerLoginFlowAlias) &&
+		includes(existent.Config, draft.Config)
+	if same && !secretChanged {
+		return nil
+	}
+	if draft.FirstBrokerLoginFlowAlias == "" {
+		draft.FirstBrokerLoginFlowAlias = existent.FirstBrokerLoginFlowAlias
+	}
+	if clientSecret != "" {
+		draft.Config["clientSecret"] = clientSecret
+	} else if masked, ok := existent.Config["clientSecret"]; ok {
+		// keep secret unchanged
+		draft.Config["clientSecret"] = masked
+	}
+	if err := kClient.UpdateIdentityProvider(ctx, realm, draft); err != nil {
+		return fmt.Errorf("update identity provider: %w", err)
+	}
+	log.Log.Info("Keycloak identity provider synced with manifest", "alias", draft.Alias)
+	return nil
+}
+
+// syncMappers creates and updates mappers to match manifest. Mappers are matched by name. Only mappers created by
+// operator (listed in status) are removed once they are not in manifest.
+func (r *KeycloakIdentityProviderReconciler) syncMappers(ctx context.Context, kClient *internal.AuthorizedKeycloak, manifest *keycloakv1alpha1.KeycloakIdentityProvider) error {
+	realm := manifest.Spec.Realm
+	alias := manifest.Alias()
+	existent, err := kClient.IdentityProviderMappers(ctx, realm, alias)
+	if err != nil {
+		return fmt.Errorf("list mappers: %w", err)
+	}
+	var byName = make(map[string]internal.IdentityProviderMapper, len(existent))
+	for _, m := range existent {
+		byName[m.Name] = m
+	}
+
+	var managed = make([]string, 0, len(manifest.Spec.Mappers))
+	for _, m := range manifest.Spec.Mappers {
+		managed = append(managed, m.Name)
+		draft := internal.IdentityProviderMapper{
+			Name:                   m.Name,
+			IdentityProviderAlias:  alias,
+			IdentityProviderMapper: m.Type,
+			Config:                 m.Config,
+		}
+		old, ok := byName[m.Name]
+		delete(byName, m.Name)
+		if !ok {
+			if err := kClient.CreateIdentityProviderMapper(ctx, realm, draft); err != nil {
+				return fmt.Errorf("create mapper %q: %w", m.Name, err)
+			}
+			continue
+		}
+		if old.IdentityProviderMapper == draft.IdentityProviderMapper && includes(old.Config, draft.Config) {
+			continue
+		}
+		draft.ID = old.ID
+		if err := kClient.UpdateIdentityProviderMapper(ctx, realm, draft); err != nil {
+			return fmt.Errorf("update mapper %q: %w", m.Name, err)
+		}
+	}
+
+	for _, name := range manifest.Status.Mappers {
+		m, ok := byName[name]
+		if !ok {
+			continue
+		}
+		if err := kClient.DeleteIdentityProviderMapper(ctx, realm, alias, m.ID); err != nil {
+			return fmt.Errorf("delete mapper %q: %w", m.Name, err)
+		}
+	}
+
+	sort.Strings(managed)
+	if sameSet(managed, manifest.Status.Mappers) {
+		return nil
+	}
+	manifest.Status.Mappers = managed
+	if err := r.Status().Update(ctx, manifest); err != nil {
+		return fmt.Errorf("update status: %w", err)
+	}
+	return nil
+}
+
+func (r *KeycloakIdentityProviderReconciler) removeIdentityProvider(ctx context.Context, spec *keycloakv1alpha1.KeycloakIdentityProvider) error {
+	keycloak, err := r.Instances.Resolve(ctx, spec.Namespace, spec.Spec.InstanceRef)
+	if err != nil {
+		return err
+	}
+	kClient := keycloak.Authorize(ctx)
+	idp, err := kClient.IdentityProvider(ctx, spec.Spec.Realm, spec.Alias())
+	if errors2.Is(err, internal.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("get identity provider: %w", err)
+	}
+	if !r.owns(idp, spec) {
+		log.Log.Info("Identity provider is not owned by resource and will not be removed", "alias", idp.Alias)
+		return nil
+	}
+	err = kClient.DeleteIdentityProvider(ctx, spec.Spec.Realm, idp.Alias)
+	if errors2.Is(err, internal.ErrNotFound) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/reddec/keycloak-ext-operator/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+func TestKeycloakIdentityProviderReconciler_syncMappers(t *testing.T) {
+	existent := []internal.IdentityProviderMapper{
+		{ID: "1", Name: "email", IdentityProviderMapper: "oidc-user-attribute-idp-mapper", Config: map[string]string{"claim": "email", "user.attribute": "email"}},
+		{ID: "2", Name: "admins", IdentityProviderMapper: "hardcoded-role-idp-mapper", Config: map[string]string{"role": "admin"}},
+		{ID: "3", Name: "manual", IdentityProviderMapper: "hardcoded-attribute-idp-mapper"},
+	}
+	email := keycloakv1alpha1.IdentityProviderMapper{Name: "email", Type: "oidc-user-attribute-idp-mapper", Config: map[string]string{"claim": "email"}}
+	cases := []struct {
+		name     string
+		mappers  []keycloakv1alpha1.IdentityProviderMapper
+		managed  []string
+		requests []string
+		status   []string
+	}{
+		{
+			name:    "in sync",
+			mappers: []keycloakv1alpha1.IdentityProviderMapper{email},
+			managed: []string{"email"},
+			status:  []string{"email"},
+		},
+		{
+			name:     "new and changed",
+			mappers:  []keycloakv1alpha1.IdentityProviderMapper{email, {Name: "admins", Type: "hardcoded-role-idp-mapper", Config: map[string]string{"role": "root"}}, {Name: "team", Type: "hardcoded-group-idp-mapper"}},
+			requests: []string{"PUT 2", "POST team"},
+			status:   []string{"admins", "email", "team"},
+		},
+		{
+			name:     "removed from manifest",
+			mappers:  []keycloakv1alpha1.IdentityProviderMapper{email},
+			managed:  []string{"admins", "email"},
+			requests: []string{"DELETE 2"},
+			status:   []string{"email"},
+		},
+		{
+			name:     "managed mapper removed outside",
+			managed:  []string{"gone", "email"},
+			requests: []string{"DELETE 1"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var requests []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				const prefix = "/admin/realms/test/identity-provider/instances/github/mappers"
+				switch {
+				case r.URL.Path == "/realms/master/protocol/openid-connect/token":
+					_ = json.NewEncoder(w).Encode(map[string]any{"token_type": "Bearer", "access_token": "token", "expires_in": 60})
+				case r.URL.Path == prefix && r.Method == http.MethodGet:
+					_ = json.NewEncoder(w).Encode(existent)
+				case r.URL.Path == prefix && r.Method == http.MethodPost:
+					var mapper internal.IdentityProviderMapper
+					_ = json.NewDecoder(r.Body).Decode(&mapper)
+					requests = append(requests, "POST "+mapper.Name)
+					w.WriteHeader(http.StatusCreated)
+				case strings.HasPrefix(r.URL.Path, prefix+"/"):
+					requests = append(requests, r.Method+" "+strings.TrimPrefix(r.URL.Path, prefix+"/"))
+					w.WriteHeader(http.StatusNoContent)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer srv.Close()
+
+			m := &keycloakv1alpha1.KeycloakIdentityProvider{
+				ObjectMeta: metav1.ObjectMeta{Name: "github", Namespace: "default"},
+				Spec:       keycloakv1alpha1.KeycloakIdentityProviderSpec{Realm: "test", Mappers: c.mappers},
+				Status:     keycloakv1alpha1.KeycloakIdentityProviderStatus{Mappers: c.managed},
+			}
+			k8s := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(m).WithStatusSubresource(m).Build()
+			r := &KeycloakIdentityProviderReconciler{Client: k8s}
+			kc := &internal.Keycloak{URL: srv.URL}
+			ctx := context.Background()
+
+			require.NoError(t, r.syncMappers(ctx, kc.Authorize(ctx), m))
+			sort.Strings(requests)
+			sort.Strings(c.requests)
+			assert.Equal(t, c.requests, requests)
+			assert.Equal(t, c.status, m.Status.Mappers)
+		})
+	}
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+const idpAliasIndex = ".spec.alias"
+
+//+kubebuilder:webhook:path=/validate-keycloak-k8s-reddec-net-v1alpha1-keycloakidentityprovider,mutating=false,failurePolicy=fail,sideEffects=None,groups=keycloak.k8s.reddec.net,resources=keycloakidentityproviders,verbs=create;update,versions=v1alpha1,name=vkeycloakidentityprovider.kb.io,admissionReviewVersions=v1
+
+// KeycloakIdentityProviderValidator validates KeycloakIdentityProvider on admission: uniqueness of alias in realm
+// across the cluster and access policies.
+type KeycloakIdentityProviderValidator struct {
+	Client   client.Reader
+	Policies *AccessPolicies
+}
+
+var _ admission.CustomValidator = &KeycloakIdentityProviderValidator{}
+
+func (v *KeycloakIdentityProviderValidator) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	err := mgr.GetFieldIndexer().IndexField(context.Background(), &keycloakv1alpha1.KeycloakIdentityProvider{}, idpAliasIndex, func(object client.Object) []string {
+		return []string{aliasKey(object.(*keycloakv1alpha1.KeycloakIdentityProvider))}
+	})
+	if err != nil {
+		return fmt.Errorf("index alias: %w", err)
+	}
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&keycloakv1alpha1.KeycloakIdentityProvider{}).
+		WithValidator(v).
+		Complete()
+}
+
+func (v *KeycloakIdentityProviderValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	m, err := asIdentityProvider(obj)
+	if err != nil {
+		return nil, err
+	}
+	return nil, v.validate(ctx, m)
+}
+
+func (v *KeycloakIdentityProviderValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	old, err := asIdentityProvider(oldObj)
+	if err != nil {
+		return nil, err
+	}
+	m, err := asIdentityProvider(newObj)
+	if err != nil {
+		return nil, err
+	}
+	// metadata changes (finalizers, annotations) should not be blocked, otherwise invalid providers can not be removed
+	if m.DeletionTimestamp != nil || equality.Semantic.DeepEqual(old.Spec, m.Spec) {
+		return nil, nil
+	}
+	return nil, v.validate(ctx, m)
+}
+
+func (v *KeycloakIdentityProviderValidator) ValidateDelete(context.Context, runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (v *KeycloakIdentityProviderValidator) validate(ctx context.Context, m *keycloakv1alpha1.KeycloakIdentityProvider) error {
+	errs, err := v.duplicateAlias(ctx, m)
+	if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return apierrors.NewInvalid(keycloakv1alpha1.GroupVersion.WithKind("KeycloakIdentityProvider").GroupKind(), m.Name, errs)
+	}
+	return v.Policies.Check(ctx, m)
+}
+
+// duplicateAlias checks that no other resource in cluster manages identity provider with the same alias in the same
+// realm.
+func (v *KeycloakIdentityProviderValidator) duplicateAlias(ctx context.Context, m *keycloakv1alpha1.KeycloakIdentityProvider) (field.ErrorList, error) {
+	var list keycloakv1alpha1.KeycloakIdentityProviderList
+	if err := v.Client.List(ctx, &list, client.MatchingFields{idpAliasIndex: aliasKey(m)}); err != nil {
+		return nil, fmt.Errorf("list identity providers: %w", err)
+	}
+	for _, item := range list.Items {
+		if item.Namespace == m.Namespace && item.Name == m.Name {
+			continue
+		}
+		return field.ErrorList{
+			field.Duplicate(field.NewPath("spec", "alias"), fmt.Sprintf("alias %q in realm %q is already used by %s/%s", m.Alias(), m.Spec.Realm, item.Namespace, item.Name)),
+		}, nil
+	}
+	return nil, nil
+}
+
+// aliasKey identifies identity provider across instances: instance, realm and alias.
+func aliasKey(m *keycloakv1alpha1.KeycloakIdentityProvider) string {
+	return instanceKey(m.Namespace, m.Spec.InstanceRef) + "|" + m.Spec.Realm + "|" + m.Alias()
+}
+
+func asIdentityProvider(obj runtime.Object) (*keycloakv1alpha1.KeycloakIdentityProvider, error) {
+	m, ok := obj.(*keycloakv1alpha1.KeycloakIdentityProvider)
+	if !ok {
+		return nil, fmt.Errorf("expected KeycloakIdentityProvider, got %T", obj)
+	}
+	return m, nil
+}
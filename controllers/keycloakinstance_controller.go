@@ -0,0 +1,65 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+// KeycloakInstanceReconciler removes sessions of deleted KeycloakInstance and ClusterKeycloakInstance resources from
+// pool. Requests without namespace are for cluster instances. Sessions of changed instances are replaced on resolve.
+type KeycloakInstanceReconciler struct {
+	client.Client
+	Instances *Instances
+}
+
+func (r *KeycloakInstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var instance client.Object = &keycloakv1alpha1.KeycloakInstance{}
+	ref := &keycloakv1alpha1.InstanceReference{Name: req.Name}
+	if req.Namespace == "" {
+		instance = &keycloakv1alpha1.ClusterKeycloakInstance{}
+		ref.Kind = clusterInstanceKind
+	}
+	err := r.Get(ctx, req.NamespacedName, instance)
+	if err == nil || !errors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+	key := instanceKey(req.Namespace, ref)
+	r.Instances.Pool.Forget(key)
+	log.FromContext(ctx).Info("Session of removed instance closed", "instance", key)
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *KeycloakInstanceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&keycloakv1alpha1.KeycloakInstance{}).
+		Watches(&keycloakv1alpha1.ClusterKeycloakInstance{}, handler.EnqueueRequestsFromMapFunc(func(_ context.Context, object client.Object) []reconcile.Request {
+			return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: object.GetName()}}}
+		})).
+		Complete(r)
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v12 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+func TestKeycloakInstanceReconciler_Reconcile(t *testing.T) {
+	credentials := func(namespace string) *v12.Secret {
+		return &v12.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "sso-admin", Namespace: namespace},
+			Data:       map[string][]byte{"username": []byte("admin"), "password": []byte(namespace)},
+		}
+	}
+	namespaced := &keycloakv1alpha1.KeycloakInstance{
+		ObjectMeta: metav1.ObjectMeta{Name: "internal", Namespace: "team-a"},
+		Spec: keycloakv1alpha1.KeycloakInstanceSpec{
+			URL:               "https://sso.team-a.example.com",
+			CredentialsSecret: keycloakv1alpha1.InstanceSecretReference{Name: "sso-admin"},
+		},
+	}
+	cluster := &keycloakv1alpha1.ClusterKeycloakInstance{
+		ObjectMeta: metav1.ObjectMeta{Name: "customers"},
+		Spec: keycloakv1alpha1.KeycloakInstanceSpec{
+			URL:               "https://sso.example.com",
+			CredentialsSecret: keycloakv1alpha1.InstanceSecretReference{Name: "sso-admin", Namespace: "keycloak"},
+		},
+	}
+
+	cases := []struct {
+		name    string
+		deleted client.Object
+		request types.NamespacedName
+		size    int
+	}{
+		{name: "instance exists", request: types.NamespacedName{Name: "internal", Namespace: "team-a"}, size: 2},
+		{name: "namespaced instance deleted", deleted: namespaced, request: types.NamespacedName{Name: "internal", Namespace: "team-a"}, size: 1},
+		{name: "cluster instance deleted", deleted: cluster, request: types.NamespacedName{Name: "customers"}, size: 1},
+		{name: "unknown instance", request: types.NamespacedName{Name: "internal", Namespace: "team-b"}, size: 2},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			k8s := fake.NewClientBuilder().WithScheme(testScheme(t)).
+				WithObjects(credentials("team-a"), credentials("keycloak"), namespaced.DeepCopy(), cluster.DeepCopy()).
+				Build()
+			instances := &Instances{Client: k8s}
+			_, err := instances.Resolve(ctx, "team-a", &keycloakv1alpha1.InstanceReference{Name: "internal"})
+			require.NoError(t, err)
+			_, err = instances.Resolve(ctx, "team-a", &keycloakv1alpha1.InstanceReference{Kind: clusterInstanceKind, Name: "customers"})
+			require.NoError(t, err)
+			if c.deleted != nil {
+				require.NoError(t, k8s.Delete(ctx, c.deleted))
+			}
+
+			r := &KeycloakInstanceReconciler{Client: k8s, Instances: instances}
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: c.request})
+			require.NoError(t, err)
+			assert.Equal(t, c.size, instances.Pool.Len())
+		})
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/reddec/keycloak-ext-operator/internal"
+	v12 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+// owner returns ownership markers of resource.
+func (r *KeycloakUserReconciler) owner(m *keycloakv1alpha1.KeycloakUser) internal.Owner {
+	return resourceOwner(r.ClusterID, m)
+}
+
+// owns returns true if user is marked as owned by resource in this cluster. Users without markers (created before
+// markers were introduced) are owned only if their ID is already recorded in status of resource.
+func (r *KeycloakUserReconciler) owns(user *internal.User, m *keycloakv1alpha1.KeycloakUser) bool {
+	if owner, ok := user.Owner(); ok {
+		return ownedBy(owner, r.owner(m))
+	}
+	return m.Status.ID != "" && user.ID == m.Status.ID
+}
+
+// checkAdoption checks if existent user (found by username) can be managed by resource according to spec.adoption.
+// Users are never adopted by default.
+func (r *KeycloakUserReconciler) checkAdoption(user *internal.User, m *keycloakv1alpha1.KeycloakUser) error {
+	if r.owns(user, m) {
+		return nil
+	}
+	adoption := m.Spec.Adoption
+	if adoption == "" {
+		adoption = adoptionNever
+	}
+	owner, managed := user.Owner()
+	if err := refuseAdoption("user", user.Username, adoption, owner, managed); err != nil {
+		return err
+	}
+	log.Log.Info("Existent user will be adopted", "username", user.Username)
+	return nil
+}
+
+// reportUserConflict sets UserConflict condition (if conflict is not nil) or clears it.
+func (r *KeycloakUserReconciler) reportUserConflict(ctx context.Context, m *keycloakv1alpha1.KeycloakUser, conflict error) error {
+	changed, err := setStatusCondition(ctx, r.Status(), m, &m.Status.Conditions, conflictCondition(conditionUserConflict, conflict))
+	if err != nil {
+		return err
+	}
+	if changed && conflict != nil {
+		r.Recorder.Event(m, v12.EventTypeWarning, "UserConflict", conflict.Error())
+	}
+	return nil
+}
+
+// reportSecretConflict sets SecretConflict condition (if conflict is not nil) or clears it.
+func (r *KeycloakUserReconciler) reportSecretConflict(ctx context.Context, m *keycloakv1alpha1.KeycloakUser, conflict error) error {
+	condition := metav1.Condition{
+		Type:   conditionSecretConflict,
+		Status: metav1.ConditionFalse,
+		Reason: "Owned",
+	}
+	if conflict != nil {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "NotOwned"
+		condition.Message = conflict.Error()
+	}
+	changed, err := setStatusCondition(ctx, r.Status(), m, &m.Status.Conditions, condition)
+	if err != nil {
+		return err
+	}
+	if changed && conflict != nil {
+		r.Recorder.Event(m, v12.EventTypeWarning, "SecretConflict", conflict.Error())
+	}
+	return nil
+}
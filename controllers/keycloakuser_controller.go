@@ -0,0 +1,498 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	errors2 "errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/reddec/keycloak-ext-operator/internal"
+	v12 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+// KeycloakUserReconciler reconciles a KeycloakUser object
+type KeycloakUserReconciler struct {
+	client.Client
+	Scheme    *runtime.Scheme
+	Instances *Instances
+	Policies  *AccessPolicies
+	Recorder  record.EventRecorder
+	// ClusterID is stored in ownership markers of users.
+	ClusterID string
+}
+
+//+kubebuilder:rbac:groups=keycloak.k8s.reddec.net,resources=keycloakusers,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=keycloak.k8s.reddec.net,resources=keycloakusers/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=keycloak.k8s.reddec.net,resources=keycloakusers/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile creates (or updates) user in Keycloak and stores generated password in secret.
+// Password is generated only once: if secret already exists, password from the secret will be used.
+// Existent users not created for the resource are not touched unless adoption is allowed.
+func (r *KeycloakUserReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	userSpec := &keycloakv1alpha1.KeycloakUser{}
+	err := r.Get(ctx, req.NamespacedName, userSpec)
+	if errors.IsNotFound(err) {
+		return ctrl.Result{}, nil
+	}
+	if err != nil {
+		logger.Error(err, "get user spec")
+		return ctrl.Result{}, err
+	}
+
+	if userSpec.GetDeletionTimestamp() != nil {
+		if err := r.removeUser(ctx, userSpec); err != nil {
+			logger.Error(err, "Failed to remove user")
+			return ctrl.Result{}, err
+		}
+		controllerutil.RemoveFinalizer(userSpec, keycloakFinalizer)
+		if err := r.Update(ctx, userSpec); err != nil {
+			return ctrl.Result{}, err
+		}
+		log.Log.Info("User removed")
+		return ctrl.Result{}, nil
+	}
+
+	// enforce access policies before any changes
+	allowed, err := r.checkAccess(ctx, userSpec)
+	if err != nil {
+		logger.Error(err, "Check access policies")
+		return ctrl.Result{}, err
+	}
+	if !allowed {
+		return ctrl.Result{RequeueAfter: time.Minute}, nil
+	}
+
+	// add finalizer (to clean up Keycloak user)
+	if !controllerutil.ContainsFinalizer(userSpec, keycloakFinalizer) {
+		controllerutil.AddFinalizer(userSpec, keycloakFinalizer)
+		if err := r.Update(ctx, userSpec); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	keycloak, err := r.Instances.Resolve(ctx, userSpec.Namespace, userSpec.Spec.InstanceRef)
+	if err != nil {
+		logger.Error(err, "Resolve Keycloak instance")
+		return ctrl.Result{}, err
+	}
+
+	// secret goes first, so generated password will not be lost if something goes wrong later
+	secret, err := r.getOrCreateSecret(ctx, keycloak, userSpec)
+	if errors2.Is(err, ErrSecretConflict) {
+		return ctrl.Result{RequeueAfter: time.Minute}, r.reportSecretConflict(ctx, userSpec, err)
+	}
+	if err != nil {
+		logger.Error(err, "Failed to get or create Secret")
+		return ctrl.Result{}, err
+	}
+	if err := r.reportSecretConflict(ctx, userSpec, nil); err != nil {
+		return ctrl.Result{}, err
+	}
+	password := string(secret.Data["password"])
+
+	// Ensure the secret is the same as the spec (before its version is recorded with password)
+	if err := r.updateSecret(ctx, keycloak, secret, userSpec, password); err != nil {
+		logger.Error(err, "Failed to update Secret", "Secret.Namespace", secret.Namespace, "Secret.Name", secret.Name)
+		return ctrl.Result{}, err
+	}
+
+	kClient := keycloak.Authorize(ctx)
+
+	user, err := r.getOrCreateUser(ctx, kClient, userSpec)
+	if errors2.Is(err, ErrAdoptionRefused) {
+		return ctrl.Result{RequeueAfter: time.Minute}, r.reportUserConflict(ctx, userSpec, err)
+	}
+	if err != nil {
+		logger.Error(err, "Create user")
+		return ctrl.Result{}, err
+	}
+	if err := r.reportUserConflict(ctx, userSpec, nil); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if err := r.syncPassword(ctx, kClient, user, userSpec, secret); err != nil {
+		logger.Error(err, "Set password")
+		return ctrl.Result{}, err
+	}
+
+	// sync manifest and keycloak
+	if err := r.updateUser(ctx, kClient, user, userSpec); err != nil {
+		logger.Error(err, "Update user")
+		return ctrl.Result{}, err
+	}
+
+	if err := r.syncMemberships(ctx, kClient, user.ID, userSpec.Spec); err != nil {
+		logger.Error(err, "Sync groups and roles")
+		return ctrl.Result{}, err
+	}
+
+	if userSpec.Status.ID != user.ID {
+		userSpec.Status.ID = user.ID
+		if err := r.Status().Update(ctx, userSpec); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{RequeueAfter: time.Minute}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *KeycloakUserReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&keycloakv1alpha1.KeycloakUser{}).
+		Owns(&v12.Secret{}).
+		Complete(r)
+}
+
+// getOrCreateSecret returns existent secret (if it's controlled by resource or can be adopted) or creates new one with
+// generated password. Password is generated and saved before returning if existent secret has no password.
+func (r *KeycloakUserReconciler) getOrCreateSecret(ctx context.Context, keycloak *internal.Keycloak, manifest *keycloakv1alpha1.KeycloakUser) (*v12.Secret, error) {
+	found := &v12.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: manifest.SecretName(), Namespace: manifest.Namespace}, found)
+	if err == nil {
+		if err := claimSecret(r.Scheme, found, manifest, manifest.Spec.SecretAdoption); err != nil {
+			return nil, err
+		}
+		if len(found.Data["password"]) > 0 {
+			return found, nil
+		}
+		log.Log.Info("Secret has no password, new password will be generated", "Namespace", found.Namespace, "Name", found.Name)
+		return found, r.updateSecret(ctx, keycloak, found, manifest, internal.GeneratePassword())
+	}
+	if !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	sec := &v12.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        manifest.SecretName(),
+			Namespace:   manifest.Namespace,
+			Labels:      userSecretLabels(manifest),
+			Annotations: manifest.Spec.Annotations,
+		},
+		Immutable: proto.Bool(true),
+		Data:      userSecretData(keycloak, manifest, internal.GeneratePassword()),
+		Type:      "Opaque",
+	}
+
+	if err := ctrl.SetControllerReference(manifest, sec, r.Scheme); err != nil {
+		return nil, fmt.Errorf("set controller refrence: %w", err)
+	}
+	log.Log.Info("New secret will be created", "Namespace", sec.Namespace, "Name", sec.Name)
+	return sec, r.Create(ctx, sec)
+}
+
+// updateSecret saves labels, annotations and content of secret with provided password.
+func (r *KeycloakUserReconciler) updateSecret(ctx context.Context, keycloak *internal.Keycloak, secret *v12.Secret, m *keycloakv1alpha1.KeycloakUser, password string) error {
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	for k, v := range m.Spec.Annotations {
+		secret.Annotations[k] = v
+	}
+	secret.Labels = userSecretLabels(m)
+	data := userSecretData(keycloak, m, password)
+	if secret.Immutable != nil && *secret.Immutable && !reflect.DeepEqual(secret.Data, data) {
+		// content of immutable secret can not be changed, so it has to be re-created (password is kept)
+		if err := r.Delete(ctx, secret); err != nil {
+			return fmt.Errorf("delete outdated secret: %w", err)
+		}
+		secret.ObjectMeta = metav1.ObjectMeta{
+			Name:        secret.Name,
+			Namespace:   secret.Namespace,
+			Labels:      secret.Labels,
+			Annotations: secret.Annotations,
+		}
+		secret.Data = data
+		secret.Type = "Opaque"
+		if err := ctrl.SetControllerReference(m, secret, r.Scheme); err != nil {
+			return fmt.Errorf("set controller refrence: %w", err)
+		}
+		log.Log.Info("Secret will be re-created", "Namespace", secret.Namespace, "Name", secret.Name)
+		return r.Create(ctx, secret)
+	}
+	secret.Data = data
+	secret.Type = "Opaque"
+	return r.Update(ctx, secret)
+}
+
+func userSecretData(keycloak *internal.Keycloak, m *keycloakv1alpha1.KeycloakUser, password string) map[string][]byte {
+	return map[string][]byte{
+		"username": []byte(m.Spec.Username),
+		"password": []byte(password),
+		"realm":    []byte(m.Spec.Realm),
+		"realmURL": []byte(keycloak.RealmURL(m.Spec.Realm)),
+	}
+}
+
+func userSecretLabels(m *keycloakv1alpha1.KeycloakUser) map[string]string {
+	var labels = map[string]string{
+		"keycloak-cr": m.Name,
+	}
+	for k, v := range m.Spec.Labels {
+		labels[k] = v
+	}
+	return labels
+}
+
+// userDraft from spec for new user. Ownership markers are added to attributes.
+func userDraft(spec keycloakv1alpha1.KeycloakUserSpec, owner internal.Owner) internal.User {
+	var attributes = owner.UserAttributes()
+	for k, v := range spec.Attributes {
+		attributes[k] = v
+	}
+	return internal.User{
+		Username:        spec.Username,
+		Email:           spec.Email,
+		Enabled:         proto.Bool(spec.Enabled == nil || *spec.Enabled),
+		Attributes:      attributes,
+		RequiredActions: spec.RequiredActions,
+	}
+}
+
+// getOrCreateUser finds user by username or creates new one. Returns ErrAdoptionRefused if user exists and can not be
+// managed by resource.
+func (r *KeycloakUserReconciler) getOrCreateUser(ctx context.Context, kClient *internal.AuthorizedKeycloak, manifest *keycloakv1alpha1.KeycloakUser) (*internal.User, error) {
+	realm := manifest.Spec.Realm
+	user, err := kClient.FindUser(ctx, realm, manifest.Spec.Username)
+	if err == nil {
+		return user, r.checkAdoption(user, manifest)
+	}
+	if !errors2.Is(err, internal.ErrUserNotFound) {
+		return nil, fmt.Errorf("find user: %w", err)
+	}
+	id, err := kClient.CreateUser(ctx, realm, userDraft(manifest.Spec, r.owner(manifest)))
+	if err != nil {
+		return nil, fmt.Errorf("create user: %w", err)
+	}
+	log.Log.Info("User created", "username", manifest.Spec.Username)
+	user, err = kClient.FindUser(ctx, realm, manifest.Spec.Username)
+	if err != nil {
+		return nil, fmt.Errorf("get created user %s: %w", id, err)
+	}
+	return user, nil
+}
+
+// syncPassword sets password from secret to user until version of secret is recorded in status, so failed attempt
+// (for example right after user creation) is repeated and changed secret is applied. Users reconciled before versions
+// were recorded (user ID in status) are assumed to have the password already.
+func (r *KeycloakUserReconciler) syncPassword(ctx context.Context, kClient *internal.AuthorizedKeycloak, user *internal.User, m *keycloakv1alpha1.KeycloakUser, secret *v12.Secret) error {
+	version := passwordSecretVersion(secret)
+	if m.Status.PasswordSecretVersion == version {
+		return nil
+	}
+	if m.Status.PasswordSecretVersion != "" || m.Status.ID != user.ID {
+		if err := kClient.SetPassword(ctx, m.Spec.Realm, user.ID, string(secret.Data["password"]), m.Spec.TemporaryPassword); err != nil {
+			return fmt.Errorf("set password: %w", err)
+		}
+		log.Log.Info("User password set", "username", m.Spec.Username)
+	}
+	m.Status.PasswordSecretVersion = version
+	return r.Status().Update(ctx, m)
+}
+
+// updateUser updates user if declared fields differ. Keycloak updates only fields present in the payload, so fields
+// not declared in the spec are left as is. Attributes from spec are merged into existent attributes.
+func (r *KeycloakUserReconciler) updateUser(ctx context.Context, kClient *internal.AuthorizedKeycloak, user *internal.User, m *keycloakv1alpha1.KeycloakUser) error {
+	spec := m.Spec
+	wanted := r.owner(m).UserAttributes()
+	for k, v := range spec.Attributes {
+		wanted[k] = v
+	}
+	same := (spec.Email == "" || user.Email == spec.Email) &&
+		(spec.Enabled == nil || user.Enabled != nil && *user.Enabled == *spec.Enabled) &&
+		includesAttributes(user.Attributes, wanted) &&
+		(len(spec.RequiredActions) == 0 || sameSet(user.RequiredActions, spec.RequiredActions))
+	if same {
+		return nil
+	}
+	var attributes = make(map[string][]string, len(user.Attributes)+len(wanted))
+	for k, v := range user.Attributes {
+		attributes[k] = v
+	}
+	for k, v := range wanted {
+		attributes[k] = v
+	}
+	draft := internal.User{
+		Username:        spec.Username,
+		Email:           spec.Email,
+		Enabled:         spec.Enabled,
+		Attributes:      attributes,
+		RequiredActions: spec.RequiredActions,
+	}
+	if err := kClient.UpdateUser(ctx, spec.Realm, user.ID, draft); err != nil {
+		return fmt.Errorf("update user: %w", err)
+	}
+	log.Log.Info("Keycloak user synced with manifest")
+	return nil
+}
+
+// syncMemberships adds missed groups and roles. It never removes memberships or roles which were assigned outside of
+// manifest.
+func (r *KeycloakUserReconciler) syncMemberships(ctx context.Context, kClient *internal.AuthorizedKeycloak, userID string, spec keycloakv1alpha1.KeycloakUserSpec) error {
+	realm := spec.Realm
+	if len(spec.Groups) > 0 {
+		groups, err := kClient.UserGroups(ctx, realm, userID)
+		if err != nil {
+			return fmt.Errorf("get user groups: %w", err)
+		}
+		var joined = make(map[string]bool, len(groups))
+		for _, g := range groups {
+			joined[g.Path] = true
+		}
+		for _, groupPath := range spec.Groups {
+			if joined[groupPath] {
+				continue
+			}
+			group, err := kClient.GroupByPath(ctx, realm, groupPath)
+			if err != nil {
+				return err
+			}
+			if err := kClient.JoinGroup(ctx, realm, userID, group.ID); err != nil {
+				return fmt.Errorf("join group %q: %w", groupPath, err)
+			}
+		}
+	}
+
+	if len(spec.RealmRoles) > 0 {
+		assigned, err := kClient.UserRealmRoles(ctx, realm, userID)
+		if err != nil {
+			return fmt.Errorf("get user realm roles: %w", err)
+		}
+		missed, err := missedRoles(assigned, spec.RealmRoles, func(name string) (*internal.Role, error) {
+			return kClient.RealmRole(ctx, realm, name)
+		})
+		if err != nil {
+			return err
+		}
+		if len(missed) > 0 {
+			if err := kClient.AddUserRealmRoles(ctx, realm, userID, missed); err != nil {
+				return fmt.Errorf("add realm roles: %w", err)
+			}
+		}
+	}
+
+	for clientID, roles := range spec.ClientRoles {
+		if len(roles) == 0 {
+			continue
+		}
+		info, err := kClient.Clients(ctx, realm).Find(clientID)
+		if err != nil {
+			return fmt.Errorf("find client %q: %w", clientID, err)
+		}
+		assigned, err := kClient.UserClientRoles(ctx, realm, userID, info.ID)
+		if err != nil {
+			return fmt.Errorf("get user client roles: %w", err)
+		}
+		missed, err := missedRoles(assigned, roles, func(name string) (*internal.Role, error) {
+			return kClient.ClientRole(ctx, realm, info.ID, name)
+		})
+		if err != nil {
+			return err
+		}
+		if len(missed) > 0 {
+			if err := kClient.AddUserClientRoles(ctx, realm, userID, info.ID, missed); err != nil {
+				return fmt.Errorf("add client %q roles: %w", clientID, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *KeycloakUserReconciler) removeUser(ctx context.Context, spec *keycloakv1alpha1.KeycloakUser) error {
+	keycloak, err := r.Instances.Resolve(ctx, spec.Namespace, spec.Spec.InstanceRef)
+	if err != nil {
+		return err
+	}
+	kClient := keycloak.Authorize(ctx)
+	user, err := kClient.FindUser(ctx, spec.Spec.Realm, spec.Spec.Username)
+	if errors2.Is(err, internal.ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !r.owns(user, spec) {
+		log.Log.Info("User is not owned by resource and will not be removed", "username", user.Username)
+		return nil
+	}
+	return kClient.DeleteUser(ctx, spec.Spec.Realm, user.ID)
+}
+
+func missedRoles(assigned []internal.Role, required []string, lookup func(name string) (*internal.Role, error)) ([]internal.Role, error) {
+	var has = make(map[string]bool, len(assigned))
+	for _, role := range assigned {
+		has[role.Name] = true
+	}
+	var missed []internal.Role
+	for _, name := range required {
+		if has[name] {
+			continue
+		}
+		role, err := lookup(name)
+		if err != nil {
+			return nil, err
+		}
+		missed = append(missed, *role)
+	}
+	return missed, nil
+}
+
+func sameSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	return reflect.DeepEqual(a, b)
+}
+
+// includesAttributes returns true if all attributes from subset have the same values in src.
+func includesAttributes(src map[string][]string, subset map[string][]string) bool {
+	for k, v := range subset {
+		if sv, ok := src[k]; !ok || !reflect.DeepEqual(sv, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// passwordSecretVersion returns version of secret with password to record in status. Password itself (or anything
+// derived from it) is not exposed in status.
+func passwordSecretVersion(secret *v12.Secret) string {
+	return string(secret.UID) + "/" + secret.ResourceVersion
+}
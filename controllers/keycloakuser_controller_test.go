@@ -0,0 +1,401 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+	"github.com/reddec/keycloak-ext-operator/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v12 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestMissedRoles(t *testing.T) {
+	realmRoles := map[string]internal.Role{
+		"admin":  {ID: "1", Name: "admin"},
+		"viewer": {ID: "2", Name: "viewer"},
+	}
+	lookup := func(name string) (*internal.Role, error) {
+		role, ok := realmRoles[name]
+		if !ok {
+			return nil, internal.ErrNotFound
+		}
+		return &role, nil
+	}
+	cases := []struct {
+		name     string
+		assigned []internal.Role
+		required []string
+		missed   []string
+		err      error
+	}{
+		{
+			name:     "nothing assigned",
+			required: []string{"admin", "viewer"},
+			missed:   []string{"admin", "viewer"},
+		},
+		{
+			name:     "partially assigned",
+			assigned: []internal.Role{realmRoles["viewer"]},
+			required: []string{"admin", "viewer"},
+			missed:   []string{"admin"},
+		},
+		{
+			name:     "all assigned",
+			assigned: []internal.Role{realmRoles["admin"], realmRoles["viewer"]},
+			required: []string{"admin"},
+		},
+		{
+			name:     "unknown role",
+			required: []string{"admin", "unknown"},
+			err:      internal.ErrNotFound,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			missed, err := missedRoles(c.assigned, c.required, lookup)
+			if c.err != nil {
+				assert.ErrorIs(t, err, c.err)
+				return
+			}
+			require.NoError(t, err)
+			var names []string
+			for _, role := range missed {
+				assert.Equal(t, realmRoles[role.Name], role)
+				names = append(names, role.Name)
+			}
+			assert.Equal(t, c.missed, names)
+		})
+	}
+}
+
+func TestSameSet(t *testing.T) {
+	cases := []struct {
+		name string
+		a, b []string
+		same bool
+	}{
+		{name: "empty", same: true},
+		{name: "nil and empty", a: nil, b: []string{}, same: true},
+		{name: "same order", a: []string{"a", "b"}, b: []string{"a", "b"}, same: true},
+		{name: "other order", a: []string{"b", "a"}, b: []string{"a", "b"}, same: true},
+		{name: "different", a: []string{"a", "b"}, b: []string{"a", "c"}},
+		{name: "different length", a: []string{"a"}, b: []string{"a", "b"}},
+		{name: "duplicates", a: []string{"a", "a"}, b: []string{"a", "b"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a := append([]string(nil), c.a...)
+			assert.Equal(t, c.same, sameSet(c.a, c.b))
+			assert.Equal(t, a, append([]string(nil), c.a...), "arguments are not modified")
+		})
+	}
+}
+
+func TestKeycloakUserReconciler_checkAdoption(t *testing.T) {
+	foreign := internal.Owner{Cluster: "c1", UID: "uid-2"}.UserAttributes()
+	cases := []struct {
+		name     string
+		adoption string
+		statusID string
+		attrs    map[string][]string
+		refused  bool
+	}{
+		{name: "owned", attrs: internal.Owner{Cluster: "c1", UID: "uid-1"}.UserAttributes()},
+		{name: "legacy by status", statusID: "user-1"},
+		{name: "unmanaged default", refused: true},
+		{name: "unmanaged never", adoption: adoptionNever, refused: true},
+		{name: "unmanaged ifUnmanaged", adoption: "ifUnmanaged"},
+		{name: "managed ifUnmanaged", adoption: "ifUnmanaged", attrs: foreign, refused: true},
+		{name: "managed always", adoption: adoptionAlways, attrs: foreign},
+		{name: "managed with legacy status", statusID: "user-1", attrs: foreign, refused: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := &keycloakv1alpha1.KeycloakUser{
+				ObjectMeta: metav1.ObjectMeta{Name: "john", Namespace: "default", UID: "uid-1"},
+				Spec:       keycloakv1alpha1.KeycloakUserSpec{Adoption: c.adoption},
+				Status:     keycloakv1alpha1.KeycloakUserStatus{ID: c.statusID},
+			}
+			user := &internal.User{ID: "user-1", Username: "john", Attributes: c.attrs}
+			r := &KeycloakUserReconciler{ClusterID: "c1"}
+			err := r.checkAdoption(user, m)
+			if c.refused {
+				assert.ErrorIs(t, err, ErrAdoptionRefused)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestKeycloakUserReconciler_syncPassword(t *testing.T) {
+	cases := []struct {
+		name     string
+		statusID string
+		recorded string
+		version  string // resource version of secret
+		failed   bool
+		set      bool
+	}{
+		{name: "new user", version: "1", set: true},
+		{name: "already set", statusID: "user-1", recorded: "secret-1/1", version: "1"},
+		{name: "changed in secret", statusID: "user-1", recorded: "secret-1/1", version: "2", set: true},
+		{name: "set before versions were recorded", statusID: "user-1", version: "1"},
+		{name: "adopted user", statusID: "user-0", version: "1", set: true},
+		{name: "failed attempt is not recorded", version: "1", failed: true, set: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var set []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/realms/master/protocol/openid-connect/token":
+					_ = json.NewEncoder(w).Encode(map[string]any{"token_type": "Bearer", "access_token": "token", "expires_in": 60})
+				case "/admin/realms/test/users/user-1/reset-password":
+					var payload struct{ Value string }
+					_ = json.NewDecoder(r.Body).Decode(&payload)
+					set = append(set, payload.Value)
+					if c.failed {
+						w.WriteHeader(http.StatusInternalServerError)
+						return
+					}
+					w.WriteHeader(http.StatusNoContent)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer srv.Close()
+
+			m := &keycloakv1alpha1.KeycloakUser{
+				ObjectMeta: metav1.ObjectMeta{Name: "robot", Namespace: "default"},
+				Spec:       keycloakv1alpha1.KeycloakUserSpec{Realm: "test", Username: "robot"},
+				Status:     keycloakv1alpha1.KeycloakUserStatus{ID: c.statusID, PasswordSecretVersion: c.recorded},
+			}
+			secret := &v12.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "robot", Namespace: "default", UID: "secret-1", ResourceVersion: c.version},
+				Data:       map[string][]byte{"password": []byte("p1")},
+			}
+			k8s := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(m).WithStatusSubresource(m).Build()
+			r := &KeycloakUserReconciler{Client: k8s}
+			kc := &internal.Keycloak{URL: srv.URL}
+			ctx := context.Background()
+
+			err := r.syncPassword(ctx, kc.Authorize(ctx), &internal.User{ID: "user-1", Username: "robot"}, m, secret)
+			assert.Equal(t, c.failed, err != nil)
+			if c.set {
+				assert.Equal(t, []string{"p1"}, set)
+			} else {
+				assert.Empty(t, set)
+			}
+			var saved keycloakv1alpha1.KeycloakUser
+			require.NoError(t, k8s.Get(ctx, types.NamespacedName{Name: "robot", Namespace: "default"}, &saved))
+			expected := "secret-1/" + c.version
+			if c.failed {
+				expected = c.recorded
+			}
+			assert.Equal(t, expected, saved.Status.PasswordSecretVersion)
+		})
+	}
+}
+
+func TestKeycloakUserReconciler_getOrCreateSecret(t *testing.T) {
+	m := &keycloakv1alpha1.KeycloakUser{
+		TypeMeta:   metav1.TypeMeta{APIVersion: keycloakv1alpha1.GroupVersion.String(), Kind: "KeycloakUser"},
+		ObjectMeta: metav1.ObjectMeta{Name: "robot", Namespace: "default", UID: "uid-1"},
+		Spec:       keycloakv1alpha1.KeycloakUserSpec{Realm: "test", Username: "robot"},
+	}
+	controller := true
+	ownedBy := func(kind, name, uid string) []metav1.OwnerReference {
+		return []metav1.OwnerReference{{APIVersion: keycloakv1alpha1.GroupVersion.String(), Kind: kind, Name: name, UID: types.UID(uid), Controller: &controller}}
+	}
+	cases := []struct {
+		name     string
+		adoption string
+		existent *v12.Secret
+		conflict bool
+		password string
+	}{
+		{name: "created"},
+		{
+			name:     "controlled by resource",
+			existent: &v12.Secret{ObjectMeta: metav1.ObjectMeta{OwnerReferences: ownedBy("KeycloakUser", "robot", "uid-1")}, Data: map[string][]byte{"password": []byte("kept")}},
+			password: "kept",
+		},
+		{
+			name:     "controlled by another resource",
+			adoption: secretAdoptionAdopt,
+			existent: &v12.Secret{ObjectMeta: metav1.ObjectMeta{OwnerReferences: ownedBy("KeycloakClient", "robot", "uid-2")}, Data: map[string][]byte{"password": []byte("foreign")}},
+			conflict: true,
+		},
+		{
+			name:     "not controlled",
+			existent: &v12.Secret{Data: map[string][]byte{"password": []byte("manual")}},
+			conflict: true,
+		},
+		{
+			name:     "adopted with password",
+			adoption: secretAdoptionAdopt,
+			existent: &v12.Secret{Data: map[string][]byte{"password": []byte("manual")}},
+			password: "manual",
+		},
+		{
+			name:     "adopted without password",
+			adoption: secretAdoptionAdopt,
+			existent: &v12.Secret{Data: map[string][]byte{"token": []byte("xyz")}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			builder := fake.NewClientBuilder().WithScheme(testScheme(t))
+			if c.existent != nil {
+				c.existent.Name = "robot"
+				c.existent.Namespace = "default"
+				builder = builder.WithObjects(c.existent)
+			}
+			k8s := builder.Build()
+			r := &KeycloakUserReconciler{Client: k8s, Scheme: k8s.Scheme()}
+			manifest := m.DeepCopy()
+			manifest.Spec.SecretAdoption = c.adoption
+			ctx := context.Background()
+
+			secret, err := r.getOrCreateSecret(ctx, &internal.Keycloak{URL: "https://keycloak"}, manifest)
+			if c.conflict {
+				assert.ErrorIs(t, err, ErrSecretConflict)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, metav1.IsControlledBy(secret, manifest))
+			if c.password != "" {
+				assert.Equal(t, c.password, string(secret.Data["password"]))
+				return
+			}
+			// generated password is saved before it's used
+			var saved v12.Secret
+			require.NoError(t, k8s.Get(ctx, types.NamespacedName{Name: "robot", Namespace: "default"}, &saved))
+			assert.Len(t, saved.Data["password"], 64)
+			assert.Equal(t, saved.Data["password"], secret.Data["password"])
+			assert.Equal(t, "robot", string(saved.Data["username"]))
+		})
+	}
+}
+
+func TestKeycloakUserReconciler_updateUser(t *testing.T) {
+	r := &KeycloakUserReconciler{ClusterID: "c1"}
+	m := &keycloakv1alpha1.KeycloakUser{ObjectMeta: metav1.ObjectMeta{Name: "robot", Namespace: "default", UID: "uid-1"}}
+	markers := r.owner(m).UserAttributes()
+	withMarkers := func(attrs map[string][]string) map[string][]string {
+		merged := make(map[string][]string, len(markers)+len(attrs))
+		for k, v := range markers {
+			merged[k] = v
+		}
+		for k, v := range attrs {
+			merged[k] = v
+		}
+		return merged
+	}
+	disabled, enabled := false, true
+	cases := []struct {
+		name    string
+		spec    keycloakv1alpha1.KeycloakUserSpec
+		user    internal.User
+		payload map[string]any
+	}{
+		{
+			name: "in sync",
+			spec: keycloakv1alpha1.KeycloakUserSpec{Attributes: map[string][]string{"team": {"platform"}}},
+			user: internal.User{Enabled: &enabled, Attributes: withMarkers(map[string][]string{"team": {"platform"}, "locale": {"en"}})},
+		},
+		{
+			name: "enabled is not declared",
+			user: internal.User{Enabled: &disabled, Email: "manual@example.com", Attributes: withMarkers(nil)},
+		},
+		{
+			name: "disabled by spec",
+			spec: keycloakv1alpha1.KeycloakUserSpec{Enabled: &disabled},
+			user: internal.User{Enabled: &enabled, Attributes: withMarkers(nil)},
+			payload: map[string]any{
+				"username":   "robot",
+				"enabled":    false,
+				"attributes": toJSON(t, withMarkers(nil)),
+			},
+		},
+		{
+			name: "attributes are merged",
+			spec: keycloakv1alpha1.KeycloakUserSpec{Attributes: map[string][]string{"team": {"platform"}}},
+			user: internal.User{Enabled: &enabled, Attributes: map[string][]string{"team": {"web"}, "locale": {"en"}}},
+			payload: map[string]any{
+				"username":   "robot",
+				"attributes": toJSON(t, withMarkers(map[string][]string{"team": {"platform"}, "locale": {"en"}})),
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var payloads []map[string]any
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.URL.Path == "/realms/master/protocol/openid-connect/token":
+					_ = json.NewEncoder(w).Encode(map[string]any{"token_type": "Bearer", "access_token": "token", "expires_in": 60})
+				case r.URL.Path == "/admin/realms/test/users/user-1" && r.Method == http.MethodPut:
+					var payload map[string]any
+					_ = json.NewDecoder(r.Body).Decode(&payload)
+					payloads = append(payloads, payload)
+					w.WriteHeader(http.StatusNoContent)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer srv.Close()
+
+			manifest := m.DeepCopy()
+			manifest.Spec = c.spec
+			manifest.Spec.Realm = "test"
+			manifest.Spec.Username = "robot"
+			user := c.user
+			user.ID = "user-1"
+			user.Username = "robot"
+			kc := &internal.Keycloak{URL: srv.URL}
+			ctx := context.Background()
+
+			require.NoError(t, r.updateUser(ctx, kc.Authorize(ctx), &user, manifest))
+			if c.payload == nil {
+				assert.Empty(t, payloads)
+			} else {
+				assert.Equal(t, []map[string]any{c.payload}, payloads)
+			}
+		})
+	}
+}
+
+// toJSON returns value as it's decoded from JSON into untyped map.
+func toJSON(t *testing.T, value any) any {
+	data, err := json.Marshal(value)
+	require.NoError(t, err)
+	var out any
+	require.NoError(t, json.Unmarshal(data, &out))
+	return out
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+//+kubebuilder:webhook:path=/validate-keycloak-k8s-reddec-net-v1alpha1-keycloakuser,mutating=false,failurePolicy=fail,sideEffects=None,groups=keycloak.k8s.reddec.net,resources=keycloakusers,verbs=create;update,versions=v1alpha1,name=vkeycloakuser.kb.io,admissionReviewVersions=v1
+
+// KeycloakUserValidator validates KeycloakUser on admission against access policies.
+type KeycloakUserValidator struct {
+	Policies *AccessPolicies
+}
+
+var _ admission.CustomValidator = &KeycloakUserValidator{}
+
+func (v *KeycloakUserValidator) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&keycloakv1alpha1.KeycloakUser{}).
+		WithValidator(v).
+		Complete()
+}
+
+func (v *KeycloakUserValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	m, err := asUser(obj)
+	if err != nil {
+		return nil, err
+	}
+	return nil, v.Policies.Check(ctx, m)
+}
+
+func (v *KeycloakUserValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	old, err := asUser(oldObj)
+	if err != nil {
+		return nil, err
+	}
+	m, err := asUser(newObj)
+	if err != nil {
+		return nil, err
+	}
+	// metadata changes (finalizers, annotations) should not be blocked, otherwise denied users can not be removed
+	if m.DeletionTimestamp != nil || equality.Semantic.DeepEqual(old.Spec, m.Spec) {
+		return nil, nil
+	}
+	return nil, v.Policies.Check(ctx, m)
+}
+
+func (v *KeycloakUserValidator) ValidateDelete(context.Context, runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func asUser(obj runtime.Object) (*keycloakv1alpha1.KeycloakUser, error) {
+	m, ok := obj.(*keycloakv1alpha1.KeycloakUser)
+	if !ok {
+		return nil, fmt.Errorf("expected KeycloakUser, got %T", obj)
+	}
+	return m, nil
+}
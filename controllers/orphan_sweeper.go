@@ -0,0 +1,230 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/reddec/keycloak-ext-operator/internal"
+	v12 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+// Policies of orphaned clients.
+const (
+	OrphanPolicyReport = "Report"
+	OrphanPolicyDelete = "Delete"
+)
+
+var (
+	orphanedClients = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "keycloak_orphaned_clients",
+		Help: "Number of Keycloak clients owned by removed KeycloakClient resources (by last sweep)",
+	}, []string{"instance", "realm"})
+	removedOrphans = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "keycloak_orphaned_clients_removed_total",
+		Help: "Number of removed orphaned Keycloak clients",
+	}, []string{"instance", "realm"})
+	sweepErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "keycloak_orphan_sweep_errors_total",
+		Help: "Number of errors during sweep of orphaned Keycloak clients (instance was not swept completely)",
+	}, []string{"instance", "operation"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(orphanedClients, removedOrphans, sweepErrors)
+}
+
+// OrphanSweeper periodically looks for Keycloak clients with ownership markers of this cluster, whose KeycloakClient
+// resource (by UID) no longer exists, for example, after etcd restore or manual removal of finalizer. Orphans are
+// reported by metrics, logs and events (on instance resource) and, depending on policy, removed.
+// Client is treated as orphan only if it was found by two sweeps in a row, so lagging cache does not cause removal
+// of just created clients.
+type OrphanSweeper struct {
+	Client    client.Client
+	Instances *Instances
+	Recorder  record.EventRecorder
+	ClusterID string
+	Interval  time.Duration
+	// Policy is Report (default) or Delete.
+	Policy string
+	// DryRun only reports clients which would be removed.
+	DryRun bool
+
+	suspects map[string]bool
+}
+
+type sweepTarget struct {
+	name     string
+	object   client.Object // nil for default instance
+	keycloak *internal.Keycloak
+}
+
+func (s *OrphanSweeper) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(s)
+}
+
+func (s *OrphanSweeper) NeedLeaderElection() bool {
+	return true
+}
+
+func (s *OrphanSweeper) Start(ctx context.Context) error {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := s.Sweep(ctx); err != nil {
+				log.FromContext(ctx).Error(err, "Sweep orphaned clients")
+			}
+		}
+	}
+}
+
+// Sweep looks for orphaned clients in all known instances.
+func (s *OrphanSweeper) Sweep(ctx context.Context) error {
+	var list keycloakv1alpha1.KeycloakClientList
+	if err := s.Client.List(ctx, &list); err != nil {
+		return fmt.Errorf("list clients: %w", err)
+	}
+	var existent = make(map[string]bool, len(list.Items))
+	for _, item := range list.Items {
+		existent[string(item.UID)] = true
+	}
+
+	targets, err := s.targets(ctx)
+	if err != nil {
+		return err
+	}
+	orphanedClients.Reset()
+	var suspects = make(map[string]bool)
+	for _, target := range targets {
+		if err := s.sweepInstance(ctx, target, existent, suspects); err != nil {
+			log.FromContext(ctx).Error(err, "Sweep instance", "instance", target.name)
+		}
+	}
+	s.suspects = suspects
+	return nil
+}
+
+// targets returns default instance and all declared instances. Instances with the same URL are swept once.
+func (s *OrphanSweeper) targets(ctx context.Context) ([]sweepTarget, error) {
+	var ans []sweepTarget
+	var seen = make(map[string]bool)
+	add := func(name string, object client.Object, ref *keycloakv1alpha1.InstanceReference, namespace string) {
+		keycloak, err := s.Instances.Resolve(ctx, namespace, ref)
+		if err != nil {
+			// instance is skipped, but it should not look like there are no orphans
+			sweepErrors.WithLabelValues(name, "resolve").Inc()
+			log.FromContext(ctx).Error(err, "Resolve instance", "instance", name)
+			return
+		}
+		if seen[keycloak.URL] {
+			return
+		}
+		seen[keycloak.URL] = true
+		ans = append(ans, sweepTarget{name: name, object: object, keycloak: keycloak})
+	}
+
+	if s.Instances.Default != nil {
+		add("default", nil, nil, "")
+	}
+	var clusterInstances keycloakv1alpha1.ClusterKeycloakInstanceList
+	if err := s.Client.List(ctx, &clusterInstances); err != nil {
+		return nil, fmt.Errorf("list cluster instances: %w", err)
+	}
+	for i := range clusterInstances.Items {
+		instance := &clusterInstances.Items[i]
+		add(clusterInstanceKind+"/"+instance.Name, instance, &keycloakv1alpha1.InstanceReference{Kind: clusterInstanceKind, Name: instance.Name}, "")
+	}
+	var instances keycloakv1alpha1.KeycloakInstanceList
+	if err := s.Client.List(ctx, &instances); err != nil {
+		return nil, fmt.Errorf("list instances: %w", err)
+	}
+	for i := range instances.Items {
+		instance := &instances.Items[i]
+		name := types.NamespacedName{Namespace: instance.Namespace, Name: instance.Name}
+		add("KeycloakInstance/"+name.String(), instance, &keycloakv1alpha1.InstanceReference{Name: instance.Name}, instance.Namespace)
+	}
+	return ans, nil
+}
+
+func (s *OrphanSweeper) sweepInstance(ctx context.Context, target sweepTarget, existent map[string]bool, suspects map[string]bool) error {
+	kClient := target.keycloak.Authorize(ctx)
+	realms, err := kClient.Realms(ctx)
+	if err != nil {
+		sweepErrors.WithLabelValues(target.name, "realms").Inc()
+		return fmt.Errorf("list realms: %w", err)
+	}
+	for _, realm := range realms {
+		list, err := kClient.Clients(ctx, realm.Realm).All()
+		if err != nil {
+			sweepErrors.WithLabelValues(target.name, "clients").Inc()
+			log.FromContext(ctx).Error(err, "List clients", "instance", target.name, "realm", realm.Realm)
+			continue
+		}
+		for _, info := range list {
+			owner, ok := info.Owner()
+			if !ok || owner.Cluster != s.ClusterID || existent[owner.UID] {
+				continue
+			}
+			key := target.name + "/" + realm.Realm + "/" + info.ID
+			suspects[key] = true
+			if !s.suspects[key] {
+				continue
+			}
+			orphanedClients.WithLabelValues(target.name, realm.Realm).Inc()
+			s.handleOrphan(ctx, kClient, target, realm.Realm, info, owner)
+		}
+	}
+	return nil
+}
+
+func (s *OrphanSweeper) handleOrphan(ctx context.Context, kClient *internal.AuthorizedKeycloak, target sweepTarget, realm string, info internal.Client, owner internal.Owner) {
+	logger := log.FromContext(ctx).WithValues("instance", target.name, "realm", realm, "client_id", info.ClientID, "owner", owner.String())
+	logger.Info("Orphaned client found")
+	if target.object != nil {
+		s.Recorder.Eventf(target.object, v12.EventTypeWarning, "OrphanedClient", "Client %q in realm %q is owned by removed resource %s", info.ClientID, realm, owner)
+	}
+	if s.Policy != OrphanPolicyDelete {
+		return
+	}
+	if s.DryRun {
+		logger.Info("Orphaned client would be removed (dry-run)")
+		return
+	}
+	if err := kClient.Delete(ctx, realm, info.ID); err != nil && !errors.Is(err, internal.ErrClientNotFound) {
+		sweepErrors.WithLabelValues(target.name, "delete").Inc()
+		logger.Error(err, "Remove orphaned client")
+		return
+	}
+	removedOrphans.WithLabelValues(target.name, realm).Inc()
+	logger.Info("Orphaned client removed")
+}
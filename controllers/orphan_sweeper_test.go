@@ -0,0 +1,185 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/reddec/keycloak-ext-operator/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
+)
+
+// fakeKeycloak serves minimal admin API: token, realms, clients of realm "test" and removal of clients.
+type fakeKeycloak struct {
+	lock    sync.Mutex
+	clients []internal.Client
+	deleted []string
+}
+
+func (f *fakeKeycloak) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	const clientsPath = "/admin/realms/test/clients"
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/realms/master/protocol/openid-connect/token":
+		_ = json.NewEncoder(w).Encode(map[string]any{"token_type": "Bearer", "access_token": "token", "expires_in": 300})
+	case r.Method == http.MethodGet && r.URL.Path == "/admin/realms":
+		_ = json.NewEncoder(w).Encode([]internal.Realm{{Realm: "test", Enabled: true}})
+	case r.Method == http.MethodGet && r.URL.Path == clientsPath:
+		_ = json.NewEncoder(w).Encode(f.clients)
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, clientsPath+"/"):
+		id := strings.TrimPrefix(r.URL.Path, clientsPath+"/")
+		f.deleted = append(f.deleted, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeKeycloak) Deleted() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]string(nil), f.deleted...)
+}
+
+func ownedClient(id, cluster, uid string) internal.Client {
+	return internal.Client{ID: id, ClientID: id, Attributes: map[string]string{
+		internal.AttrOwnerCluster:   cluster,
+		internal.AttrOwnerNamespace: "default",
+		internal.AttrOwnerName:      id,
+		internal.AttrOwnerUID:       uid,
+	}}
+}
+
+func newTestSweeper(t *testing.T, kc *fakeKeycloak, objects ...client.Object) *OrphanSweeper {
+	srv := httptest.NewServer(kc)
+	t.Cleanup(srv.Close)
+	instance, err := internal.NewKeycloak(internal.Keycloak{URL: srv.URL, User: "admin", Password: "admin"})
+	require.NoError(t, err)
+	k8s := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(objects...).Build()
+	return &OrphanSweeper{
+		Client:    k8s,
+		Instances: &Instances{Client: k8s, Default: instance},
+		Recorder:  record.NewFakeRecorder(100),
+		ClusterID: "c1",
+		Policy:    OrphanPolicyDelete,
+	}
+}
+
+func keycloakClientResource(name string, uid types.UID) *keycloakv1alpha1.KeycloakClient {
+	return &keycloakv1alpha1.KeycloakClient{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: uid}}
+}
+
+func TestOrphanSweeper_Sweep(t *testing.T) {
+	ctx := context.Background()
+	kc := &fakeKeycloak{clients: []internal.Client{
+		ownedClient("orphan", "c1", "removed"),
+		ownedClient("alive", "c1", "alive-uid"),
+		ownedClient("foreign", "c2", "foreign-uid"),
+		ownedClient("lagging", "c1", "lagging-uid"),
+		{ID: "unmanaged", ClientID: "unmanaged"},
+	}}
+	sweeper := newTestSweeper(t, kc, keycloakClientResource("alive", "alive-uid"))
+
+	require.NoError(t, sweeper.Sweep(ctx))
+	assert.Empty(t, kc.Deleted(), "first sweep only marks suspects")
+	assert.Equal(t, map[string]bool{"default/test/orphan": true, "default/test/lagging": true}, sweeper.suspects)
+
+	// resource appeared in cache after the first sweep
+	require.NoError(t, sweeper.Client.Create(ctx, keycloakClientResource("lagging", "lagging-uid")))
+
+	require.NoError(t, sweeper.Sweep(ctx))
+	assert.Equal(t, []string{"orphan"}, kc.Deleted())
+}
+
+func TestOrphanSweeper_Sweep_disappearedSuspect(t *testing.T) {
+	ctx := context.Background()
+	kc := &fakeKeycloak{clients: []internal.Client{ownedClient("orphan", "c1", "removed")}}
+	sweeper := newTestSweeper(t, kc)
+
+	require.NoError(t, sweeper.Sweep(ctx))
+	kc.lock.Lock()
+	kc.clients = nil
+	kc.lock.Unlock()
+	require.NoError(t, sweeper.Sweep(ctx))
+	assert.Empty(t, sweeper.suspects, "suspects are not carried over if client is gone")
+
+	kc.lock.Lock()
+	kc.clients = []internal.Client{ownedClient("orphan", "c1", "removed")}
+	kc.lock.Unlock()
+	require.NoError(t, sweeper.Sweep(ctx))
+	assert.Empty(t, kc.Deleted(), "client must be seen by two sweeps in a row")
+}
+
+func TestOrphanSweeper_Sweep_policies(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		dryRun bool
+	}{
+		{name: "report", policy: OrphanPolicyReport},
+		{name: "default policy", policy: ""},
+		{name: "delete dry-run", policy: OrphanPolicyDelete, dryRun: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			kc := &fakeKeycloak{clients: []internal.Client{ownedClient("orphan", "c1", "removed")}}
+			sweeper := newTestSweeper(t, kc)
+			sweeper.Policy = tt.policy
+			sweeper.DryRun = tt.dryRun
+
+			for i := 0; i < 3; i++ {
+				require.NoError(t, sweeper.Sweep(ctx))
+			}
+			assert.Empty(t, kc.Deleted())
+			assert.Equal(t, 1.0, testutil.ToFloat64(orphanedClients.WithLabelValues("default", "test")))
+		})
+	}
+}
+
+func TestOrphanSweeper_Sweep_resolveError(t *testing.T) {
+	ctx := context.Background()
+	broken := &keycloakv1alpha1.ClusterKeycloakInstance{
+		ObjectMeta: metav1.ObjectMeta{Name: "broken"},
+		Spec: keycloakv1alpha1.KeycloakInstanceSpec{
+			URL:               "http://keycloak.invalid",
+			CredentialsSecret: keycloakv1alpha1.InstanceSecretReference{Name: "missing", Namespace: "default"},
+		},
+	}
+	sweeper := newTestSweeper(t, &fakeKeycloak{}, broken)
+	counter := sweepErrors.WithLabelValues(clusterInstanceKind+"/broken", "resolve")
+	before := testutil.ToFloat64(counter)
+
+	require.NoError(t, sweeper.Sweep(ctx))
+	assert.Equal(t, before+1, testutil.ToFloat64(counter))
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// page for listing authorization objects. Keycloak returns only first 100 items by default.
+var authzPage = url.Values{
+	"first": []string{"0"},
+	"max":   []string{"10000"},
+}
+
+type ResourceServer struct {
+	PolicyEnforcementMode         string `json:"policyEnforcementMode"`
+	DecisionStrategy              string `json:"decisionStrategy"`
+	AllowRemoteResourceManagement bool   `json:"allowRemoteResourceManagement"`
+}
+
+type AuthzScope struct {
+	ID          string `json:"id,omitempty"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName,omitempty"`
+}
+
+type AuthzResource struct {
+	ID                 string       `json:"_id,omitempty"`
+	Name               string       `json:"name"`
+	DisplayName        string       `json:"displayName,omitempty"`
+	Type               string       `json:"type,omitempty"`
+	URIs               []string     `json:"uris"`
+	Scopes             []AuthzScope `json:"scopes"`
+	OwnerManagedAccess bool         `json:"ownerManagedAccess"`
+}
+
+// AuthzPolicy is a policy or permission (policy with type resource or scope).
+type AuthzPolicy struct {
+	ID               string             `json:"id,omitempty"`
+	Name             string             `json:"name"`
+	Type             string             `json:"type"`
+	Description      string             `json:"description,omitempty"`
+	Logic            string             `json:"logic,omitempty"`
+	DecisionStrategy string             `json:"decisionStrategy,omitempty"`
+	Roles            []AuthzPolicyRole  `json:"roles,omitempty"`
+	Groups           []AuthzPolicyGroup `json:"groups,omitempty"`
+	Clients          []string           `json:"clients,omitempty"`
+	Resources        []string           `json:"resources,omitempty"`
+	Scopes           []string           `json:"scopes,omitempty"`
+	Policies         []string           `json:"policies,omitempty"`
+}
+
+type AuthzPolicyRole struct {
+	// ID of role, or name for realm role, or clientId/name for client role.
+	ID       string `json:"id"`
+	Required bool   `json:"required"`
+}
+
+type AuthzPolicyGroup struct {
+	Path           string `json:"path"`
+	ExtendChildren bool   `json:"extendChildren"`
+}
+
+// ResourceServer settings of client. Client identified by internal ID (not clientId).
+func (k *AuthorizedKeycloak) ResourceServer(ctx context.Context, realm string, clientID string) (*ResourceServer, error) {
+	var server ResourceServer
+	if _, err := k.call(ctx, http.MethodGet, k.authzURL(realm, clientID), nil, &server); err != nil {
+		return nil, err
+	}
+	return &server, nil
+}
+
+func (k *AuthorizedKeycloak) UpdateResourceServer(ctx context.Context, realm string, clientID string, server ResourceServer) error {
+	_, err := k.call(ctx, http.MethodPut, k.authzURL(realm, clientID), server, nil)
+	return err
+}
+
+func (k *AuthorizedKeycloak) AuthzScopes(ctx context.Context, realm string, clientID string) ([]AuthzScope, error) {
+	var list []AuthzScope
+	_, err := k.call(ctx, http.MethodGet, k.authzURL(realm, clientID, "scope")+"?"+authzPage.Encode(), nil, &list)
+	return list, err
+}
+
+// SaveAuthzScope creates new scope (if ID not set) or updates existent.
+func (k *AuthorizedKeycloak) SaveAuthzScope(ctx context.Context, realm string, clientID string, scope AuthzScope) error {
+	if scope.ID == "" {
+		_, err := k.call(ctx, http.MethodPost, k.authzURL(realm, clientID, "scope"), scope, nil)
+		return err
+	}
+	_, err := k.call(ctx, http.MethodPut, k.authzURL(realm, clientID, "scope", scope.ID), scope, nil)
+	return err
+}
+
+func (k *AuthorizedKeycloak) DeleteAuthzScope(ctx context.Context, realm string, clientID string, id string) error {
+	_, err := k.call(ctx, http.MethodDelete, k.authzURL(realm, clientID, "scope", id), nil, nil)
+	return err
+}
+
+func (k *AuthorizedKeycloak) AuthzResources(ctx context.Context, realm string, clientID string) ([]AuthzResource, error) {
+	var list []AuthzResource
+	_, err := k.call(ctx, http.MethodGet, k.authzURL(realm, clientID, "resource")+"?"+authzPage.Encode(), nil, &list)
+	return list, err
+}
+
+// SaveAuthzResource creates new resource (if ID not set) or updates existent.
+func (k *AuthorizedKeycloak) SaveAuthzResource(ctx context.Context, realm string, clientID string, resource AuthzResource) error {
+	if resource.ID == "" {
+		_, err := k.call(ctx, http.MethodPost, k.authzURL(realm, clientID, "resource"), resource, nil)
+		return err
+	}
+	_, err := k.call(ctx, http.MethodPut, k.authzURL(realm, clientID, "resource", resource.ID), resource, nil)
+	return err
+}
+
+func (k *AuthorizedKeycloak) DeleteAuthzResource(ctx context.Context, realm string, clientID string, id string) error {
+	_, err := k.call(ctx, http.MethodDelete, k.authzURL(realm, clientID, "resource", id), nil, nil)
+	return err
+}
+
+// AuthzPolicies returns policies (if permissions is false) or permissions.
+func (k *AuthorizedKeycloak) AuthzPolicies(ctx context.Context, realm string, clientID string, permissions bool) ([]AuthzPolicy, error) {
+	var list []AuthzPolicy
+	query := url.Values{"permission": []string{"false"}}
+	if permissions {
+		query.Set("permission", "true")
+	}
+	for k, v := range authzPage {
+		query[k] = v
+	}
+	_, err := k.call(ctx, http.MethodGet, k.authzURL(realm, clientID, "policy")+"?"+query.Encode(), nil, &list)
+	return list, err
+}
+
+// SaveAuthzPolicy creates new policy or permission (if ID not set) or updates existent.
+func (k *AuthorizedKeycloak) SaveAuthzPolicy(ctx context.Context, realm string, clientID string, policy AuthzPolicy) error {
+	if policy.ID == "" {
+		_, err := k.call(ctx, http.MethodPost, k.authzURL(realm, clientID, "policy", policy.Type), policy, nil)
+		return err
+	}
+	_, err := k.call(ctx, http.MethodPut, k.authzURL(realm, clientID, "policy", policy.Type, policy.ID), policy, nil)
+	return err
+}
+
+// DeleteAuthzPolicy removes policy or permission.
+func (k *AuthorizedKeycloak) DeleteAuthzPolicy(ctx context.Context, realm string, clientID string, id string) error {
+	_, err := k.call(ctx, http.MethodDelete, k.authzURL(realm, clientID, "policy", id), nil, nil)
+	return err
+}
+
+func (k *AuthorizedKeycloak) authzURL(realm string, clientID string, parts ...string) string {
+	return k.adminURL(realm, append([]string{"clients", clientID, "authz", "resource-server"}, parts...)...)
+}
@@ -0,0 +1,48 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"context"
+	"net/http"
+)
+
+type ClientScope struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Protocol string `json:"protocol,omitempty"`
+}
+
+// ClientScopes of realm.
+func (k *AuthorizedKeycloak) ClientScopes(ctx context.Context, realm string) ([]ClientScope, error) {
+	var list []ClientScope
+	_, err := k.call(ctx, http.MethodGet, k.adminURL(realm, "client-scopes"), nil, &list)
+	return list, err
+}
+
+// DefaultClientScopes assigned to client. Client identified by internal ID (not clientId).
+func (k *AuthorizedKeycloak) DefaultClientScopes(ctx context.Context, realm string, clientID string) ([]ClientScope, error) {
+	var list []ClientScope
+	_, err := k.call(ctx, http.MethodGet, k.adminURL(realm, "clients", clientID, "default-client-scopes"), nil, &list)
+	return list, err
+}
+
+// AddDefaultClientScope assigns realm client scope (by ID) to client as default scope.
+func (k *AuthorizedKeycloak) AddDefaultClientScope(ctx context.Context, realm string, clientID string, scopeID string) error {
+	_, err := k.call(ctx, http.MethodPut, k.adminURL(realm, "clients", clientID, "default-client-scopes", scopeID), nil, nil)
+	return err
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Keys of admin credentials in secret or names of files in directory.
+const (
+	CredentialsUserKey     = "KEYCLOAK_USER"
+	CredentialsPasswordKey = "KEYCLOAK_PASSWORD"
+)
+
+// CredentialsSource provides current admin credentials.
+type CredentialsSource interface {
+	Credentials(ctx context.Context) (user, password string, err error)
+}
+
+// FileCredentials reads credentials from KEYCLOAK_USER and KEYCLOAK_PASSWORD files in directory (ex: mounted secret),
+// so updated files are picked up without restart.
+type FileCredentials string
+
+func (dir FileCredentials) Credentials(context.Context) (string, string, error) {
+	user, err := os.ReadFile(filepath.Join(string(dir), CredentialsUserKey))
+	if err != nil {
+		return "", "", fmt.Errorf("read user: %w", err)
+	}
+	password, err := os.ReadFile(filepath.Join(string(dir), CredentialsPasswordKey))
+	if err != nil {
+		return "", "", fmt.Errorf("read password: %w", err)
+	}
+	return strings.TrimSpace(string(user)), strings.TrimSpace(string(password)), nil
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/reddec/keycloak-ext-operator/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileCredentials(t *testing.T) {
+	cases := []struct {
+		name     string
+		files    map[string]string
+		user     string
+		password string
+		invalid  bool
+	}{
+		{
+			name:     "mounted secret",
+			files:    map[string]string{internal.CredentialsUserKey: "admin\n", internal.CredentialsPasswordKey: " s3cr3t\n"},
+			user:     "admin",
+			password: "s3cr3t",
+		},
+		{
+			name:    "no password",
+			files:   map[string]string{internal.CredentialsUserKey: "admin"},
+			invalid: true,
+		},
+		{
+			name:    "no user",
+			files:   map[string]string{internal.CredentialsPasswordKey: "s3cr3t"},
+			invalid: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range c.files {
+				require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0600))
+			}
+			user, password, err := internal.FileCredentials(dir).Credentials(context.Background())
+			if c.invalid {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, c.user, user)
+			assert.Equal(t, c.password, password)
+		})
+	}
+}
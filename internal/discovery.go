@@ -0,0 +1,113 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DiscoveryTTL is how long discovery documents are cached.
+const DiscoveryTTL = 5 * time.Minute
+
+var ErrRealmNotFound = errors.New("realm not found")
+
+// Discovery is a subset of OpenID Connect discovery document of realm.
+type Discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+}
+
+type cachedDiscovery struct {
+	discovery *Discovery
+	expires   time.Time
+}
+
+var discoveryCache struct {
+	lock  sync.Mutex
+	items map[string]cachedDiscovery
+}
+
+// Rebase returns copy of discovery document where prefix (issuer) of all endpoints is replaced by base URL of realm.
+// Issuer itself is kept: it is what Keycloak puts into tokens.
+func (d Discovery) Rebase(base string) Discovery {
+	prefix := d.Issuer
+	rebase := func(endpoint string) string {
+		if prefix == "" || !strings.HasPrefix(endpoint, prefix) {
+			return endpoint
+		}
+		return base + strings.TrimPrefix(endpoint, prefix)
+	}
+	return Discovery{
+		Issuer:                d.Issuer,
+		AuthorizationEndpoint: rebase(d.AuthorizationEndpoint),
+		TokenEndpoint:         rebase(d.TokenEndpoint),
+		UserinfoEndpoint:      rebase(d.UserinfoEndpoint),
+		JWKSURI:               rebase(d.JWKSURI),
+		EndSessionEndpoint:    rebase(d.EndSessionEndpoint),
+	}
+}
+
+// Discovery returns OpenID Connect discovery document of realm, fetched by internal URL. Documents are cached for
+// DiscoveryTTL. Returns ErrRealmNotFound if realm does not exist.
+func (k *Keycloak) Discovery(ctx context.Context, realm string) (*Discovery, error) {
+	href := k.internalRealmURL(realm) + discoveryPath
+	discoveryCache.lock.Lock()
+	cached, ok := discoveryCache.items[href]
+	discoveryCache.lock.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.discovery, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, href, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	res, err := k.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("do request: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", ErrRealmNotFound, realm)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status: %d", res.StatusCode)
+	}
+	var discovery Discovery
+	if err := json.NewDecoder(res.Body).Decode(&discovery); err != nil {
+		return nil, fmt.Errorf("decode discovery: %w", err)
+	}
+
+	discoveryCache.lock.Lock()
+	defer discoveryCache.lock.Unlock()
+	if discoveryCache.items == nil {
+		discoveryCache.items = make(map[string]cachedDiscovery)
+	}
+	discoveryCache.items[href] = cachedDiscovery{discovery: &discovery, expires: time.Now().Add(DiscoveryTTL)}
+	return &discovery, nil
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/reddec/keycloak-ext-operator/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeycloak_Discovery(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch r.URL.Path {
+		case "/realms/test/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"issuer":                 "https://sso.example.com/realms/test",
+				"authorization_endpoint": "https://sso.example.com/realms/test/protocol/openid-connect/auth",
+				"token_endpoint":         "https://sso.example.com/realms/test/protocol/openid-connect/token",
+			})
+		case "/realms/broken/.well-known/openid-configuration":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	k := &internal.Keycloak{URL: srv.URL}
+
+	cases := []struct {
+		realm  string
+		issuer string
+		err    error
+	}{
+		{realm: "test", issuer: "https://sso.example.com/realms/test"},
+		{realm: "missing", err: internal.ErrRealmNotFound},
+		{realm: "broken"},
+	}
+	for _, c := range cases {
+		t.Run(c.realm, func(t *testing.T) {
+			discovery, err := k.Discovery(context.Background(), c.realm)
+			switch {
+			case c.err != nil:
+				assert.ErrorIs(t, err, c.err)
+			case c.issuer == "":
+				assert.Error(t, err)
+			default:
+				require.NoError(t, err)
+				assert.Equal(t, c.issuer, discovery.Issuer)
+				assert.Equal(t, c.issuer+"/protocol/openid-connect/token", discovery.TokenEndpoint)
+			}
+		})
+	}
+
+	t.Run("cached", func(t *testing.T) {
+		before := requests.Load()
+		discovery, err := k.Discovery(context.Background(), "test")
+		require.NoError(t, err)
+		assert.Equal(t, "https://sso.example.com/realms/test", discovery.Issuer)
+		assert.Equal(t, before, requests.Load())
+	})
+}
+
+func TestDiscovery_Rebase(t *testing.T) {
+	const internalIssuer = "http://keycloak.keycloak.svc:8080/realms/test"
+	const publicIssuer = "https://sso.example.com/realms/test"
+	cases := []struct {
+		name     string
+		source   internal.Discovery
+		base     string
+		expected internal.Discovery
+	}{
+		{
+			name: "all endpoints",
+			source: internal.Discovery{
+				Issuer:                internalIssuer,
+				AuthorizationEndpoint: internalIssuer + "/protocol/openid-connect/auth",
+				TokenEndpoint:         internalIssuer + "/protocol/openid-connect/token",
+				UserinfoEndpoint:      internalIssuer + "/protocol/openid-connect/userinfo",
+				JWKSURI:               internalIssuer + "/protocol/openid-connect/certs",
+				EndSessionEndpoint:    internalIssuer + "/protocol/openid-connect/logout",
+			},
+			base: publicIssuer,
+			expected: internal.Discovery{
+				Issuer:                internalIssuer,
+				AuthorizationEndpoint: publicIssuer + "/protocol/openid-connect/auth",
+				TokenEndpoint:         publicIssuer + "/protocol/openid-connect/token",
+				UserinfoEndpoint:      publicIssuer + "/protocol/openid-connect/userinfo",
+				JWKSURI:               publicIssuer + "/protocol/openid-connect/certs",
+				EndSessionEndpoint:    publicIssuer + "/protocol/openid-connect/logout",
+			},
+		},
+		{
+			name: "foreign and empty endpoints are kept",
+			source: internal.Discovery{
+				Issuer:                internalIssuer,
+				AuthorizationEndpoint: "https://other.example.com/auth",
+				TokenEndpoint:         internalIssuer + "/protocol/openid-connect/token",
+			},
+			base: publicIssuer,
+			expected: internal.Discovery{
+				Issuer:                internalIssuer,
+				AuthorizationEndpoint: "https://other.example.com/auth",
+				TokenEndpoint:         publicIssuer + "/protocol/openid-connect/token",
+			},
+		},
+		{
+			name: "no issuer",
+			source: internal.Discovery{
+				TokenEndpoint: internalIssuer + "/protocol/openid-connect/token",
+			},
+			base: publicIssuer,
+			expected: internal.Discovery{
+				TokenEndpoint: internalIssuer + "/protocol/openid-connect/token",
+			},
+		},
+		{
+			name: "same base",
+			source: internal.Discovery{
+				Issuer:        publicIssuer,
+				TokenEndpoint: publicIssuer + "/protocol/openid-connect/token",
+			},
+			base: publicIssuer,
+			expected: internal.Discovery{
+				Issuer:        publicIssuer,
+				TokenEndpoint: publicIssuer + "/protocol/openid-connect/token",
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			source := c.source
+			assert.Equal(t, c.expected, c.source.Rebase(c.base))
+			assert.Equal(t, source, c.source, "source is not modified")
+		})
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"context"
+	"net/http"
+)
+
+// IdentityProvider by alias. Returns ErrNotFound if provider not exists.
+func (k *AuthorizedKeycloak) IdentityProvider(ctx context.Context, realm string, alias string) (*IdentityProvider, error) {
+	var idp IdentityProvider
+	if _, err := k.call(ctx, http.MethodGet, k.adminURL(realm, "identity-provider", "instances", alias), nil, &idp); err != nil {
+		return nil, err
+	}
+	return &idp, nil
+}
+
+func (k *AuthorizedKeycloak) CreateIdentityProvider(ctx context.Context, realm string, idp IdentityProvider) error {
+	_, err := k.call(ctx, http.MethodPost, k.adminURL(realm, "identity-provider", "instances"), idp, nil)
+	return err
+}
+
+func (k *AuthorizedKeycloak) UpdateIdentityProvider(ctx context.Context, realm string, idp IdentityProvider) error {
+	_, err := k.call(ctx, http.MethodPut, k.adminURL(realm, "identity-provider", "instances", idp.Alias), idp, nil)
+	return err
+}
+
+func (k *AuthorizedKeycloak) DeleteIdentityProvider(ctx context.Context, realm string, alias string) error {
+	_, err := k.call(ctx, http.MethodDelete, k.adminURL(realm, "identity-provider", "instances", alias), nil, nil)
+	return err
+}
+
+func (k *AuthorizedKeycloak) IdentityProviderMappers(ctx context.Context, realm string, alias string) ([]IdentityProviderMapper, error) {
+	var list []IdentityProviderMapper
+	_, err := k.call(ctx, http.MethodGet, k.adminURL(realm, "identity-provider", "instances", alias, "mappers"), nil, &list)
+	return list, err
+}
+
+func (k *AuthorizedKeycloak) CreateIdentityProviderMapper(ctx context.Context, realm string, mapper IdentityProviderMapper) error {
+	_, err := k.call(ctx, http.MethodPost, k.adminURL(realm, "identity-provider", "instances", mapper.IdentityProviderAlias, "mappers"), mapper, nil)
+	return err
+}
+
+func (k *AuthorizedKeycloak) UpdateIdentityProviderMapper(ctx context.Context, realm string, mapper IdentityProviderMapper) error {
+	_, err := k.call(ctx, http.MethodPut, k.adminURL(realm, "identity-provider", "instances", mapper.IdentityProviderAlias, "mappers", mapper.ID), mapper, nil)
+	return err
+}
+
+func (k *AuthorizedKeycloak) DeleteIdentityProviderMapper(ctx context.Context, realm string, alias string, id string) error {
+	_, err := k.call(ctx, http.MethodDelete, k.adminURL(realm, "identity-provider", "instances", alias, "mappers", id), nil, nil)
+	return err
+}
@@ -0,0 +1,237 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"mime/multipart"
+	"net/http"
+	"time"
+)
+
+const (
+	AuthenticatorSecret = "client-secret"
+	AuthenticatorJWT    = "client-jwt"
+)
+
+// Client attributes used by Keycloak to verify JWT by keys from JWKS stored in client itself.
+const (
+	AttrUseJWKSString = "use.jwks.string"
+	AttrJWKSString    = "jwks.string"
+)
+
+var ErrInvalidCertificate = errors.New("invalid certificate")
+
+// ClientKey is a key pair for private-key JWT client authentication.
+type ClientKey struct {
+	PrivateKey  string // PEM encoded PKCS8 private key
+	Certificate string // PEM encoded self-signed certificate
+	KeyID       string // key ID, the same as Keycloak computes for registered certificate
+}
+
+// GenerateClientKey generates new key pair (RS256 - RSA 2048, ES256 - ECDSA P-256) and self-signed certificate
+// valid for 10 years.
+func GenerateClientKey(algorithm string, commonName string) (*ClientKey, error) {
+	var (
+		private crypto.Signer
+		err     error
+	)
+	switch algorithm {
+	case "RS256":
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("generate serial: %w", err)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now,
+		NotAfter:     now.AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, private.Public(), private)
+	if err != nil {
+		return nil, fmt.Errorf("create certificate: %w", err)
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, fmt.Errorf("marshal private key: %w", err)
+	}
+	kid, err := KeyID(private.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &ClientKey{
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})),
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})),
+		KeyID:       kid,
+	}, nil
+}
+
+// KeyID computes ID of public key in the same way as Keycloak: base64url (without padding) of SHA-256 of
+// DER encoded public key.
+func KeyID(public crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return "", fmt.Errorf("marshal public key: %w", err)
+	}
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// ParseCertificate parses PEM encoded certificate and returns it with the JWT algorithm (RS256 or ES256) of its key.
+func ParseCertificate(certPEM []byte) (*x509.Certificate, string, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, "", ErrInvalidCertificate
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, "", fmt.Errorf("parse certificate: %w", err)
+	}
+	switch cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return cert, "RS256", nil
+	case *ecdsa.PublicKey:
+		return cert, "ES256", nil
+	default:
+		return nil, "", ErrInvalidCertificate
+	}
+}
+
+// JWKS returns JSON Web Key Set with public keys of PEM encoded certificates. Keys are identified by KeyID.
+func JWKS(certificates ...[]byte) (string, error) {
+	type jwk struct {
+		KTY string   `json:"kty"`
+		KID string   `json:"kid"`
+		Use string   `json:"use"`
+		Alg string   `json:"alg"`
+		N   string   `json:"n,omitempty"`
+		E   string   `json:"e,omitempty"`
+		Crv string   `json:"crv,omitempty"`
+		X   string   `json:"x,omitempty"`
+		Y   string   `json:"y,omitempty"`
+		X5C []string `json:"x5c"`
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	for _, certPEM := range certificates {
+		cert, algorithm, err := ParseCertificate(certPEM)
+		if err != nil {
+			return "", err
+		}
+		kid, err := KeyID(cert.PublicKey)
+		if err != nil {
+			return "", err
+		}
+		key := jwk{KID: kid, Use: "sig", Alg: algorithm, X5C: []string{base64.StdEncoding.EncodeToString(cert.Raw)}}
+		switch public := cert.PublicKey.(type) {
+		case *rsa.PublicKey:
+			key.KTY = "RSA"
+			key.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			key.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case *ecdsa.PublicKey:
+			point, err := public.ECDH()
+			if err != nil {
+				return "", fmt.Errorf("convert key: %w", err)
+			}
+			coordinates := point.Bytes()[1:] // uncompressed point: 0x04 || X || Y
+			key.KTY = "EC"
+			key.Crv = "P-256"
+			key.X = base64.RawURLEncoding.EncodeToString(coordinates[:len(coordinates)/2])
+			key.Y = base64.RawURLEncoding.EncodeToString(coordinates[len(coordinates)/2:])
+		}
+		set.Keys = append(set.Keys, key)
+	}
+	data, err := json.Marshal(set)
+	return string(data), err
+}
+
+// ClientCertificate returns base64 encoded DER certificate registered for private-key JWT authentication
+// (empty if not registered). Client identified by internal ID (not clientId).
+func (k *AuthorizedKeycloak) ClientCertificate(ctx context.Context, realm string, clientID string) (string, error) {
+	var info struct {
+		Certificate string `json:"certificate"`
+	}
+	_, err := k.call(ctx, http.MethodGet, k.adminURL(realm, "clients", clientID, "certificates", "jwt.credential"), nil, &info)
+	return info.Certificate, err
+}
+
+// UploadClientCertificate registers PEM encoded certificate for private-key JWT authentication.
+// Client identified by internal ID (not clientId).
+func (k *AuthorizedKeycloak) UploadClientCertificate(ctx context.Context, realm string, clientID string, certPEM string) error {
+	if k.err != nil {
+		return k.err
+	}
+	var data bytes.Buffer
+	form := multipart.NewWriter(&data)
+	if err := form.WriteField("keystoreFormat", "Certificate PEM"); err != nil {
+		return fmt.Errorf("write format: %w", err)
+	}
+	file, err := form.CreateFormFile("file", "certificate.pem")
+	if err != nil {
+		return fmt.Errorf("create file: %w", err)
+	}
+	if _, err := file.Write([]byte(certPEM)); err != nil {
+		return fmt.Errorf("write file: %w", err)
+	}
+	if err := form.Close(); err != nil {
+		return fmt.Errorf("close form: %w", err)
+	}
+
+	href := k.adminURL(realm, "clients", clientID, "certificates", "jwt.credential", "upload-certificate")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, href, &data)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	res, err := k.do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("status: %d", res.StatusCode)
+	}
+	return nil
+}
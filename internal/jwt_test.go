@@ -0,0 +1,146 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal_test
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"testing"
+
+	"github.com/reddec/keycloak-ext-operator/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateClientKey(t *testing.T) {
+	cases := []struct {
+		algorithm string
+		invalid   bool
+	}{
+		{algorithm: "RS256"},
+		{algorithm: "ES256"},
+		{algorithm: "HS256", invalid: true},
+		{algorithm: "", invalid: true},
+	}
+	for _, c := range cases {
+		t.Run(c.algorithm, func(t *testing.T) {
+			key, err := internal.GenerateClientKey(c.algorithm, "app.example.com")
+			if c.invalid {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			cert, algorithm, err := internal.ParseCertificate([]byte(key.Certificate))
+			require.NoError(t, err)
+			assert.Equal(t, c.algorithm, algorithm)
+			assert.Equal(t, "app.example.com", cert.Subject.CommonName)
+			assert.True(t, cert.NotAfter.After(cert.NotBefore.AddDate(9, 0, 0)))
+
+			block, _ := pem.Decode([]byte(key.PrivateKey))
+			require.NotNil(t, block)
+			assert.Equal(t, "PRIVATE KEY", block.Type)
+			private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			require.NoError(t, err)
+			public := private.(crypto.Signer).Public()
+			assert.True(t, public.(interface{ Equal(crypto.PublicKey) bool }).Equal(cert.PublicKey), "certificate matches private key")
+
+			kid, err := internal.KeyID(cert.PublicKey)
+			require.NoError(t, err)
+			assert.Equal(t, kid, key.KeyID)
+		})
+	}
+}
+
+func TestKeyID(t *testing.T) {
+	first, err := internal.GenerateClientKey("ES256", "first")
+	require.NoError(t, err)
+	second, err := internal.GenerateClientKey("ES256", "second")
+	require.NoError(t, err)
+
+	cases := []struct {
+		name  string
+		key   *internal.ClientKey
+		other *internal.ClientKey
+		same  bool
+	}{
+		{name: "same key", key: first, other: first, same: true},
+		{name: "other key", key: first, other: second},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a, _, err := internal.ParseCertificate([]byte(c.key.Certificate))
+			require.NoError(t, err)
+			b, _, err := internal.ParseCertificate([]byte(c.other.Certificate))
+			require.NoError(t, err)
+			kidA, err := internal.KeyID(a.PublicKey)
+			require.NoError(t, err)
+			kidB, err := internal.KeyID(b.PublicKey)
+			require.NoError(t, err)
+			assert.Len(t, kidA, 43, "base64url of SHA-256 without padding")
+			assert.Equal(t, c.same, kidA == kidB)
+		})
+	}
+
+	t.Run("unsupported key", func(t *testing.T) {
+		_, err := internal.KeyID("not a key")
+		assert.Error(t, err)
+	})
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKey, err := internal.GenerateClientKey("RS256", "current")
+	require.NoError(t, err)
+	ecKey, err := internal.GenerateClientKey("ES256", "previous")
+	require.NoError(t, err)
+
+	jwks, err := internal.JWKS([]byte(rsaKey.Certificate), []byte(ecKey.Certificate))
+	require.NoError(t, err)
+	var set struct {
+		Keys []map[string]any `json:"keys"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(jwks), &set))
+	require.Len(t, set.Keys, 2)
+
+	cases := []struct {
+		key    map[string]any
+		kid    string
+		kty    string
+		alg    string
+		fields []string
+	}{
+		{key: set.Keys[0], kid: rsaKey.KeyID, kty: "RSA", alg: "RS256", fields: []string{"n", "e"}},
+		{key: set.Keys[1], kid: ecKey.KeyID, kty: "EC", alg: "ES256", fields: []string{"crv", "x", "y"}},
+	}
+	for _, c := range cases {
+		t.Run(c.kty, func(t *testing.T) {
+			assert.Equal(t, c.kid, c.key["kid"])
+			assert.Equal(t, c.kty, c.key["kty"])
+			assert.Equal(t, c.alg, c.key["alg"])
+			assert.Equal(t, "sig", c.key["use"])
+			for _, field := range c.fields {
+				assert.NotEmpty(t, c.key[field], field)
+			}
+		})
+	}
+	assert.Len(t, set.Keys[1]["x"], 43, "P-256 coordinate is 32 bytes")
+
+	_, err = internal.JWKS([]byte("not a certificate"))
+	assert.ErrorIs(t, err, internal.ErrInvalidCertificate)
+}
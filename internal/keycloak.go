@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -29,29 +30,66 @@ import (
 	"net/url"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 )
 
 func FromEnv() (*Keycloak, error) {
 	var cfg Keycloak
-	return &cfg, envconfig.Process("KEYCLOAK", &cfg)
+	if err := envconfig.Process("KEYCLOAK", &cfg); err != nil {
+		return nil, err
+	}
+	return NewKeycloak(cfg)
 }
 
 type Keycloak struct {
 	URL      string `required:"true" envconfig:"URL"`
-	User     string `required:"true" envconfig:"USER"`
-	Password string `required:"true" envconfig:"PASSWORD"`
+	User     string `envconfig:"USER"`
+	Password string `envconfig:"PASSWORD"`
+	// PublicURL (optional) is a URL of Keycloak reachable by users' browsers and applications. Default is URL.
+	PublicURL string `envconfig:"PUBLIC_URL"`
+	// RealmPublicURLs (optional) overrides public URL per realm (realm:url,realm2:url2).
+	RealmPublicURLs map[string]string `envconfig:"REALM_PUBLIC_URLS"`
+	// AuthMethod (optional) is one of: password (admin user, default) or clientCredentials (User and Password are
+	// client ID and client secret of service account).
+	AuthMethod string `envconfig:"AUTH_METHOD"`
+	// AuthRealm (optional) used for authorization. Default is master.
+	AuthRealm string `envconfig:"AUTH_REALM"`
+	// InsecureSkipVerify (optional) disables TLS verification.
+	InsecureSkipVerify bool `envconfig:"INSECURE_SKIP_VERIFY"`
+	// CACertificate (optional) is a PEM-encoded CA bundle to verify Keycloak.
+	CACertificate string `envconfig:"CA_CERTIFICATE"`
+
+	session *session
 }
 
+// RealmURL is a public URL of realm.
 func (k *Keycloak) RealmURL(realm string) string {
-	return strings.TrimRight(k.URL, "/") + `/realms/` + url.PathEscape(realm)
+	return strings.TrimRight(k.publicURL(realm), "/") + `/realms/` + url.PathEscape(realm)
 }
 
+// DiscoveryURL is a public URL of OpenID Connect discovery document of realm.
 func (k *Keycloak) DiscoveryURL(realm string) string {
-	return k.RealmURL(realm) + "/.well-known/openid-configuration"
+	return k.RealmURL(realm) + discoveryPath
+}
+
+func (k *Keycloak) publicURL(realm string) string {
+	if u := k.RealmPublicURLs[realm]; u != "" {
+		return u
+	}
+	if k.PublicURL != "" {
+		return k.PublicURL
+	}
+	return k.URL
 }
 
+func (k *Keycloak) internalRealmURL(realm string) string {
+	return strings.TrimRight(k.URL, "/") + `/realms/` + url.PathEscape(realm)
+}
+
+const discoveryPath = "/.well-known/openid-configuration"
+
 type ClientDraft struct {
 	ClientID     string   `json:"clientId,omitempty"`
 	ClientSecret string   `json:"secret,omitempty"`
@@ -62,15 +100,19 @@ type ClientDraft struct {
 	Name         string   `json:"name,omitempty"`
 	ID           string   `json:"id,omitempty"`
 	Description  string   `json:"description,omitempty"`
+	Enabled      *bool    `json:"enabled,omitempty"`
+
+	Protocol                     string            `json:"protocol,omitempty"`
+	ClientAuthenticatorType      string            `json:"clientAuthenticatorType,omitempty"`
+	Attributes                   map[string]string `json:"attributes,omitempty"`
+	FrontChannelLogout           *bool             `json:"frontchannelLogout,omitempty"`
+	ServiceAccountsEnabled       *bool             `json:"serviceAccountsEnabled,omitempty"`
+	AuthorizationServicesEnabled *bool             `json:"authorizationServicesEnabled,omitempty"`
+	PublicClient                 *bool             `json:"publicClient,omitempty"`
 }
 
 func Generate(domain string) ClientDraft {
-	var key [32]byte
-	_, err := io.ReadFull(rand.Reader, key[:])
-	if err != nil {
-		panic(err)
-	}
-	secret := hex.EncodeToString(key[:])
+	secret := randomHex(32)
 	clientURL := "https://" + domain
 	return ClientDraft{
 		ClientID:     domain,
@@ -83,10 +125,49 @@ func Generate(domain string) ClientDraft {
 		WebOrigins: []string{
 			clientURL,
 		},
-		Name: domain,
+		Name:     domain,
+		Protocol: ProtocolOIDC,
+	}
+}
+
+// GeneratePassword returns crypto random password: 32 bytes represented as 64-bytes hex.
+func GeneratePassword() string {
+	return randomHex(32)
+}
+
+// GenerateRandom returns crypto random bytes (size) in encoding: hex (default), base64 or base64url.
+func GenerateRandom(size int, encoding string) string {
+	key := randomBytes(size)
+	switch encoding {
+	case EncodingBase64:
+		return base64.StdEncoding.EncodeToString(key)
+	case EncodingBase64URL:
+		return base64.URLEncoding.EncodeToString(key)
+	default:
+		return hex.EncodeToString(key)
 	}
 }
 
+// Encodings of random values.
+const (
+	EncodingHex       = "hex"
+	EncodingBase64    = "base64"
+	EncodingBase64URL = "base64url"
+)
+
+func randomHex(size int) string {
+	return hex.EncodeToString(randomBytes(size))
+}
+
+func randomBytes(size int) []byte {
+	var key = make([]byte, size)
+	_, err := io.ReadFull(rand.Reader, key)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
 type AuthorizedKeycloak struct {
 	config Keycloak
 	token  string
@@ -98,145 +179,75 @@ func (k *AuthorizedKeycloak) Error() error {
 }
 
 func (k *AuthorizedKeycloak) Delete(ctx context.Context, realm, id string) error {
-	if k.err != nil {
-		return k.err
-	}
-	href := strings.TrimRight(k.config.URL, "/") + `/admin/realms/` + url.PathEscape(realm) + `/clients/` + url.PathEscape(id)
-	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, href, nil)
-	if err != nil {
-		return fmt.Errorf("create request: %w", err)
+	_, err := k.call(ctx, http.MethodDelete, k.adminURL(realm, "clients", id), nil, nil)
+	if errors.Is(err, ErrNotFound) {
+		return ErrClientNotFound
 	}
-	req.Header.Set("Authorization", k.token)
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("do request: %w", err)
-	}
-	defer res.Body.Close()
-	if res.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("status: %d", res.StatusCode)
-	}
-	return nil
+	return err
 }
 
 func (k *AuthorizedKeycloak) Update(ctx context.Context, id string, realm string, draft ClientDraft) error {
-	if k.err != nil {
-		return k.err
-	}
-	var data bytes.Buffer
-	if err := json.NewEncoder(&data).Encode(draft); err != nil {
-		return fmt.Errorf("encode payload, %w", err)
-	}
-	href := strings.TrimRight(k.config.URL, "/") + `/admin/realms/` + url.PathEscape(realm) + `/clients/` + url.PathEscape(id)
-	req, err := http.NewRequestWithContext(ctx, http.MethodPut, href, &data)
-	if err != nil {
-		return fmt.Errorf("create request: %w", err)
-	}
-	req.Header.Set("Authorization", k.token)
-	req.Header.Set("Content-Type", "application/json")
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("do request: %w", err)
-	}
-	defer res.Body.Close()
-	if res.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("status: %d", res.StatusCode)
-	}
-	return nil
+	_, err := k.call(ctx, http.MethodPut, k.adminURL(realm, "clients", id), draft, nil)
+	return err
 }
 
 // Create new client and return ID.
 func (k *AuthorizedKeycloak) Create(ctx context.Context, realm string, draft ClientDraft) (string, error) {
-	if k.err != nil {
-		return "", k.err
-	}
-	var data bytes.Buffer
-	if err := json.NewEncoder(&data).Encode(draft); err != nil {
-		return "", fmt.Errorf("encode payload, %w", err)
-	}
-	href := strings.TrimRight(k.config.URL, "/") + `/admin/realms/` + url.PathEscape(realm) + `/clients`
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, href, &data)
+	header, err := k.call(ctx, http.MethodPost, k.adminURL(realm, "clients"), draft, nil)
 	if err != nil {
-		return "", fmt.Errorf("create request: %w", err)
-	}
-	req.Header.Set("Authorization", k.token)
-	req.Header.Set("Content-Type", "application/json")
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("do request: %w", err)
-	}
-	defer res.Body.Close()
-	if res.StatusCode != http.StatusCreated {
-		return "", fmt.Errorf("status: %d", res.StatusCode)
+		return "", err
 	}
-	id := path.Base(res.Header.Get("Location"))
-	return id, nil
+	return path.Base(header.Get("Location")), nil
 }
 
+// Clients in realm. Returns ErrNotFound if realm not exists.
 func (k *AuthorizedKeycloak) Clients(ctx context.Context, realm string) *Clients {
-	if k.err != nil {
-		return &Clients{err: k.err}
-	}
-	href := strings.TrimRight(k.config.URL, "/") + `/admin/realms/` + url.PathEscape(realm) + `/clients`
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, href, nil)
-	if err != nil {
-		return &Clients{err: fmt.Errorf("create request: %w", err)}
-	}
-	req.Header.Set("Authorization", k.token)
-
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return &Clients{err: fmt.Errorf("do request: %w", err)}
-	}
-	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		return &Clients{err: fmt.Errorf("status: %d", res.StatusCode)}
-	}
-
 	var ans []Client
-	return &Clients{list: ans, err: json.NewDecoder(res.Body).Decode(&ans)}
+	_, err := k.call(ctx, http.MethodGet, k.adminURL(realm, "clients"), nil, &ans)
+	return &Clients{list: ans, err: err}
 }
 
 func (k *AuthorizedKeycloak) Get(ctx context.Context, realm string, id string) (*ClientDetails, error) {
-	if k.err != nil {
-		return nil, k.err
-	}
-	href := strings.TrimRight(k.config.URL, "/") + `/admin/realms/` + url.PathEscape(realm) + `/clients/` + url.PathEscape(id)
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, href, nil)
-	if err != nil {
-		return nil, fmt.Errorf("create request: %w", err)
-	}
-	req.Header.Set("Authorization", k.token)
-
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("do request: %w", err)
-	}
-	defer res.Body.Close()
-	if res.StatusCode == http.StatusNotFound {
+	var ans ClientDetails
+	_, err := k.call(ctx, http.MethodGet, k.adminURL(realm, "clients", id), nil, &ans)
+	if errors.Is(err, ErrNotFound) {
 		return nil, ErrClientNotFound
 	}
-	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("status: %d", res.StatusCode)
+	if err != nil {
+		return nil, err
 	}
-	var ans ClientDetails
-	return &ans, json.NewDecoder(res.Body).Decode(&ans)
+	return &ans, nil
 }
 
 func (k *Keycloak) Authorize(ctx context.Context) *AuthorizedKeycloak {
+	if token, ok := k.session.cached(); ok {
+		return &AuthorizedKeycloak{config: *k, token: token}
+	}
 	var form = url.Values{
 		"grant_type": []string{"password"},
 		"client_id":  []string{"admin-cli"},
 		"username":   []string{k.User},
 		"password":   []string{k.Password},
 	}
-	href := strings.TrimRight(k.URL, "/") + `/realms/master/protocol/openid-connect/token`
+	if k.AuthMethod == AuthClientCredentials {
+		form = url.Values{
+			"grant_type":    []string{"client_credentials"},
+			"client_id":     []string{k.User},
+			"client_secret": []string{k.Password},
+		}
+	}
+	authRealm := k.AuthRealm
+	if authRealm == "" {
+		authRealm = "master"
+	}
+	href := strings.TrimRight(k.URL, "/") + `/realms/` + url.PathEscape(authRealm) + `/protocol/openid-connect/token`
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, href, strings.NewReader(form.Encode()))
 	if err != nil {
 		return &AuthorizedKeycloak{err: fmt.Errorf("create request: %w", err)}
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := k.httpClient().Do(req)
 	if err != nil {
 		return &AuthorizedKeycloak{err: fmt.Errorf("do request: %w", err)}
 	}
@@ -248,18 +259,88 @@ func (k *Keycloak) Authorize(ctx context.Context) *AuthorizedKeycloak {
 	var token struct {
 		TokenType   string `json:"token_type"`
 		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
 	}
 
 	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
 		return &AuthorizedKeycloak{err: fmt.Errorf("decode result: %w", err)}
 	}
+	value := token.TokenType + " " + token.AccessToken
+	k.session.save(value, time.Duration(token.ExpiresIn)*time.Second)
 	return &AuthorizedKeycloak{
 		config: *k,
-		token:  token.TokenType + " " + token.AccessToken,
+		token:  value,
 	}
 }
 
-var ErrClientNotFound = errors.New("client not found")
+var (
+	ErrClientNotFound = errors.New("client not found")
+	ErrNotFound       = errors.New("object not found")
+)
+
+// adminURL returns URL to the realm admin API. Each part is path-escaped.
+func (k *AuthorizedKeycloak) adminURL(realm string, parts ...string) string {
+	href := strings.TrimRight(k.config.URL, "/") + `/admin/realms/` + url.PathEscape(realm)
+	for _, p := range parts {
+		href += "/" + url.PathEscape(p)
+	}
+	return href
+}
+
+// do executes authorized request. Cached session is dropped if token is rejected, so the next authorization
+// re-authenticates.
+func (k *AuthorizedKeycloak) do(req *http.Request) (*http.Response, error) {
+	req.Header.Set("Authorization", k.token)
+	res, err := k.config.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("do request: %w", err)
+	}
+	if res.StatusCode == http.StatusUnauthorized {
+		// token revoked or credentials changed
+		k.config.session.reset()
+	}
+	return res, nil
+}
+
+// call executes request to admin API with optional JSON payload and decodes JSON response to out (if not nil).
+// Any 2xx status is treated as success, 404 is reported as ErrNotFound.
+func (k *AuthorizedKeycloak) call(ctx context.Context, method string, href string, payload any, out any) (http.Header, error) {
+	if k.err != nil {
+		return nil, k.err
+	}
+	var body io.Reader
+	if payload != nil {
+		var data bytes.Buffer
+		if err := json.NewEncoder(&data).Encode(payload); err != nil {
+			return nil, fmt.Errorf("encode payload, %w", err)
+		}
+		body = &data
+	}
+	req, err := http.NewRequestWithContext(ctx, method, href, body)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := k.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, fmt.Errorf("status: %d", res.StatusCode)
+	}
+	if out != nil {
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			return nil, fmt.Errorf("decode result: %w", err)
+		}
+	}
+	return res.Header, nil
+}
 
 type Clients struct {
 	list []Client
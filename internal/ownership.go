@@ -0,0 +1,117 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"context"
+)
+
+// Attributes with ownership markers of clients (and other objects) managed by operator.
+const (
+	AttrOwnerCluster   = "k8s.reddec.net.owner.cluster"
+	AttrOwnerNamespace = "k8s.reddec.net.owner.namespace"
+	AttrOwnerName      = "k8s.reddec.net.owner.name"
+	AttrOwnerUID       = "k8s.reddec.net.owner.uid"
+)
+
+// Owner of client: resource in cluster.
+type Owner struct {
+	Cluster   string
+	Namespace string
+	Name      string
+	UID       string
+}
+
+// Is returns true if owners refer to the same resource in the same cluster.
+func (o Owner) Is(other Owner) bool {
+	return o.Cluster == other.Cluster && o.UID == other.UID
+}
+
+func (o Owner) String() string {
+	name := o.Namespace + "/" + o.Name
+	if o.Cluster != "" {
+		name = o.Cluster + ":" + name
+	}
+	return name
+}
+
+// Attributes of client with ownership markers.
+func (o Owner) Attributes() map[string]string {
+	return map[string]string{
+		AttrOwnerCluster:   o.Cluster,
+		AttrOwnerNamespace: o.Namespace,
+		AttrOwnerName:      o.Name,
+		AttrOwnerUID:       o.UID,
+	}
+}
+
+// UserAttributes are ownership markers in form of user attributes (multi-valued).
+func (o Owner) UserAttributes() map[string][]string {
+	var ans = make(map[string][]string, 4)
+	for k, v := range o.Attributes() {
+		ans[k] = []string{v}
+	}
+	return ans
+}
+
+// Owner of client by ownership markers. Returns false if client has no markers (not managed by operator).
+func (c *Client) Owner() (Owner, bool) {
+	return ownerOf(c.Attributes)
+}
+
+// Owner of user by ownership markers in attributes. Returns false if user has no markers (not managed by operator).
+func (u *User) Owner() (Owner, bool) {
+	var attrs = make(map[string]string, len(u.Attributes))
+	for k, v := range u.Attributes {
+		if len(v) > 0 {
+			attrs[k] = v[0]
+		}
+	}
+	return ownerOf(attrs)
+}
+
+// Owner of identity provider by ownership markers in config. Returns false if provider has no markers (not managed by
+// operator).
+func (p *IdentityProvider) Owner() (Owner, bool) {
+	return ownerOf(p.Config)
+}
+
+func ownerOf(attrs map[string]string) (Owner, bool) {
+	uid := attrs[AttrOwnerUID]
+	if uid == "" {
+		return Owner{}, false
+	}
+	return Owner{
+		Cluster:   attrs[AttrOwnerCluster],
+		Namespace: attrs[AttrOwnerNamespace],
+		Name:      attrs[AttrOwnerName],
+		UID:       uid,
+	}, true
+}
+
+// ReleaseClient removes ownership markers from client (attributes can not be removed by update, so they are emptied),
+// and optionally disables client. Client identified by internal ID (not clientId).
+func (k *AuthorizedKeycloak) ReleaseClient(ctx context.Context, realm string, id string, disable bool) error {
+	draft := ClientDraft{
+		Attributes: Owner{}.Attributes(),
+	}
+	if disable {
+		enabled := false
+		draft.Enabled = &enabled
+	}
+	return k.Update(ctx, id, realm, draft)
+}
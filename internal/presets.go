@@ -0,0 +1,149 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"strings"
+)
+
+// Preset is a recipe for well-known consumer of client: callback paths, protocol mappers, scopes and keys of the
+// generated secret.
+type Preset struct {
+	// RedirectPaths relative to client URL (https://<domain>).
+	RedirectPaths []string
+	// Scopes which should be assigned to client as default scopes.
+	Scopes []string
+	// Groups adds mapper of user groups to "groups" claim.
+	Groups bool
+	// Audience adds mapper of client ID to "aud" claim.
+	Audience bool
+	// SecretTemplate defines additional keys of generated secret (as Go text/template).
+	SecretTemplate map[string]string
+	// RandomValues defines random values (key: encoding) of 32 bytes in generated secret.
+	RandomValues map[string]string
+}
+
+var presets = map[string]Preset{
+	"oauth2-proxy": {
+		RedirectPaths: []string{"/oauth2/callback"},
+		Scopes:        []string{"email", "profile"},
+		Groups:        true,
+		Audience:      true,
+		SecretTemplate: map[string]string{
+			"OAUTH2_PROXY_PROVIDER":        "keycloak-oidc",
+			"OAUTH2_PROXY_CLIENT_ID":       "{{ .ClientID }}",
+			"OAUTH2_PROXY_CLIENT_SECRET":   "{{ .ClientSecret }}",
+			"OAUTH2_PROXY_OIDC_ISSUER_URL": "{{ .Data.issuer }}",
+			"OAUTH2_PROXY_REDIRECT_URL":    "https://{{ .Domain }}/oauth2/callback",
+			"OAUTH2_PROXY_SCOPE":           "openid email profile",
+		},
+		RandomValues: map[string]string{
+			"OAUTH2_PROXY_COOKIE_SECRET": EncodingBase64URL,
+		},
+	},
+	"grafana": {
+		RedirectPaths: []string{"/login/generic_oauth"},
+		Scopes:        []string{"email", "profile", "roles"},
+		Groups:        true,
+		SecretTemplate: map[string]string{
+			"GF_AUTH_GENERIC_OAUTH_ENABLED":       "true",
+			"GF_AUTH_GENERIC_OAUTH_NAME":          "Keycloak",
+			"GF_AUTH_GENERIC_OAUTH_CLIENT_ID":     "{{ .ClientID }}",
+			"GF_AUTH_GENERIC_OAUTH_CLIENT_SECRET": "{{ .ClientSecret }}",
+			"GF_AUTH_GENERIC_OAUTH_SCOPES":        "openid email profile roles",
+			"GF_AUTH_GENERIC_OAUTH_AUTH_URL":      "{{ .Data.authorizationEndpoint }}",
+			"GF_AUTH_GENERIC_OAUTH_TOKEN_URL":     "{{ .Data.tokenEndpoint }}",
+			"GF_AUTH_GENERIC_OAUTH_API_URL":       "{{ .Data.userinfoEndpoint }}",
+			"GF_AUTH_SIGNOUT_REDIRECT_URL":        "{{ .Data.endSessionEndpoint }}",
+			"GF_SERVER_ROOT_URL":                  "https://{{ .Domain }}",
+		},
+	},
+	"argocd": {
+		RedirectPaths: []string{"/auth/callback", "/pkce/verify"},
+		Scopes:        []string{"email", "profile"},
+		Groups:        true,
+		// Argo CD reads oidc.config from argocd-cm ConfigMap, and the client secret is referenced from this secret
+		// ($<secret>:<key> form), so the config itself has no credentials.
+		SecretTemplate: map[string]string{
+			"oidc.config": "name: Keycloak\n" +
+				"issuer: {{ .Data.issuer }}\n" +
+				"clientID: {{ .ClientID }}\n" +
+				"clientSecret: ${{ .SecretName }}:clientSecret\n" +
+				"requestedScopes: [\"openid\", \"profile\", \"email\", \"groups\"]\n",
+		},
+	},
+	"gitea": {
+		RedirectPaths: []string{"/user/oauth2/keycloak/callback"},
+		Scopes:        []string{"email", "profile"},
+		Groups:        true,
+		SecretTemplate: map[string]string{
+			"key":             "{{ .ClientID }}",
+			"secret":          "{{ .ClientSecret }}",
+			"autoDiscoverUrl": "{{ .DiscoveryURL }}",
+		},
+	},
+}
+
+// LookupPreset returns preset by name.
+func LookupPreset(name string) (Preset, bool) {
+	p, ok := presets[name]
+	return p, ok
+}
+
+// Apply preset to OIDC client draft: redirect URIs are replaced by preset callbacks.
+func (p Preset) Apply(draft *ClientDraft) {
+	if len(p.RedirectPaths) == 0 {
+		return
+	}
+	root := strings.TrimRight(draft.RootURL, "/")
+	draft.RedirectURIs = make([]string, 0, len(p.RedirectPaths))
+	for _, path := range p.RedirectPaths {
+		draft.RedirectURIs = append(draft.RedirectURIs, root+path)
+	}
+}
+
+// ProtocolMappers required by preset.
+func (p Preset) ProtocolMappers(clientID string) []ProtocolMapper {
+	var ans []ProtocolMapper
+	if p.Groups {
+		ans = append(ans, ProtocolMapper{
+			Name:           "groups",
+			Protocol:       ProtocolOIDC,
+			ProtocolMapper: "oidc-group-membership-mapper",
+			Config: map[string]string{
+				"claim.name":           "groups",
+				"full.path":            "false",
+				"id.token.claim":       "true",
+				"access.token.claim":   "true",
+				"userinfo.token.claim": "true",
+			},
+		})
+	}
+	if p.Audience {
+		ans = append(ans, ProtocolMapper{
+			Name:           "audience",
+			Protocol:       ProtocolOIDC,
+			ProtocolMapper: "oidc-audience-mapper",
+			Config: map[string]string{
+				"included.client.audience": clientID,
+				"id.token.claim":           "false",
+				"access.token.claim":       "true",
+			},
+		})
+	}
+	return ans
+}
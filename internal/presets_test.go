@@ -0,0 +1,90 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal_test
+
+import (
+	"testing"
+
+	"github.com/reddec/keycloak-ext-operator/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreset_Apply(t *testing.T) {
+	cases := []struct {
+		preset    string
+		redirects []string
+		mappers   []string
+	}{
+		{
+			preset:    "oauth2-proxy",
+			redirects: []string{"https://app.example.com/oauth2/callback"},
+			mappers:   []string{"groups", "audience"},
+		},
+		{
+			preset:    "grafana",
+			redirects: []string{"https://app.example.com/login/generic_oauth"},
+			mappers:   []string{"groups"},
+		},
+		{
+			preset:    "argocd",
+			redirects: []string{"https://app.example.com/auth/callback", "https://app.example.com/pkce/verify"},
+			mappers:   []string{"groups"},
+		},
+		{
+			preset:    "gitea",
+			redirects: []string{"https://app.example.com/user/oauth2/keycloak/callback"},
+			mappers:   []string{"groups"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.preset, func(t *testing.T) {
+			preset, ok := internal.LookupPreset(c.preset)
+			require.True(t, ok)
+			draft := internal.Generate("app.example.com")
+			preset.Apply(&draft)
+			assert.Equal(t, c.redirects, draft.RedirectURIs)
+			assert.Equal(t, []string{"https://app.example.com"}, draft.WebOrigins, "web origins are not changed")
+
+			var mappers []string
+			for _, m := range preset.ProtocolMappers("app") {
+				assert.Equal(t, internal.ProtocolOIDC, m.Protocol)
+				mappers = append(mappers, m.Name)
+			}
+			assert.Equal(t, c.mappers, mappers)
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		_, ok := internal.LookupPreset("unknown")
+		assert.False(t, ok)
+		_, ok = internal.LookupPreset("")
+		assert.False(t, ok)
+	})
+}
+
+func TestPreset_ProtocolMappers_audience(t *testing.T) {
+	preset, ok := internal.LookupPreset("oauth2-proxy")
+	require.True(t, ok)
+	for _, m := range preset.ProtocolMappers("app-client") {
+		if m.Name == "audience" {
+			assert.Equal(t, "app-client", m.Config["included.client.audience"])
+			return
+		}
+	}
+	t.Fatal("audience mapper not found")
+}
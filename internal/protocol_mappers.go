@@ -0,0 +1,44 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"context"
+	"net/http"
+)
+
+// ProtocolMappers of client. Client identified by internal ID (not clientId).
+func (k *AuthorizedKeycloak) ProtocolMappers(ctx context.Context, realm string, clientID string) ([]ProtocolMapper, error) {
+	var list []ProtocolMapper
+	_, err := k.call(ctx, http.MethodGet, k.adminURL(realm, "clients", clientID, "protocol-mappers", "models"), nil, &list)
+	return list, err
+}
+
+func (k *AuthorizedKeycloak) CreateProtocolMapper(ctx context.Context, realm string, clientID string, mapper ProtocolMapper) error {
+	_, err := k.call(ctx, http.MethodPost, k.adminURL(realm, "clients", clientID, "protocol-mappers", "models"), mapper, nil)
+	return err
+}
+
+func (k *AuthorizedKeycloak) UpdateProtocolMapper(ctx context.Context, realm string, clientID string, mapper ProtocolMapper) error {
+	_, err := k.call(ctx, http.MethodPut, k.adminURL(realm, "clients", clientID, "protocol-mappers", "models", mapper.ID), mapper, nil)
+	return err
+}
+
+func (k *AuthorizedKeycloak) DeleteProtocolMapper(ctx context.Context, realm string, clientID string, id string) error {
+	_, err := k.call(ctx, http.MethodDelete, k.adminURL(realm, "clients", clientID, "protocol-mappers", "models", id), nil, nil)
+	return err
+}
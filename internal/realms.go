@@ -0,0 +1,36 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+type Realm struct {
+	ID      string `json:"id"`
+	Realm   string `json:"realm"`
+	Enabled bool   `json:"enabled"`
+}
+
+// Realms visible to the authorized user.
+func (k *AuthorizedKeycloak) Realms(ctx context.Context) ([]Realm, error) {
+	var list []Realm
+	_, err := k.call(ctx, http.MethodGet, strings.TrimRight(k.config.URL, "/")+"/admin/realms", nil, &list)
+	return list, err
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	ProtocolOIDC = "openid-connect"
+	ProtocolSAML = "saml"
+)
+
+var ErrNoCertificate = errors.New("no active signing certificate")
+
+type SAMLOptions struct {
+	EntityID                string
+	ACSURLs                 []string
+	NameIDFormat            string
+	SignDocuments           bool
+	SignAssertions          bool
+	ClientSignatureRequired bool
+	EncryptAssertions       bool
+	SignatureAlgorithm      string
+}
+
+// GenerateSAML generates SAML client draft. Entity ID is used as client ID, and assertion consumer service URLs as
+// redirect URIs. If entity ID is not set, client URL (https://domain) will be used.
+func GenerateSAML(domain string, opts SAMLOptions) ClientDraft {
+	clientURL := "https://" + domain
+	entityID := opts.EntityID
+	if entityID == "" {
+		entityID = clientURL
+	}
+	redirectURIs := opts.ACSURLs
+	if len(redirectURIs) == 0 {
+		redirectURIs = []string{clientURL + "/*"}
+	}
+	attrs := map[string]string{
+		"saml.server.signature":    strconv.FormatBool(opts.SignDocuments),
+		"saml.assertion.signature": strconv.FormatBool(opts.SignAssertions),
+		"saml.client.signature":    strconv.FormatBool(opts.ClientSignatureRequired),
+		"saml.encrypt":             strconv.FormatBool(opts.EncryptAssertions),
+		"saml.authnstatement":      "true",
+	}
+	if opts.SignatureAlgorithm != "" {
+		attrs["saml.signature.algorithm"] = opts.SignatureAlgorithm
+	}
+	if opts.NameIDFormat != "" {
+		attrs["saml_name_id_format"] = opts.NameIDFormat
+		attrs["saml_force_name_id_format"] = "true"
+	}
+	if len(opts.ACSURLs) > 0 {
+		attrs["saml_assertion_consumer_url_post"] = opts.ACSURLs[0]
+	}
+	frontChannel := true
+	return ClientDraft{
+		ClientID:           entityID,
+		RootURL:            clientURL,
+		AdminURL:           clientURL,
+		RedirectURIs:       redirectURIs,
+		Name:               domain,
+		Protocol:           ProtocolSAML,
+		Attributes:         attrs,
+		FrontChannelLogout: &frontChannel,
+	}
+}
+
+// SAMLDescriptorURL is a URL to IdP metadata of realm.
+func (k *Keycloak) SAMLDescriptorURL(realm string) string {
+	return k.SAMLURL(realm) + "/descriptor"
+}
+
+// SAMLURL is a single sign-on (and single logout) service URL of realm.
+func (k *Keycloak) SAMLURL(realm string) string {
+	return k.RealmURL(realm) + "/protocol/saml"
+}
+
+type RealmKeys struct {
+	Active map[string]string `json:"active"`
+	Keys   []RealmKey        `json:"keys"`
+}
+
+type RealmKey struct {
+	KID         string `json:"kid"`
+	Algorithm   string `json:"algorithm"`
+	Type        string `json:"type"`
+	Use         string `json:"use"`
+	Status      string `json:"status"`
+	PublicKey   string `json:"publicKey,omitempty"`
+	Certificate string `json:"certificate,omitempty"`
+}
+
+func (k *AuthorizedKeycloak) RealmKeys(ctx context.Context, realm string) (*RealmKeys, error) {
+	var keys RealmKeys
+	if _, err := k.call(ctx, http.MethodGet, k.adminURL(realm, "keys"), nil, &keys); err != nil {
+		return nil, err
+	}
+	return &keys, nil
+}
+
+// SigningCertificate returns PEM encoded certificate of active realm key for the algorithm (ex: RS256).
+func (k *AuthorizedKeycloak) SigningCertificate(ctx context.Context, realm string, algorithm string) (string, error) {
+	keys, err := k.RealmKeys(ctx, realm)
+	if err != nil {
+		return "", err
+	}
+	kid := keys.Active[algorithm]
+	for _, key := range keys.Keys {
+		if key.KID == kid && key.Certificate != "" {
+			return toPEM("CERTIFICATE", key.Certificate), nil
+		}
+	}
+	return "", ErrNoCertificate
+}
+
+// toPEM wraps base64 encoded DER to PEM block.
+func toPEM(blockType string, base64DER string) string {
+	var out strings.Builder
+	out.WriteString("-----BEGIN " + blockType + "-----\n")
+	for len(base64DER) > 64 {
+		out.WriteString(base64DER[:64] + "\n")
+		base64DER = base64DER[64:]
+	}
+	out.WriteString(base64DER + "\n")
+	out.WriteString("-----END " + blockType + "-----\n")
+	return out.String()
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/reddec/keycloak-ext-operator/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateSAML(t *testing.T) {
+	cases := []struct {
+		name         string
+		opts         internal.SAMLOptions
+		clientID     string
+		redirectURIs []string
+		attrs        map[string]string // checked attributes only
+		absent       []string
+	}{
+		{
+			name:         "defaults",
+			clientID:     "https://app.example.com",
+			redirectURIs: []string{"https://app.example.com/*"},
+			attrs: map[string]string{
+				"saml.server.signature":    "false",
+				"saml.assertion.signature": "false",
+				"saml.client.signature":    "false",
+				"saml.encrypt":             "false",
+				"saml.authnstatement":      "true",
+			},
+			absent: []string{"saml_name_id_format", "saml_assertion_consumer_url_post", "saml.signature.algorithm"},
+		},
+		{
+			name: "all options",
+			opts: internal.SAMLOptions{
+				EntityID:                "urn:app",
+				ACSURLs:                 []string{"https://app.example.com/saml/acs", "https://app.example.com/saml/acs2"},
+				NameIDFormat:            "email",
+				SignDocuments:           true,
+				SignAssertions:          true,
+				ClientSignatureRequired: true,
+				EncryptAssertions:       true,
+				SignatureAlgorithm:      "RSA_SHA512",
+			},
+			clientID:     "urn:app",
+			redirectURIs: []string{"https://app.example.com/saml/acs", "https://app.example.com/saml/acs2"},
+			attrs: map[string]string{
+				"saml.server.signature":            "true",
+				"saml.assertion.signature":         "true",
+				"saml.client.signature":            "true",
+				"saml.encrypt":                     "true",
+				"saml.signature.algorithm":         "RSA_SHA512",
+				"saml_name_id_format":              "email",
+				"saml_force_name_id_format":        "true",
+				"saml_assertion_consumer_url_post": "https://app.example.com/saml/acs",
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			draft := internal.GenerateSAML("app.example.com", c.opts)
+			assert.Equal(t, internal.ProtocolSAML, draft.Protocol)
+			assert.Equal(t, c.clientID, draft.ClientID)
+			assert.Equal(t, "https://app.example.com", draft.RootURL)
+			assert.Equal(t, c.redirectURIs, draft.RedirectURIs)
+			for key, value := range c.attrs {
+				assert.Equal(t, value, draft.Attributes[key], key)
+			}
+			for _, key := range c.absent {
+				assert.NotContains(t, draft.Attributes, key)
+			}
+		})
+	}
+}
+
+func TestKeycloak_SAMLDescriptorURL(t *testing.T) {
+	k := &internal.Keycloak{URL: "https://sso.example.com/"}
+	assert.Equal(t, "https://sso.example.com/realms/my%20realm/protocol/saml", k.SAMLURL("my realm"))
+	assert.Equal(t, "https://sso.example.com/realms/test/protocol/saml/descriptor", k.SAMLDescriptorURL("test"))
+}
+
+func TestAuthorizedKeycloak_SigningCertificate(t *testing.T) {
+	der := strings.Repeat("A", 100)
+	keys := internal.RealmKeys{
+		Active: map[string]string{"RS256": "rsa", "HS256": "hmac"},
+		Keys: []internal.RealmKey{
+			{KID: "hmac", Algorithm: "HS256"},
+			{KID: "old", Algorithm: "RS256", Certificate: "old"},
+			{KID: "rsa", Algorithm: "RS256", Certificate: der},
+		},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/realms/master/protocol/openid-connect/token":
+			_ = json.NewEncoder(w).Encode(map[string]any{"token_type": "Bearer", "access_token": "token", "expires_in": 60})
+		case "/admin/realms/test/keys":
+			_ = json.NewEncoder(w).Encode(keys)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+	kClient := (&internal.Keycloak{URL: srv.URL}).Authorize(ctx)
+	require.NoError(t, kClient.Error())
+
+	cases := []struct {
+		algorithm string
+		cert      string
+		err       error
+	}{
+		{
+			algorithm: "RS256",
+			cert:      "-----BEGIN CERTIFICATE-----\n" + der[:64] + "\n" + der[64:] + "\n-----END CERTIFICATE-----\n",
+		},
+		{algorithm: "HS256", err: internal.ErrNoCertificate},
+		{algorithm: "ES256", err: internal.ErrNoCertificate},
+	}
+	for _, c := range cases {
+		t.Run(c.algorithm, func(t *testing.T) {
+			cert, err := kClient.SigningCertificate(ctx, "test", c.algorithm)
+			if c.err != nil {
+				assert.ErrorIs(t, err, c.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, c.cert, cert)
+		})
+	}
+}
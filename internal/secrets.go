@@ -0,0 +1,84 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Client attributes used by Keycloak (22+) to keep previous client secret valid after rotation.
+const (
+	AttrRotatedSecret         = "client.secret.rotated"
+	AttrRotatedCreationTime   = "client.secret.rotated.creation.time"
+	AttrRotatedExpirationTime = "client.secret.rotated.expiration.time"
+)
+
+type credential struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// RegenerateSecret generates new client secret in Keycloak and returns it. Client identified by internal ID
+// (not clientId).
+func (k *AuthorizedKeycloak) RegenerateSecret(ctx context.Context, realm string, clientID string) (string, error) {
+	var cred credential
+	if _, err := k.call(ctx, http.MethodPost, k.adminURL(realm, "clients", clientID, "client-secret"), nil, &cred); err != nil {
+		return "", err
+	}
+	return cred.Value, nil
+}
+
+// RotateSecret generates new client secret in Keycloak and keeps previous secret valid for grace period.
+// Previous secret is recorded before regeneration, so it is not lost if regeneration fails. Rotated secret is not
+// changed if previous is empty. Returns new secret.
+func (k *AuthorizedKeycloak) RotateSecret(ctx context.Context, realm string, clientID string, previous string, grace time.Duration) (string, error) {
+	if previous != "" && grace > 0 {
+		now := time.Now()
+		err := k.Update(ctx, clientID, realm, ClientDraft{
+			Attributes: map[string]string{
+				AttrRotatedSecret:         previous,
+				AttrRotatedCreationTime:   strconv.FormatInt(now.Unix(), 10),
+				AttrRotatedExpirationTime: strconv.FormatInt(now.Add(grace).Unix(), 10),
+			},
+		})
+		if err != nil {
+			return "", fmt.Errorf("keep rotated secret: %w", err)
+		}
+	}
+	return k.RegenerateSecret(ctx, realm, clientID)
+}
+
+// InvalidateRotatedSecret removes previous (rotated) client secret.
+func (k *AuthorizedKeycloak) InvalidateRotatedSecret(ctx context.Context, realm string, clientID string) error {
+	_, err := k.call(ctx, http.MethodDelete, k.adminURL(realm, "clients", clientID, "client-secret", "rotated"), nil, nil)
+	return err
+}
+
+// RotatedSecret returns previous client secret and its expiration time. Empty secret returned if there is no
+// rotated secret.
+func (c *Client) RotatedSecret() (string, time.Time) {
+	secret := c.Attributes[AttrRotatedSecret]
+	if secret == "" {
+		return "", time.Time{}
+	}
+	expiration, _ := strconv.ParseInt(c.Attributes[AttrRotatedExpirationTime], 10, 64)
+	return secret, time.Unix(expiration, 0)
+}
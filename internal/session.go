@@ -0,0 +1,169 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Authorization methods.
+const (
+	AuthPassword          = "password"
+	AuthClientCredentials = "clientCredentials"
+)
+
+// tokenSkew is how long before expiration cached token is refreshed.
+const tokenSkew = 30 * time.Second
+
+var ErrInvalidCA = errors.New("no certificates in CA bundle")
+
+// NewKeycloak returns Keycloak instance with own HTTP client (according to TLS settings) and cache of access token.
+func NewKeycloak(cfg Keycloak) (*Keycloak, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.InsecureSkipVerify || cfg.CACertificate != "" {
+		transport.TLSClientConfig = &tls.Config{
+			InsecureSkipVerify: cfg.InsecureSkipVerify, //nolint:gosec
+		}
+	}
+	if cfg.CACertificate != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(cfg.CACertificate)) {
+			return nil, ErrInvalidCA
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+	cfg.session = &session{client: &http.Client{Transport: transport}}
+	return &cfg, nil
+}
+
+// session of Keycloak instance: HTTP client and cached access token. Nil session is valid and means no caching and
+// default HTTP client.
+type session struct {
+	client  *http.Client
+	lock    sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (s *session) cached() (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.token, s.token != "" && time.Now().Before(s.expires)
+}
+
+func (s *session) reset() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.token = ""
+}
+
+func (s *session) save(token string, ttl time.Duration) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.token = token
+	s.expires = time.Now().Add(ttl - tokenSkew)
+}
+
+func (k *Keycloak) httpClient() *http.Client {
+	if k.session == nil {
+		return http.DefaultClient
+	}
+	return k.session.client
+}
+
+// Pool of Keycloak instances with authorized sessions. Instance (and its session) is re-created once configuration
+// changed, and idle connections of replaced instance are closed.
+type Pool struct {
+	lock  sync.Mutex
+	items map[string]pooledKeycloak
+}
+
+type pooledKeycloak struct {
+	fingerprint string
+	instance    *Keycloak
+}
+
+// Get instance by key (unique name of instance). Instance created if not exists or configuration changed.
+func (p *Pool) Get(key string, cfg Keycloak) (*Keycloak, error) {
+	fingerprint := cfg.fingerprint()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if item, ok := p.items[key]; ok && item.fingerprint == fingerprint {
+		return item.instance, nil
+	}
+	instance, err := NewKeycloak(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if p.items == nil {
+		p.items = make(map[string]pooledKeycloak)
+	}
+	if item, ok := p.items[key]; ok {
+		item.instance.closeIdle()
+	}
+	p.items[key] = pooledKeycloak{fingerprint: fingerprint, instance: instance}
+	return instance, nil
+}
+
+// Forget removes instance by key (ex: once instance resource is deleted). Unknown key is ignored.
+func (p *Pool) Forget(key string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if item, ok := p.items[key]; ok {
+		item.instance.closeIdle()
+		delete(p.items, key)
+	}
+}
+
+// Len returns number of instances in pool.
+func (p *Pool) Len() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.items)
+}
+
+func (k *Keycloak) closeIdle() {
+	if k.session != nil {
+		k.session.client.CloseIdleConnections()
+	}
+}
+
+func (k *Keycloak) fingerprint() string {
+	data, err := json.Marshal(k)
+	if err != nil {
+		panic(err) // plain struct, should never happen
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
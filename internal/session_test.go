@@ -0,0 +1,186 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/reddec/keycloak-ext-operator/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPool_Get(t *testing.T) {
+	base := internal.Keycloak{URL: "https://keycloak.example.com", User: "admin", Password: "admin"}
+	cases := []struct {
+		name   string
+		key    string
+		modify func(cfg *internal.Keycloak)
+		reused bool
+		size   int
+	}{
+		{
+			name:   "same configuration",
+			key:    "instance",
+			modify: func(cfg *internal.Keycloak) {},
+			reused: true,
+			size:   1,
+		},
+		{
+			name:   "other key",
+			key:    "other",
+			modify: func(cfg *internal.Keycloak) {},
+			size:   2,
+		},
+		{
+			name:   "changed password",
+			key:    "instance",
+			modify: func(cfg *internal.Keycloak) { cfg.Password = "rotated" },
+			size:   1,
+		},
+		{
+			name:   "changed TLS settings",
+			key:    "instance",
+			modify: func(cfg *internal.Keycloak) { cfg.InsecureSkipVerify = true },
+			size:   1,
+		},
+		{
+			name: "changed realm public URLs",
+			key:  "instance",
+			modify: func(cfg *internal.Keycloak) {
+				cfg.RealmPublicURLs = map[string]string{"test": "https://sso.example.com"}
+			},
+			size: 1,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var pool internal.Pool
+			first, err := pool.Get("instance", base)
+			require.NoError(t, err)
+
+			cfg := base
+			c.modify(&cfg)
+			second, err := pool.Get(c.key, cfg)
+			require.NoError(t, err)
+			assert.Equal(t, c.reused, first == second)
+			assert.Equal(t, cfg.Password, second.Password)
+			assert.Equal(t, c.size, pool.Len(), "replaced instance is evicted")
+		})
+	}
+
+	t.Run("invalid configuration keeps previous instance", func(t *testing.T) {
+		var pool internal.Pool
+		first, err := pool.Get("instance", base)
+		require.NoError(t, err)
+
+		cfg := base
+		cfg.CACertificate = "not a certificate"
+		_, err = pool.Get("instance", cfg)
+		assert.ErrorIs(t, err, internal.ErrInvalidCA)
+
+		same, err := pool.Get("instance", base)
+		require.NoError(t, err)
+		assert.Same(t, first, same)
+	})
+}
+
+func TestPool_Forget(t *testing.T) {
+	var pool internal.Pool
+	first, err := pool.Get("KeycloakInstance/team-a/sso", internal.Keycloak{URL: "https://sso.team-a.example.com"})
+	require.NoError(t, err)
+	_, err = pool.Get("ClusterKeycloakInstance/shared", internal.Keycloak{URL: "https://sso.example.com"})
+	require.NoError(t, err)
+
+	pool.Forget("KeycloakInstance/team-a/sso")
+	pool.Forget("KeycloakInstance/team-b/sso") // unknown key
+	assert.Equal(t, 1, pool.Len())
+
+	again, err := pool.Get("KeycloakInstance/team-a/sso", internal.Keycloak{URL: "https://sso.team-a.example.com"})
+	require.NoError(t, err)
+	assert.NotSame(t, first, again, "forgotten instance is re-created")
+	assert.Equal(t, 2, pool.Len())
+}
+
+func TestAuthorizedKeycloak_unauthorized(t *testing.T) {
+	operations := map[string]func(ctx context.Context, k *internal.AuthorizedKeycloak) error{
+		"realm keys": func(ctx context.Context, k *internal.AuthorizedKeycloak) error {
+			_, err := k.RealmKeys(ctx, "test")
+			return err
+		},
+		"get client": func(ctx context.Context, k *internal.AuthorizedKeycloak) error {
+			_, err := k.Get(ctx, "test", "client-id")
+			return err
+		},
+		"delete client": func(ctx context.Context, k *internal.AuthorizedKeycloak) error {
+			return k.Delete(ctx, "test", "client-id")
+		},
+	}
+	for name, operation := range operations {
+		t.Run(name, func(t *testing.T) {
+			var tokens, rejected int
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/realms/master/protocol/openid-connect/token":
+					tokens++
+					_ = json.NewEncoder(w).Encode(map[string]any{"token_type": "Bearer", "access_token": fmt.Sprint("token-", tokens), "expires_in": 300})
+					return
+				}
+				if r.Header.Get("Authorization") == "Bearer token-1" {
+					rejected++
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				switch r.URL.Path {
+				case "/admin/realms/test/keys":
+					_ = json.NewEncoder(w).Encode(internal.RealmKeys{})
+				case "/admin/realms/test/clients/client-id":
+					if r.Method == http.MethodGet {
+						_ = json.NewEncoder(w).Encode(internal.ClientDetails{Client: internal.Client{ID: "client-id"}})
+					}
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer srv.Close()
+			k, err := internal.NewKeycloak(internal.Keycloak{URL: srv.URL, User: "admin", Password: "admin"})
+			require.NoError(t, err)
+			ctx := context.Background()
+
+			steps := []struct {
+				name   string
+				failed bool
+				tokens int
+			}{
+				{name: "revoked token", failed: true, tokens: 1},
+				{name: "re-authenticated", tokens: 2},
+				{name: "cached", tokens: 2},
+			}
+			for _, step := range steps {
+				err := operation(ctx, k.Authorize(ctx))
+				assert.Equal(t, step.failed, err != nil, step.name)
+				assert.Equal(t, step.tokens, tokens, step.name)
+			}
+			assert.Equal(t, 1, rejected)
+		})
+	}
+}
@@ -17,37 +17,36 @@ limitations under the License.
 package internal
 
 type Client struct {
-	ID                        string   `json:"id"`
-	ClientID                  string   `json:"clientId"`
-	Name                      string   `json:"name"`
-	Description               string   `json:"description,omitempty"`
-	AdminURL                  string   `json:"adminUrl,omitempty"`
-	RootURL                   string   `json:"rootUrl"`
-	BaseURL                   string   `json:"baseUrl"`
-	SurrogateAuthRequired     bool     `json:"surrogateAuthRequired"`
-	Enabled                   bool     `json:"enabled"`
-	AlwaysDisplayInConsole    bool     `json:"alwaysDisplayInConsole"`
-	ClientAuthenticatorType   string   `json:"clientAuthenticatorType"`
-	RedirectURIs              []string `json:"redirectUris"`
-	WebOrigins                []string `json:"webOrigins"`
-	NotBefore                 int      `json:"notBefore"`
-	BearerOnly                bool     `json:"bearerOnly"`
-	ConsentRequired           bool     `json:"consentRequired"`
-	StandardFlowEnabled       bool     `json:"standardFlowEnabled"`
-	ImplicitFlowEnabled       bool     `json:"implicitFlowEnabled"`
-	DirectAccessGrantsEnabled bool     `json:"directAccessGrantsEnabled"`
-	ServiceAccountsEnabled    bool     `json:"serviceAccountsEnabled"`
-	PublicClient              bool     `json:"publicClient"`
-	FrontChannelLogout        bool     `json:"frontchannelLogout"`
-	Protocol                  string   `json:"protocol"`
-	Attributes                struct {
-		PostLogoutRedirectUris string `json:"post.logout.redirect.uris"`
-	} `json:"attributes"`
-	FullScopeAllowed          bool     `json:"fullScopeAllowed"`
-	NodeReRegistrationTimeout int      `json:"nodeReRegistrationTimeout"`
-	DefaultClientScopes       []string `json:"defaultClientScopes"`
-	OptionalClientScopes      []string `json:"optionalClientScopes"`
-	Access                    struct {
+	ID                           string            `json:"id"`
+	ClientID                     string            `json:"clientId"`
+	Name                         string            `json:"name"`
+	Description                  string            `json:"description,omitempty"`
+	AdminURL                     string            `json:"adminUrl,omitempty"`
+	RootURL                      string            `json:"rootUrl"`
+	BaseURL                      string            `json:"baseUrl"`
+	SurrogateAuthRequired        bool              `json:"surrogateAuthRequired"`
+	Enabled                      bool              `json:"enabled"`
+	AlwaysDisplayInConsole       bool              `json:"alwaysDisplayInConsole"`
+	ClientAuthenticatorType      string            `json:"clientAuthenticatorType"`
+	RedirectURIs                 []string          `json:"redirectUris"`
+	WebOrigins                   []string          `json:"webOrigins"`
+	NotBefore                    int               `json:"notBefore"`
+	BearerOnly                   bool              `json:"bearerOnly"`
+	ConsentRequired              bool              `json:"consentRequired"`
+	StandardFlowEnabled          bool              `json:"standardFlowEnabled"`
+	ImplicitFlowEnabled          bool              `json:"implicitFlowEnabled"`
+	DirectAccessGrantsEnabled    bool              `json:"directAccessGrantsEnabled"`
+	ServiceAccountsEnabled       bool              `json:"serviceAccountsEnabled"`
+	AuthorizationServicesEnabled bool              `json:"authorizationServicesEnabled"`
+	PublicClient                 bool              `json:"publicClient"`
+	FrontChannelLogout           bool              `json:"frontchannelLogout"`
+	Protocol                     string            `json:"protocol"`
+	Attributes                   map[string]string `json:"attributes"`
+	FullScopeAllowed             bool              `json:"fullScopeAllowed"`
+	NodeReRegistrationTimeout    int               `json:"nodeReRegistrationTimeout"`
+	DefaultClientScopes          []string          `json:"defaultClientScopes"`
+	OptionalClientScopes         []string          `json:"optionalClientScopes"`
+	Access                       struct {
 		View      bool `json:"view"`
 		Configure bool `json:"configure"`
 		Manage    bool `json:"manage"`
@@ -58,3 +57,54 @@ type ClientDetails struct {
 	Client
 	Secret string `json:"secret"`
 }
+
+type User struct {
+	ID              string              `json:"id,omitempty"`
+	Username        string              `json:"username"`
+	Email           string              `json:"email,omitempty"`
+	EmailVerified   *bool               `json:"emailVerified,omitempty"`
+	Enabled         *bool               `json:"enabled,omitempty"`
+	Attributes      map[string][]string `json:"attributes,omitempty"`
+	RequiredActions []string            `json:"requiredActions,omitempty"`
+}
+
+type Group struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+type Role struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Composite   bool   `json:"composite"`
+	ClientRole  bool   `json:"clientRole"`
+	ContainerID string `json:"containerId,omitempty"`
+}
+
+type IdentityProvider struct {
+	Alias                     string            `json:"alias"`
+	DisplayName               string            `json:"displayName,omitempty"`
+	ProviderID                string            `json:"providerId"`
+	Enabled                   bool              `json:"enabled"`
+	TrustEmail                bool              `json:"trustEmail"`
+	FirstBrokerLoginFlowAlias string            `json:"firstBrokerLoginFlowAlias,omitempty"`
+	Config                    map[string]string `json:"config,omitempty"`
+}
+
+type IdentityProviderMapper struct {
+	ID                     string            `json:"id,omitempty"`
+	Name                   string            `json:"name"`
+	IdentityProviderAlias  string            `json:"identityProviderAlias"`
+	IdentityProviderMapper string            `json:"identityProviderMapper"`
+	Config                 map[string]string `json:"config,omitempty"`
+}
+
+type ProtocolMapper struct {
+	ID             string            `json:"id,omitempty"`
+	Name           string            `json:"name"`
+	Protocol       string            `json:"protocol"`
+	ProtocolMapper string            `json:"protocolMapper"`
+	Config         map[string]string `json:"config,omitempty"`
+}
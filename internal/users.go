@@ -0,0 +1,144 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+
+// FindUser by exact username.
+func (k *AuthorizedKeycloak) FindUser(ctx context.Context, realm string, username string) (*User, error) {
+	var list []User
+	href := k.adminURL(realm, "users") + "?" + url.Values{
+		"username": []string{username},
+		"exact":    []string{"true"},
+	}.Encode()
+	if _, err := k.call(ctx, http.MethodGet, href, nil, &list); err != nil {
+		return nil, err
+	}
+	for _, u := range list {
+		// username in Keycloak is case-insensitive and always stored in lower case
+		if strings.EqualFold(u.Username, username) {
+			return &u, nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+// CreateUser and return ID.
+func (k *AuthorizedKeycloak) CreateUser(ctx context.Context, realm string, user User) (string, error) {
+	headers, err := k.call(ctx, http.MethodPost, k.adminURL(realm, "users"), user, nil)
+	if err != nil {
+		return "", err
+	}
+	return path.Base(headers.Get("Location")), nil
+}
+
+func (k *AuthorizedKeycloak) UpdateUser(ctx context.Context, realm string, id string, user User) error {
+	_, err := k.call(ctx, http.MethodPut, k.adminURL(realm, "users", id), user, nil)
+	return err
+}
+
+func (k *AuthorizedKeycloak) DeleteUser(ctx context.Context, realm string, id string) error {
+	_, err := k.call(ctx, http.MethodDelete, k.adminURL(realm, "users", id), nil, nil)
+	return err
+}
+
+// SetPassword replaces user password. If temporary is set, user will be asked to change password on next login.
+func (k *AuthorizedKeycloak) SetPassword(ctx context.Context, realm string, id string, password string, temporary bool) error {
+	_, err := k.call(ctx, http.MethodPut, k.adminURL(realm, "users", id, "reset-password"), map[string]any{
+		"type":      "password",
+		"value":     password,
+		"temporary": temporary,
+	}, nil)
+	return err
+}
+
+// UserGroups returns all groups where user is a member.
+func (k *AuthorizedKeycloak) UserGroups(ctx context.Context, realm string, id string) ([]Group, error) {
+	var list []Group
+	_, err := k.call(ctx, http.MethodGet, k.adminURL(realm, "users", id, "groups"), nil, &list)
+	return list, err
+}
+
+// GroupByPath finds group by full path (ex: /parent/child).
+func (k *AuthorizedKeycloak) GroupByPath(ctx context.Context, realm string, groupPath string) (*Group, error) {
+	href := k.adminURL(realm, "group-by-path")
+	for _, part := range strings.Split(strings.Trim(groupPath, "/"), "/") {
+		href += "/" + url.PathEscape(part)
+	}
+	var group Group
+	if _, err := k.call(ctx, http.MethodGet, href, nil, &group); err != nil {
+		return nil, fmt.Errorf("get group %q: %w", groupPath, err)
+	}
+	return &group, nil
+}
+
+func (k *AuthorizedKeycloak) JoinGroup(ctx context.Context, realm string, id string, groupID string) error {
+	_, err := k.call(ctx, http.MethodPut, k.adminURL(realm, "users", id, "groups", groupID), nil, nil)
+	return err
+}
+
+// RealmRole by name.
+func (k *AuthorizedKeycloak) RealmRole(ctx context.Context, realm string, name string) (*Role, error) {
+	var role Role
+	if _, err := k.call(ctx, http.MethodGet, k.adminURL(realm, "roles", name), nil, &role); err != nil {
+		return nil, fmt.Errorf("get realm role %q: %w", name, err)
+	}
+	return &role, nil
+}
+
+// ClientRole by name. Client identified by internal ID (not clientId).
+func (k *AuthorizedKeycloak) ClientRole(ctx context.Context, realm string, clientID string, name string) (*Role, error) {
+	var role Role
+	if _, err := k.call(ctx, http.MethodGet, k.adminURL(realm, "clients", clientID, "roles", name), nil, &role); err != nil {
+		return nil, fmt.Errorf("get client role %q: %w", name, err)
+	}
+	return &role, nil
+}
+
+// UserRealmRoles returns realm roles directly mapped to user.
+func (k *AuthorizedKeycloak) UserRealmRoles(ctx context.Context, realm string, id string) ([]Role, error) {
+	var list []Role
+	_, err := k.call(ctx, http.MethodGet, k.adminURL(realm, "users", id, "role-mappings", "realm"), nil, &list)
+	return list, err
+}
+
+// UserClientRoles returns client roles directly mapped to user. Client identified by internal ID (not clientId).
+func (k *AuthorizedKeycloak) UserClientRoles(ctx context.Context, realm string, id string, clientID string) ([]Role, error) {
+	var list []Role
+	_, err := k.call(ctx, http.MethodGet, k.adminURL(realm, "users", id, "role-mappings", "clients", clientID), nil, &list)
+	return list, err
+}
+
+func (k *AuthorizedKeycloak) AddUserRealmRoles(ctx context.Context, realm string, id string, roles []Role) error {
+	_, err := k.call(ctx, http.MethodPost, k.adminURL(realm, "users", id, "role-mappings", "realm"), roles, nil)
+	return err
+}
+
+func (k *AuthorizedKeycloak) AddUserClientRoles(ctx context.Context, realm string, id string, clientID string, roles []Role) error {
+	_, err := k.call(ctx, http.MethodPost, k.adminURL(realm, "users", id, "role-mappings", "clients", clientID), roles, nil)
+	return err
+}
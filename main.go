@@ -18,7 +18,10 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/reddec/keycloak-ext-operator/internal"
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
@@ -26,11 +29,14 @@ import (
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	keycloakv1alpha1 "github.com/reddec/keycloak-ext-operator/api/v1alpha1"
 	"github.com/reddec/keycloak-ext-operator/controllers"
@@ -50,22 +56,62 @@ func init() {
 }
 
 func main() {
+	var clusterID string
+	flag.StringVar(&clusterID, "cluster-id", os.Getenv("CLUSTER_ID"), "Identifier of cluster in ownership markers of Keycloak clients")
+	var prefixClientID bool
+	flag.BoolVar(&prefixClientID, "prefix-client-id", false, "Prefix clientId of new openid-connect clients by cluster ID (requires --cluster-id)")
+	var deletionPolicy string
+	flag.StringVar(&deletionPolicy, "default-deletion-policy", controllers.DeletionPolicyDelete, "Default deletion policy of Keycloak clients: Delete, Retain or Disable")
+	var deletionTimeout time.Duration
+	flag.DurationVar(&deletionTimeout, "deletion-timeout", 0, "Timeout after which finalizer of KeycloakClient is removed even if client can not be removed from Keycloak (0 - no timeout)")
+	var sweepInterval time.Duration
+	var orphanPolicy string
+	var orphanDryRun bool
+	flag.DurationVar(&sweepInterval, "orphan-sweep-interval", time.Hour, "Interval of orphaned Keycloak clients sweep (0 - disabled)")
+	flag.StringVar(&orphanPolicy, "orphan-policy", controllers.OrphanPolicyReport, "What to do with orphaned Keycloak clients: Report or Delete")
+	flag.BoolVar(&orphanDryRun, "orphan-dry-run", false, "Only report orphaned Keycloak clients which would be removed")
 	opts := zap.Options{
 		Development: true,
 	}
 	opts.BindFlags(flag.CommandLine)
 	flag.Parse()
 
-	kClient, err := internal.FromEnv()
-	if err != nil {
-		panic(err)
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+
+	switch deletionPolicy {
+	case controllers.DeletionPolicyDelete, controllers.DeletionPolicyRetain, controllers.DeletionPolicyDisable:
+	default:
+		setupLog.Error(nil, "unknown deletion policy", "policy", deletionPolicy)
+		os.Exit(1)
+	}
+	if prefixClientID && clusterID == "" {
+		setupLog.Error(nil, "--prefix-client-id requires --cluster-id")
+		os.Exit(1)
+	}
+	if orphanPolicy != controllers.OrphanPolicyReport && orphanPolicy != controllers.OrphanPolicyDelete {
+		setupLog.Error(nil, "unknown orphan policy", "policy", orphanPolicy)
+		os.Exit(1)
+	}
+	if orphanPolicy == controllers.OrphanPolicyDelete && clusterID == "" {
+		// without cluster identifier clients of other clusters sharing Keycloak look like orphans
+		setupLog.Error(nil, "--orphan-policy="+controllers.OrphanPolicyDelete+" requires --cluster-id")
+		os.Exit(1)
 	}
 
-	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	// default instance is optional, if all resources refer to Keycloak instances
+	var kClient *internal.Keycloak
+	if os.Getenv("KEYCLOAK_URL") != "" {
+		defaultInstance, err := internal.FromEnv()
+		if err != nil {
+			setupLog.Error(err, "invalid configuration of default instance")
+			os.Exit(1)
+		}
+		kClient = defaultInstance
+	}
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:         scheme,
-		Port:           9443,
+		WebhookServer:  webhook.NewServer(webhook.Options{Port: 9443}),
 		LeaderElection: false,
 	})
 	if err != nil {
@@ -73,14 +119,106 @@ func main() {
 		os.Exit(1)
 	}
 
+	instances := &controllers.Instances{
+		Client:  mgr.GetClient(),
+		Default: kClient,
+	}
+	if kClient != nil {
+		instances.DefaultCredentials, err = credentialsSource(mgr.GetClient())
+		if err != nil {
+			setupLog.Error(err, "invalid credentials of default instance")
+			os.Exit(1)
+		}
+		if instances.DefaultCredentials == nil && (kClient.User == "" || kClient.Password == "") {
+			setupLog.Error(nil, "KEYCLOAK_USER and KEYCLOAK_PASSWORD are required")
+			os.Exit(1)
+		}
+	}
+
+	if err = (&controllers.KeycloakInstanceReconciler{
+		Client:    mgr.GetClient(),
+		Instances: instances,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "KeycloakInstance")
+		os.Exit(1)
+	}
+
+	policies := &controllers.AccessPolicies{
+		Client: mgr.GetClient(),
+	}
+
 	if err = (&controllers.KeycloakClientReconciler{
-		Client:   mgr.GetClient(),
-		Scheme:   mgr.GetScheme(),
-		Keycloak: kClient,
+		Client:                mgr.GetClient(),
+		Scheme:                mgr.GetScheme(),
+		Instances:             instances,
+		Policies:              policies,
+		Recorder:              mgr.GetEventRecorderFor("keycloakclient-controller"),
+		ClusterID:             clusterID,
+		PrefixClientID:        prefixClientID,
+		DefaultDeletionPolicy: deletionPolicy,
+		DeletionTimeout:       deletionTimeout,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "KeycloakClient")
 		os.Exit(1)
 	}
+	if err = (&controllers.KeycloakUserReconciler{
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		Instances: instances,
+		Policies:  policies,
+		Recorder:  mgr.GetEventRecorderFor("keycloakuser-controller"),
+		ClusterID: clusterID,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "KeycloakUser")
+		os.Exit(1)
+	}
+	if err = (&controllers.KeycloakIdentityProviderReconciler{
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		Instances: instances,
+		Policies:  policies,
+		Recorder:  mgr.GetEventRecorderFor("keycloakidentityprovider-controller"),
+		ClusterID: clusterID,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "KeycloakIdentityProvider")
+		os.Exit(1)
+	}
+	if sweepInterval > 0 {
+		if err = (&controllers.OrphanSweeper{
+			Client:    mgr.GetClient(),
+			Instances: instances,
+			Recorder:  mgr.GetEventRecorderFor("keycloak-orphan-sweeper"),
+			ClusterID: clusterID,
+			Interval:  sweepInterval,
+			Policy:    orphanPolicy,
+			DryRun:    orphanDryRun,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create orphan sweeper")
+			os.Exit(1)
+		}
+	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&controllers.KeycloakClientValidator{
+			Client:   mgr.GetClient(),
+			Policies: policies,
+		}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "KeycloakClient")
+			os.Exit(1)
+		}
+		if err = (&controllers.KeycloakIdentityProviderValidator{
+			Client:   mgr.GetClient(),
+			Policies: policies,
+		}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "KeycloakIdentityProvider")
+			os.Exit(1)
+		}
+		if err = (&controllers.KeycloakUserValidator{
+			Policies: policies,
+		}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "KeycloakUser")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -98,3 +236,23 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// credentialsSource of default instance for hot-reload: mounted directory (KEYCLOAK_CREDENTIALS_DIR) or
+// secret (KEYCLOAK_CREDENTIALS_SECRET as namespace/name). Returns nil if credentials are static (from environment).
+func credentialsSource(kube client.Client) (internal.CredentialsSource, error) {
+	if dir := os.Getenv("KEYCLOAK_CREDENTIALS_DIR"); dir != "" {
+		return internal.FileCredentials(dir), nil
+	}
+	ref := os.Getenv("KEYCLOAK_CREDENTIALS_SECRET")
+	if ref == "" {
+		return nil, nil
+	}
+	namespace, name, ok := strings.Cut(ref, "/")
+	if !ok || namespace == "" || name == "" {
+		return nil, fmt.Errorf("KEYCLOAK_CREDENTIALS_SECRET should be in format namespace/name, got %q", ref)
+	}
+	return &controllers.SecretCredentials{
+		Client: kube,
+		Name:   types.NamespacedName{Namespace: namespace, Name: name},
+	}, nil
+}